@@ -3,40 +3,154 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"github.com/TaeKwonZeus/pva/network"
 	"io/fs"
 	"log"
 	"os"
+	"slices"
 )
 
 type Config struct {
 	Port int `json:"port"`
 
 	Scan struct {
+		// Netmask gives the subnet of the server that's scanned when no targets are configured
 		Netmask  string `json:"netmask"`
 		Interval int    `json:"interval"`
 		Timeout  int    `json:"timeout"`
+		// Scanners are the discovery methods used on targets that don't list their own
+		Scanners []string `json:"scanners"`
+		// Ports are the TCP ports probed on targets that don't list their own
+		Ports []int `json:"ports"`
+		// HistoryRetention is how many days scan history is kept
+		HistoryRetention int `json:"historyRetention"`
+		// Targets are scanned independently of each other
+		Targets []struct {
+			Name string `json:"name"`
+			// Ranges are CIDR prefixes ("10.0.0.0/24"), address ranges ("10.0.1.10-10.0.1.50") or addresses
+			Ranges  []string `json:"ranges"`
+			Exclude []string `json:"exclude"`
+			// Interfaces are where IPv6 hosts are discovered by multicast, as a /64 can't be scanned
+			Interfaces []string `json:"interfaces"`
+			Scanners   []string `json:"scanners"`
+			Ports      []int    `json:"ports"`
+			// Interval and Timeout are in seconds, 0 to use the ones above
+			Interval int `json:"interval"`
+			Timeout  int `json:"timeout"`
+		} `json:"targets"`
 	} `json:"scan"`
 
+	Trash struct {
+		// Retention is how many days deleted objects are kept before being purged
+		Retention int `json:"retention"`
+	} `json:"trash"`
+
+	Checkout struct {
+		// Duration is how many seconds a checkout lasts unless requested otherwise
+		Duration int `json:"duration"`
+		// MaxDuration is the longest checkout in seconds that can be requested
+		MaxDuration int `json:"maxDuration"`
+	} `json:"checkout"`
+
+	Access struct {
+		// MaxDuration is the longest time-limited vault access in seconds that can be requested or granted
+		MaxDuration int `json:"maxDuration"`
+	} `json:"access"`
+
+	Attachments struct {
+		// MaxSize is the largest attachment in MiB that can be uploaded
+		MaxSize int `json:"maxSize"`
+		// Quota is how many MiB of attachments each user can store, 0 for unlimited
+		Quota int `json:"quota"`
+		// Deduplicate stores equal attachments only once across all documents by deriving their keys from
+		// their content
+		Deduplicate bool `json:"deduplicate"`
+	} `json:"attachments"`
+
+	Alerts struct {
+		// NewDevices alerts on MACs never seen before that don't belong to a saved device
+		NewDevices bool `json:"newDevices"`
+		// OfflineAfter is how many minutes a saved device can go unseen before alerting, 0 to disable
+		OfflineAfter int  `json:"offlineAfter"`
+		MACChanges   bool `json:"macChanges"`
+		// Alerts are delivered to every configured receiver, and can be listed through the API regardless
+		Webhook string `json:"webhook"`
+		SMTP    struct {
+			Addr     string   `json:"addr"`
+			Username string   `json:"username"`
+			Password string   `json:"password"`
+			From     string   `json:"from"`
+			To       []string `json:"to"`
+		} `json:"smtp"`
+		Syslog struct {
+			Enabled bool `json:"enabled"`
+			// Network and Addr give a remote daemon, leave them empty for the local one
+			Network string `json:"network"`
+			Addr    string `json:"addr"`
+		} `json:"syslog"`
+	} `json:"alerts"`
+
 	path string
 }
 
 func defaultConfig() *Config {
-	return &Config{
+	config := &Config{
 		Port: 5101,
 		Scan: struct {
-			Netmask  string `json:"netmask"`
-			Interval int    `json:"interval"`
-			Timeout  int    `json:"timeout"`
+			Netmask          string   `json:"netmask"`
+			Interval         int      `json:"interval"`
+			Timeout          int      `json:"timeout"`
+			Scanners         []string `json:"scanners"`
+			Ports            []int    `json:"ports"`
+			HistoryRetention int      `json:"historyRetention"`
+			Targets          []struct {
+				Name       string   `json:"name"`
+				Ranges     []string `json:"ranges"`
+				Exclude    []string `json:"exclude"`
+				Interfaces []string `json:"interfaces"`
+				Scanners   []string `json:"scanners"`
+				Ports      []int    `json:"ports"`
+				Interval   int      `json:"interval"`
+				Timeout    int      `json:"timeout"`
+			} `json:"targets"`
 		}{
 			Netmask:  "255.255.255.0",
 			Interval: 120,
 			Timeout:  1,
+			Scanners: slices.Clone(network.DefaultScanners),
+			Ports:    slices.Clone(network.DefaultPorts),
+
+			HistoryRetention: 90,
+		},
+		Trash: struct {
+			Retention int `json:"retention"`
+		}{
+			Retention: 30,
+		},
+		Checkout: struct {
+			Duration    int `json:"duration"`
+			MaxDuration int `json:"maxDuration"`
+		}{
+			Duration:    3600,
+			MaxDuration: 4 * 3600,
+		},
+		Attachments: struct {
+			MaxSize     int  `json:"maxSize"`
+			Quota       int  `json:"quota"`
+			Deduplicate bool `json:"deduplicate"`
+		}{
+			MaxSize: 100,
 		},
 	}
+	config.Access.MaxDuration = 90 * 24 * 3600
+	config.Alerts.NewDevices = true
+	config.Alerts.MACChanges = true
+	return config
 }
 
 func NewConfig(path string) (*Config, error) {
-	config := new(Config)
+	// Fields missing from the file keep their default values
+	config := defaultConfig()
 	config.path = path
 
 	file, err := os.ReadFile(path)
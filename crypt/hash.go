@@ -0,0 +1,21 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+)
+
+// NewKeyedHash returns an HMAC-SHA256 keyed with secret, so hashes can't be computed or confirmed without it.
+func NewKeyedHash(secret []byte) hash.Hash {
+	return hmac.New(sha256.New, secret)
+}
+
+// ConvergentKey derives an AES key from the keyed hash of some content. Equal content gets the same key, which
+// lets it be encrypted once and shared by everyone who can produce it.
+func ConvergentKey(secret, contentHash []byte) []byte {
+	h := NewKeyedHash(secret)
+	h.Write([]byte("convergent key\x00"))
+	h.Write(contentHash)
+	return h.Sum(nil)[:aesKeySize]
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"golang.org/x/crypto/argon2"
 )
 
@@ -16,8 +17,12 @@ const (
 	saltSize   = 32
 	aesKeySize = 32
 	rsaKeySize = 4096
+	nameSize   = 16
 )
 
+// AesOverhead is how much longer AesEncrypt's output is than the plaintext.
+const AesOverhead = nonceSize + 16
+
 //
 //type Keys struct {
 //	signingKey []byte
@@ -86,6 +91,15 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
+// RandomName generates a random hex string for naming files.
+func RandomName() (string, error) {
+	name := make([]byte, nameSize)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(name), nil
+}
+
 func DeriveKey(password string, salt []byte) []byte {
 	return argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, aesKeySize)
 }
@@ -0,0 +1,204 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Streams are split into chunks that are sealed separately, so they can be encrypted and decrypted without
+// holding the whole plaintext in memory and read starting at any offset. Each chunk's nonce is the stream's
+// random base nonce XORed with the chunk index, and the last chunk is authenticated as such so truncated
+// streams fail to decrypt.
+const (
+	chunkSize       = 64 * 1024
+	sealedChunkSize = chunkSize + 16
+)
+
+var ErrStreamCorrupted = errors.New("encrypted stream is corrupted")
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, base)
+	binary.BigEndian.PutUint64(nonce[nonceSize-8:], binary.BigEndian.Uint64(base[nonceSize-8:])^index)
+	return nonce
+}
+
+func chunkAd(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+type encryptWriter struct {
+	w     io.Writer
+	gcm   cipher.AEAD
+	base  []byte
+	index uint64
+	buf   []byte
+}
+
+// NewEncryptWriter returns a writer encrypting everything written to it into w. Close must be called to
+// write the final chunk; it doesn't close w.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	base := make([]byte, nonceSize)
+	if _, err = rand.Read(base); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(base); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, gcm: gcm, base: base, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.gcm.Seal(nil, chunkNonce(e.base, e.index), e.buf, chunkAd(last))
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, since until then it could be the last one
+		if len(e.buf) == chunkSize {
+			if err = e.seal(false); err != nil {
+				return
+			}
+		}
+		c := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// DecryptReader decrypts a stream written by NewEncryptWriter, supporting seeking.
+type DecryptReader struct {
+	r      io.ReaderAt
+	gcm    cipher.AEAD
+	base   []byte
+	chunks int64
+	size   int64
+	offset int64
+
+	// Last decrypted chunk
+	index int64
+	chunk []byte
+}
+
+// NewDecryptReader reads an encrypted stream of the given size from r.
+func NewDecryptReader(r io.ReaderAt, size int64, key []byte) (*DecryptReader, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := size - nonceSize
+	if payload < 16 {
+		return nil, ErrStreamCorrupted
+	}
+	chunks := (payload + sealedChunkSize - 1) / sealedChunkSize
+	if payload-(chunks-1)*sealedChunkSize < 16 {
+		return nil, ErrStreamCorrupted
+	}
+
+	base := make([]byte, nonceSize)
+	if _, err = r.ReadAt(base, 0); err != nil {
+		return nil, err
+	}
+
+	return &DecryptReader{
+		r:      r,
+		gcm:    gcm,
+		base:   base,
+		chunks: chunks,
+		size:   payload - chunks*16,
+		index:  -1,
+	}, nil
+}
+
+// Size returns the size of the plaintext.
+func (d *DecryptReader) Size() int64 {
+	return d.size
+}
+
+func (d *DecryptReader) loadChunk(index int64) error {
+	if index == d.index {
+		return nil
+	}
+
+	sealed := make([]byte, sealedChunkSize)
+	n, err := d.r.ReadAt(sealed, nonceSize+index*sealedChunkSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	d.chunk, err = d.gcm.Open(sealed[:0], chunkNonce(d.base, uint64(index)), sealed[:n], chunkAd(index == d.chunks-1))
+	if err != nil {
+		d.index = -1
+		return ErrStreamCorrupted
+	}
+	d.index = index
+	return nil
+}
+
+func (d *DecryptReader) Read(p []byte) (n int, err error) {
+	if d.offset >= d.size {
+		// Empty streams still have to be authenticated
+		if d.size == 0 {
+			if err = d.loadChunk(0); err != nil {
+				return
+			}
+		}
+		return 0, io.EOF
+	}
+
+	if err = d.loadChunk(d.offset / chunkSize); err != nil {
+		return
+	}
+	n = copy(p, d.chunk[d.offset%chunkSize:])
+	d.offset += int64(n)
+	return
+}
+
+func (d *DecryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
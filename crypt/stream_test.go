@@ -0,0 +1,184 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, key, plaintext []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uneven writes, so chunks get filled across several of them
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 1000)
+		if _, err = w.Write(plaintext[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plaintext = plaintext[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decryptStream returns the plaintext of an encrypted stream, or the error reading it failed with.
+func decryptStream(key, encrypted []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(encrypted), int64(len(encrypted)), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func testPlaintext(n int) []byte {
+	plaintext := make([]byte, n)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 7)
+	}
+	return plaintext
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := NewAesKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 3*chunkSize + 5} {
+		plaintext := testPlaintext(n)
+		encrypted := encryptStream(t, key, plaintext)
+
+		chunks := max((n+chunkSize-1)/chunkSize, 1)
+		if want := nonceSize + n + chunks*16; len(encrypted) != want {
+			t.Errorf("%d bytes encrypted to %d, want %d", n, len(encrypted), want)
+		}
+		r, err := NewDecryptReader(bytes.NewReader(encrypted), int64(len(encrypted)), key)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if r.Size() != int64(n) {
+			t.Errorf("%d bytes: got size %d", n, r.Size())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: plaintext differs", n)
+		}
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	key, err := NewAesKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := encryptStream(t, key, testPlaintext(3*chunkSize+5))
+	chunk := func(i int) []byte {
+		start := nonceSize + i*sealedChunkSize
+		return encrypted[start:min(start+sealedChunkSize, len(encrypted))]
+	}
+	otherKey, err := NewAesKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reordered := bytes.Clone(encrypted[:nonceSize])
+	reordered = append(reordered, chunk(1)...)
+	reordered = append(reordered, chunk(0)...)
+	reordered = append(reordered, chunk(2)...)
+	reordered = append(reordered, chunk(3)...)
+
+	flipped := bytes.Clone(encrypted)
+	flipped[nonceSize+chunkSize+100] ^= 1
+
+	tests := []struct {
+		name      string
+		key       []byte
+		encrypted []byte
+	}{
+		{"last chunk dropped", key, encrypted[:nonceSize+3*sealedChunkSize]},
+		{"truncated within a chunk", key, encrypted[:len(encrypted)-3]},
+		{"truncated to one chunk", key, encrypted[:nonceSize+sealedChunkSize]},
+		{"chunks reordered", key, reordered},
+		{"bit flipped", key, flipped},
+		{"wrong key", otherKey, encrypted},
+		{"nonce only", key, encrypted[:nonceSize]},
+		{"empty stream with wrong key", otherKey, encryptStream(t, key, nil)},
+	}
+	for _, test := range tests {
+		_, err := decryptStream(test.key, test.encrypted)
+		if !errors.Is(err, ErrStreamCorrupted) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, ErrStreamCorrupted)
+		}
+	}
+}
+
+func TestStreamSeek(t *testing.T) {
+	key, err := NewAesKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := testPlaintext(3*chunkSize + 5)
+	encrypted := encryptStream(t, key, plaintext)
+	r, err := NewDecryptReader(bytes.NewReader(encrypted), int64(len(encrypted)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(plaintext))
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{0, io.SeekStart, 0},
+		{chunkSize - 1, io.SeekStart, chunkSize - 1},
+		{chunkSize, io.SeekStart, chunkSize},
+		{2*chunkSize + 12345, io.SeekStart, 2*chunkSize + 12345},
+		{-10, io.SeekEnd, size - 10},
+		{0, io.SeekEnd, size},
+		{100, io.SeekStart, 100},
+		{chunkSize, io.SeekCurrent, chunkSize + 100},
+	}
+	for _, test := range tests {
+		pos, err := r.Seek(test.offset, test.whence)
+		if err != nil {
+			t.Fatalf("seek(%d, %d): %v", test.offset, test.whence, err)
+		}
+		if pos != test.want {
+			t.Fatalf("seek(%d, %d) = %d, want %d", test.offset, test.whence, pos, test.want)
+		}
+
+		// Read across the next chunk boundary
+		buf := make([]byte, chunkSize+10)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Fatalf("reading at %d: %v", pos, err)
+		}
+		if want := plaintext[pos:min(pos+int64(len(buf)), size)]; !bytes.Equal(buf[:n], want) {
+			t.Errorf("reading at %d: got %d bytes differing from the plaintext", pos, n)
+		}
+		// Rewind, so SeekCurrent is relative to where the last seek went
+		if _, err = r.Seek(pos, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeking before the start succeeded")
+	}
+	if _, err = r.Seek(size+10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("reading past the end got %d bytes and error %v, want io.EOF", n, err)
+	}
+}
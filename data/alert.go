@@ -0,0 +1,192 @@
+package data
+
+import (
+	"fmt"
+	"github.com/TaeKwonZeus/pva/network"
+	"github.com/TaeKwonZeus/pva/notify"
+	"github.com/charmbracelet/log"
+	"strconv"
+	"time"
+)
+
+// AlertRules choose which alerts scans raise.
+type AlertRules struct {
+	// NewDevices alerts on MACs no scan has found before that don't belong to a saved device
+	NewDevices bool
+	// OfflineAfter alerts on saved devices that haven't been found for longer, 0 to disable
+	OfflineAfter time.Duration
+	// MACChanges alerts on IPs answering with a different MAC than in the previous scan of their target
+	MACChanges bool
+}
+
+// alertSubjects title the messages alerts are delivered in.
+var alertSubjects = map[AlertType]string{
+	AlertNewDevice:     "New device on the network",
+	AlertDeviceOffline: "Device offline",
+	AlertMACChanged:    "MAC address changed",
+}
+
+func (r AlertRules) enabled() bool {
+	return r.NewDevices || r.OfflineAfter > 0 || r.MACChanges
+}
+
+// EnableAlerts makes scans raise alerts by the rules, delivering new ones through the notifier unless it's nil.
+// It must be called before scanning starts.
+func (s *Store) EnableAlerts(rules AlertRules, notifier notify.Notifier) {
+	s.alertRules, s.notifier = rules, notifier
+}
+
+// scanBaseline is what scans of a target had found before the one being recorded.
+type scanBaseline struct {
+	// first is set if the target wasn't scanned before, in which case everything would look new
+	first bool
+	// knownMACs are all MACs any scan has found
+	knownMACs map[string]bool
+	// previous maps the IPs the previous scan found to their MACs
+	previous map[string]string
+}
+
+func (s *Store) getScanBaseline(target string) (baseline scanBaseline, err error) {
+	scanId, previous, err := s.db.getPreviousScan(target)
+	if err != nil {
+		return
+	}
+	hosts, err := s.db.getHosts()
+	if err != nil {
+		return
+	}
+	baseline = scanBaseline{first: scanId == 0, knownMACs: make(map[string]bool), previous: previous}
+	for _, h := range hosts {
+		if h.MAC != nil {
+			baseline.knownMACs[*h.MAC] = true
+		}
+	}
+	return
+}
+
+// checkScan raises the alerts for a recorded scan of target, given what was found before it.
+func (s *Store) checkScan(target string, devices []network.Device, observations []observation,
+	baseline scanBaseline, saved []Device) error {
+	var alerts []Alert
+	now := time.Now()
+	for i, o := range observations {
+		if o.MAC == "" {
+			continue
+		}
+		if s.alertRules.NewDevices && !baseline.first && !baseline.knownMACs[o.MAC] && o.DeviceId == nil {
+			desc := o.MAC
+			if vendor := devices[i].Vendor; vendor != "" {
+				desc += " (" + vendor + ")"
+			}
+			alerts = append(alerts, Alert{
+				Type:    AlertNewDevice,
+				Key:     "new_device:" + o.MAC,
+				Message: fmt.Sprintf("New device %s found at %s", desc, o.IP),
+				Target:  target,
+				IP:      o.IP,
+				MAC:     o.MAC,
+			})
+		}
+		if prev := baseline.previous[o.IP]; s.alertRules.MACChanges && prev != "" && prev != o.MAC {
+			alerts = append(alerts, Alert{
+				Type:     AlertMACChanged,
+				Key:      "mac_changed:" + o.IP + ":" + o.MAC,
+				Message:  fmt.Sprintf("%s now answers with MAC %s instead of %s", o.IP, o.MAC, prev),
+				Target:   target,
+				IP:       o.IP,
+				MAC:      o.MAC,
+				DeviceID: o.DeviceId,
+			})
+		}
+	}
+
+	if s.alertRules.OfflineAfter > 0 {
+		hosts, err := s.db.getHosts()
+		if err != nil {
+			return err
+		}
+		byDevice := make(map[int][]host)
+		for _, h := range hosts {
+			if h.DeviceId != nil {
+				byDevice[*h.DeviceId] = append(byDevice[*h.DeviceId], h)
+			}
+		}
+		for _, device := range saved {
+			_, lastSeen := seenSpan(byDevice[device.ID])
+			if lastSeen == nil || now.Sub(*lastSeen) < s.alertRules.OfflineAfter {
+				continue
+			}
+			// Each time the device goes offline is a separate alert, which stays dismissed once acknowledged
+			key := "device_offline:" + strconv.Itoa(device.ID) + ":" + strconv.FormatInt(lastSeen.Unix(), 10)
+			acknowledged, err := s.db.isAlertAcknowledged(key)
+			if err != nil {
+				return err
+			}
+			if acknowledged {
+				continue
+			}
+			name := device.Name
+			if name == "" {
+				name = device.IP
+			}
+			alerts = append(alerts, Alert{
+				Type: AlertDeviceOffline,
+				Key:  key,
+				Message: fmt.Sprintf("Device %s hasn't been found since %s", name,
+					lastSeen.Local().Format(time.DateTime)),
+				IP:       device.IP,
+				MAC:      device.MAC,
+				DeviceID: &device.ID,
+			})
+		}
+	}
+
+	var raised []Alert
+	for _, alert := range alerts {
+		alert.CreatedAt = now
+		isNew, err := s.db.raiseAlert(alert)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			log.Warn(alert.Message, "alert", alert.Type, "target", alert.Target)
+			raised = append(raised, alert)
+		}
+	}
+	if s.notifier != nil && len(raised) > 0 {
+		go s.notify(raised)
+	}
+	return nil
+}
+
+// notify delivers new alerts, which can take a while with slow receivers.
+func (s *Store) notify(alerts []Alert) {
+	for _, alert := range alerts {
+		fields := map[string]string{"type": string(alert.Type), "ip": alert.IP, "mac": alert.MAC}
+		if alert.Target != "" {
+			fields["target"] = alert.Target
+		}
+		if alert.DeviceID != nil {
+			fields["deviceId"] = strconv.Itoa(*alert.DeviceID)
+		}
+		err := s.notifier.Notify(notify.Message{
+			Subject: "pva: " + alertSubjects[alert.Type],
+			Body:    alert.Message,
+			Fields:  fields,
+			Time:    alert.CreatedAt,
+		})
+		if err != nil {
+			log.Error("error delivering alert", "alert", alert.Type, "err", err)
+		}
+	}
+}
+
+// GetAlerts returns the unacknowledged alerts, or all of them, latest first.
+func (s *Store) GetAlerts(all bool) ([]Alert, error) {
+	return s.db.getAlerts(all)
+}
+
+// AcknowledgeAlert closes an alert, so that the next time it's raised is a new alert.
+func (s *Store) AcknowledgeAlert(id int, user User) error {
+	return s.db.acknowledgeAlert(id, user.ID)
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"github.com/TaeKwonZeus/pva/network"
+	"testing"
+	"time"
+)
+
+// openAlerts counts the unacknowledged alerts by type.
+func openAlerts(t *testing.T, s *Store) map[AlertType]int {
+	t.Helper()
+	alerts, err := s.GetAlerts(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[AlertType]int)
+	for _, alert := range alerts {
+		counts[alert.Type]++
+	}
+	return counts
+}
+
+func TestScanAlerts(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s, "admin", RoleAdmin)
+	s.EnableAlerts(AlertRules{NewDevices: true, OfflineAfter: time.Hour, MACChanges: true}, nil)
+	target := network.Target{Name: "office"}
+	record := func(devices ...network.Device) {
+		t.Helper()
+		if err := s.RecordScan(target, devices, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		laptopMAC  = "00:00:00:00:00:01"
+		printerMAC = "00:00:00:00:00:02"
+		phoneMAC   = "00:00:00:00:00:03"
+		serverMAC  = "00:00:00:00:00:04"
+	)
+	newTestDevice(t, s, Device{IP: "10.0.0.2", MAC: printerMAC, Name: "printer"})
+	newTestDevice(t, s, Device{IP: "10.0.0.4", MAC: serverMAC, Name: "server"})
+
+	// Everything is new to the first scan of a target, so nothing is alerted on
+	record(scanned("10.0.0.1", laptopMAC), scanned("10.0.0.2", printerMAC))
+	if alerts := openAlerts(t, s); len(alerts) != 0 {
+		t.Errorf("first scan raised alerts %v", alerts)
+	}
+
+	// Unknown MACs are, unless they belong to a saved device
+	record(scanned("10.0.0.1", laptopMAC), scanned("10.0.0.2", printerMAC), scanned("10.0.0.3", phoneMAC),
+		scanned("10.0.0.4", serverMAC))
+	if alerts := openAlerts(t, s); alerts[AlertNewDevice] != 1 || len(alerts) != 1 {
+		t.Errorf("got alerts %v, want a new device", alerts)
+	}
+
+	// An address answering with another MAC is alerted on once
+	for range 2 {
+		record(scanned("10.0.0.1", phoneMAC), scanned("10.0.0.2", printerMAC), scanned("10.0.0.4", serverMAC))
+	}
+	if alerts := openAlerts(t, s); alerts[AlertMACChanged] != 1 || alerts[AlertNewDevice] != 1 {
+		t.Errorf("got alerts %v, want a MAC change along with the new device", alerts)
+	}
+
+	// As if the printer was last found longer ago than the offline rule allows
+	setLastSeen := func(name string, lastSeen time.Time) {
+		t.Helper()
+		_, err := s.db.pool.Exec(`UPDATE hosts SET last_seen=?
+			WHERE device_id=(SELECT id FROM devices WHERE name=?)`, lastSeen.UTC(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	setLastSeen("printer", time.Now().Add(-2*time.Hour))
+	for range 2 {
+		record(scanned("10.0.0.1", phoneMAC), scanned("10.0.0.4", serverMAC))
+	}
+	alerts, err := s.GetAlerts(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offline *Alert
+	for i := range alerts {
+		if alerts[i].Type == AlertDeviceOffline {
+			if offline != nil {
+				t.Fatal("offline device raised more than one alert")
+			}
+			offline = &alerts[i]
+		}
+	}
+	if offline == nil {
+		t.Fatal("offline device wasn't alerted on")
+	}
+	if offline.Count != 2 {
+		t.Errorf("offline alert was raised %d times, want 2", offline.Count)
+	}
+
+	// Acknowledging dismisses it for as long as the device stays offline
+	if err = s.AcknowledgeAlert(offline.ID, user); err != nil {
+		t.Fatal(err)
+	}
+	record(scanned("10.0.0.1", phoneMAC), scanned("10.0.0.4", serverMAC))
+	if n := openAlerts(t, s)[AlertDeviceOffline]; n != 0 {
+		t.Error("acknowledged offline alert was raised again")
+	}
+	// Purging history keeps what it was dismissed by
+	if _, err = s.db.purgeScanHistory(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	record(scanned("10.0.0.1", phoneMAC), scanned("10.0.0.4", serverMAC))
+	if n := openAlerts(t, s)[AlertDeviceOffline]; n != 0 {
+		t.Error("acknowledged offline alert was raised again after purging history")
+	}
+
+	// Going offline again after being found is a new alert
+	record(scanned("10.0.0.1", phoneMAC), scanned("10.0.0.2", printerMAC), scanned("10.0.0.4", serverMAC))
+	setLastSeen("printer", time.Now().Add(-3*time.Hour))
+	record(scanned("10.0.0.1", phoneMAC), scanned("10.0.0.4", serverMAC))
+	if n := openAlerts(t, s)[AlertDeviceOffline]; n != 1 {
+		t.Errorf("got %d offline alerts after the device went offline again, want 1", n)
+	}
+}
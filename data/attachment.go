@@ -0,0 +1,334 @@
+package data
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"github.com/TaeKwonZeus/pva/crypt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrTooLarge      = errors.New("attachment is too large")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// orphanGracePeriod keeps files of uploads still in progress from being removed before their row is inserted.
+const orphanGracePeriod = time.Hour
+
+// sniffLen is how many bytes http.DetectContentType considers.
+const sniffLen = 512
+
+const blobSecretFilename = "blob.key"
+
+// UploadOptions limits and configures storing attachments.
+type UploadOptions struct {
+	// MaxSize is the largest attachment in bytes
+	MaxSize int64
+	// Quota is how many bytes of attachments a user can be charged for, 0 for unlimited
+	Quota int64
+	// Deduplicate enables convergent encryption, deriving the key of a blob from its content so equal
+	// attachments are stored once regardless of which documents they're in
+	Deduplicate bool
+}
+
+// loadSecret reads a random secret from path, generating it on first use.
+func loadSecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret, err = crypt.NewAesKey()
+	if err != nil {
+		return nil, err
+	}
+	return secret, os.WriteFile(path, secret, 0600)
+}
+
+func (s *Store) attachmentPath(fileName string) string {
+	return filepath.Join(s.attachmentDir, fileName)
+}
+
+// writeBlobFile encrypts r with key into a new file at path, hashing the plaintext with h on the way.
+// Returns ErrTooLarge if r is longer than maxSize bytes.
+func writeBlobFile(path string, r io.Reader, key []byte, h hash.Hash, maxSize int64) (size int64, err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	ew, err := crypt.NewEncryptWriter(file, key)
+	if err != nil {
+		return
+	}
+	size, err = io.Copy(ew, io.TeeReader(io.LimitReader(r, maxSize+1), h))
+	if err != nil {
+		return
+	}
+	if size > maxSize {
+		return size, ErrTooLarge
+	}
+	if err = ew.Close(); err != nil {
+		return
+	}
+	return size, file.Sync()
+}
+
+// reencryptBlobFile decrypts the file at src with oldKey and writes it encrypted with newKey to dst.
+func reencryptBlobFile(src, dst string, oldKey, newKey []byte) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	dr, err := crypt.NewDecryptReader(file, info.Size(), oldKey)
+	if err != nil {
+		return err
+	}
+	_, err = writeBlobFile(dst, dr, newKey, crypt.NewKeyedHash(nil), dr.Size())
+	return err
+}
+
+// GetStorageUsage retrieves how many bytes of attachments the user is charged for. Attachments sharing a blob
+// are only counted once.
+func (s *Store) GetStorageUsage(user User, quota int64) (StorageUsage, error) {
+	used, err := s.db.getStorageUsage(user.ID)
+	return StorageUsage{Used: used, Quota: quota}, err
+}
+
+// CreateAttachment streams r into a new attachment of the document. The content is stored in a blob named by its
+// keyed hash, whose key is kept encrypted with the document key. Returns ErrTooLarge if r is longer than
+// opts.MaxSize bytes and ErrQuotaExceeded if the user would go over opts.Quota.
+func (s *Store) CreateAttachment(docId int, name string, r io.Reader, opts UploadOptions, user User) (
+	attachment Attachment, err error) {
+	docKey, err := s.getDecryptedDocumentKey(docId, user)
+	if err != nil {
+		return
+	}
+	if opts.Quota > 0 {
+		used, err := s.db.getStorageUsage(user.ID)
+		if err != nil {
+			return attachment, err
+		}
+		if used >= opts.Quota {
+			return attachment, ErrQuotaExceeded
+		}
+	}
+
+	attachment = Attachment{
+		DocumentID: docId,
+		Name:       name,
+		CreatedAt:  time.Now(),
+		UploadedBy: &user.ID,
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return
+	}
+	attachment.ContentType = http.DetectContentType(head)
+
+	// The hash, and with deduplication the key, are only known once everything has been read, so the content
+	// goes to a temporary file first
+	tempKey, err := crypt.NewAesKey()
+	if err != nil {
+		return
+	}
+	tempName, err := crypt.RandomName()
+	if err != nil {
+		return
+	}
+	tempPath := s.attachmentPath(tempName + ".tmp")
+	defer os.Remove(tempPath)
+
+	h := crypt.NewKeyedHash(s.blobSecret)
+	if !opts.Deduplicate {
+		// Mixing in the random key makes the hash unique, so blobs are never shared
+		h.Write(tempKey)
+	}
+	attachment.Size, err = writeBlobFile(tempPath, br, tempKey, h, opts.MaxSize)
+	if err != nil {
+		return
+	}
+	sum := h.Sum(nil)
+	attachment.BlobHash = hex.EncodeToString(sum)
+	blobKey := tempKey
+	if opts.Deduplicate {
+		blobKey = crypt.ConvergentKey(s.blobSecret, sum)
+	}
+	attachment.KeyEncrypted, err = crypt.AesEncrypt(blobKey, docKey)
+	if err != nil {
+		return
+	}
+
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if opts.Quota > 0 {
+		if err = s.checkQuota(attachment, opts.Quota, user); err != nil {
+			return
+		}
+	}
+
+	exists, err := s.db.blobExists(attachment.BlobHash)
+	if err != nil {
+		return
+	}
+	blobPath := s.attachmentPath(attachment.BlobHash)
+	if !exists {
+		if opts.Deduplicate {
+			err = reencryptBlobFile(tempPath, blobPath, tempKey, blobKey)
+		} else {
+			err = os.Rename(tempPath, blobPath)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	attachment.ID, err = s.db.createAttachment(attachment, blob{
+		Hash:      attachment.BlobHash,
+		Size:      attachment.Size,
+		CreatedAt: attachment.CreatedAt,
+	})
+	if err != nil && !exists {
+		os.Remove(blobPath)
+	}
+	return
+}
+
+// checkQuota returns ErrQuotaExceeded if charging the user for the attachment's blob would take them over quota.
+func (s *Store) checkQuota(attachment Attachment, quota int64, user User) error {
+	charged, err := s.db.isBlobUploadedBy(attachment.BlobHash, user.ID)
+	if err != nil || charged {
+		return err
+	}
+	used, err := s.db.getStorageUsage(user.ID)
+	if err != nil {
+		return err
+	}
+	if used+attachment.Size > quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// attachmentReader decrypts an attachment file, closing it along with itself.
+type attachmentReader struct {
+	*crypt.DecryptReader
+	file *os.File
+}
+
+func (a attachmentReader) Close() error {
+	return a.file.Close()
+}
+
+// GetAttachment opens an attachment of the document for reading. The returned reader must be closed.
+func (s *Store) GetAttachment(id, docId int, user User) (attachment Attachment, content io.ReadSeekCloser,
+	err error) {
+	attachment, err = s.db.getAttachment(id, docId)
+	if err != nil {
+		return
+	}
+	docKey, err := s.getDecryptedDocumentKey(docId, user)
+	if err != nil {
+		return
+	}
+	// Attachments from before blobs are encrypted with the document key itself
+	key := docKey
+	if len(attachment.KeyEncrypted) > 0 {
+		key, err = crypt.AesDecrypt(attachment.KeyEncrypted, docKey)
+		if err != nil {
+			return
+		}
+	}
+
+	file, err := os.Open(s.attachmentPath(attachment.BlobHash))
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	dr, err := crypt.NewDecryptReader(file, info.Size(), key)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	return attachment, attachmentReader{dr, file}, nil
+}
+
+func (s *Store) GetAttachments(docId int) ([]Attachment, error) {
+	return s.db.getAttachments(docId)
+}
+
+// DeleteAttachment deletes an attachment. Its blob is removed by the next collection once nothing refers to it.
+func (s *Store) DeleteAttachment(id, docId int) error {
+	return s.db.deleteAttachment(id, docId)
+}
+
+func (s *Store) RenameAttachment(id, docId int, name string) error {
+	return s.db.renameAttachment(id, docId, name)
+}
+
+// collectBlobs deletes blobs no attachment refers to anymore, including those of purged documents, and removes
+// files left behind by interrupted uploads.
+func (s *Store) collectBlobs() error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	unreferenced, err := s.db.deleteUnreferencedBlobs()
+	if err != nil {
+		return err
+	}
+	for _, hash := range unreferenced {
+		if err = os.Remove(s.attachmentPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(s.attachmentDir)
+	if err != nil {
+		return err
+	}
+	hashes, err := s.db.getBlobHashes()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || hashes[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < orphanGracePeriod {
+			continue
+		}
+		if err = os.Remove(s.attachmentPath(entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
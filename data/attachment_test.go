@@ -0,0 +1,147 @@
+package data
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blobRefs returns the reference count of a blob, or -1 if it doesn't exist.
+func blobRefs(t *testing.T, s *Store, hash string) int {
+	t.Helper()
+	refs := -1
+	err := s.db.pool.Get(&refs, "SELECT ref_count FROM blobs WHERE hash=?", hash)
+	if err != nil && !IsErrNotFound(err) {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+func blobFileExists(t *testing.T, s *Store, hash string) bool {
+	t.Helper()
+	_, err := os.Stat(s.attachmentPath(hash))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestAttachmentBlobs(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s, "admin", RoleAdmin)
+	opts := UploadOptions{MaxSize: 1 << 20, Deduplicate: true}
+
+	first, err := s.CreateDocument(Document{Name: "First", Payload: "first"}, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.CreateDocument(Document{Name: "Second", Payload: "second"}, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const content = "the same config in two places"
+	a, err := s.CreateAttachment(first, "config.txt", strings.NewReader(content), opts, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.CreateAttachment(second, "config.txt", strings.NewReader(content), opts, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.BlobHash != b.BlobHash {
+		t.Fatal("equal attachments got different blobs with deduplication")
+	}
+	if refs := blobRefs(t, s, a.BlobHash); refs != 2 {
+		t.Errorf("shared blob has %d references, want 2", refs)
+	}
+	usage, err := s.GetStorageUsage(user, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != int64(len(content)) {
+		t.Errorf("charged %d bytes for a shared blob of %d", usage.Used, len(content))
+	}
+
+	unique, err := s.CreateAttachment(first, "copy.txt", strings.NewReader(content),
+		UploadOptions{MaxSize: opts.MaxSize}, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unique.BlobHash == a.BlobHash {
+		t.Error("attachment shares a blob without deduplication")
+	}
+
+	// A blob is only collected once nothing refers to it
+	if err = s.DeleteAttachment(a.ID, first); err != nil {
+		t.Fatal(err)
+	}
+	if refs := blobRefs(t, s, a.BlobHash); refs != 1 {
+		t.Errorf("blob has %d references after deleting one of two, want 1", refs)
+	}
+	if err = s.collectBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	_, r, err := s.GetAttachment(b.ID, second, user)
+	if err != nil {
+		t.Fatalf("opening the attachment still referring to the blob: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("got content %q", got)
+	}
+
+	// Purging a document releases its attachments
+	if err = s.DeleteDocument(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.purgeTrash(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if refs := blobRefs(t, s, a.BlobHash); refs != 0 {
+		t.Errorf("blob has %d references after purging its last document, want 0", refs)
+	}
+	if err = s.collectBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	if refs := blobRefs(t, s, a.BlobHash); refs != -1 {
+		t.Error("unreferenced blob wasn't collected")
+	}
+	if blobFileExists(t, s, a.BlobHash) {
+		t.Error("file of a collected blob wasn't removed")
+	}
+	if !blobFileExists(t, s, unique.BlobHash) {
+		t.Error("file of a referenced blob was removed")
+	}
+}
+
+func TestCollectOrphanFiles(t *testing.T) {
+	s := newTestStore(t)
+
+	for _, name := range []string{"stale.tmp", "recent.tmp"} {
+		if err := os.WriteFile(s.attachmentPath(name), []byte("partial upload"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	if err := os.Chtimes(s.attachmentPath("stale.tmp"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.collectBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	if blobFileExists(t, s, "stale.tmp") {
+		t.Error("file of an interrupted upload wasn't removed")
+	}
+	if !blobFileExists(t, s, "recent.tmp") {
+		t.Error("file of an upload that may be in progress was removed")
+	}
+}
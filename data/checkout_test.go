@@ -0,0 +1,89 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckout(t *testing.T) {
+	s := newTestStore(t)
+	owner := newTestUser(t, s, "owner", RoleManager)
+	guest := newTestUser(t, s, "guest", RoleManager)
+	admin := newTestUser(t, s, "admin", RoleAdmin)
+	vaultId := newTestVault(t, s, "Servers", owner)
+	for _, u := range []User{guest, admin} {
+		if err := s.ShareVault(vaultId, u, owner, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CreatePassword(Password{Name: "root", Password: "secret"}, vaultId, owner); err != nil {
+		t.Fatal(err)
+	}
+	vault, err := s.GetVault(vaultId, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := vault.Passwords[0].ID
+
+	if _, err = s.CheckoutPassword(id, vaultId, "", time.Hour, false, guest); !errors.Is(err, ErrCheckoutNotRequired) {
+		t.Errorf("checking out an unprivileged password returned %v", err)
+	}
+
+	required := true
+	if err = s.UpdatePassword(Password{ID: id}, vaultId, &required, owner); err != nil {
+		t.Fatal(err)
+	}
+	if p := vaultPasswords(t, s, vaultId, guest); p["root"] != "" {
+		t.Error("privileged password is readable without checking it out")
+	}
+
+	password, err := s.CheckoutPassword(id, vaultId, "maintenance", time.Hour, false, guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password.Password != "secret" {
+		t.Errorf("checkout returned password %q", password.Password)
+	}
+	if p := vaultPasswords(t, s, vaultId, guest); p["root"] != "secret" {
+		t.Error("holder can't read the password they checked out")
+	}
+	if p := vaultPasswords(t, s, vaultId, owner); p["root"] != "" {
+		t.Error("password checked out by someone else is readable")
+	}
+
+	// Checkouts are exclusive, and only admins can override them
+	if _, err = s.CheckoutPassword(id, vaultId, "", time.Hour, false, owner); !errors.Is(err, ErrCheckedOut) {
+		t.Errorf("second checkout returned %v", err)
+	}
+	if _, err = s.CheckoutPassword(id, vaultId, "", time.Hour, true, owner); !errors.Is(err, ErrCheckedOut) {
+		t.Errorf("override by a non-admin returned %v", err)
+	}
+	if err = s.CheckInPassword(id, vaultId, owner); !errors.Is(err, ErrCheckedOut) {
+		t.Errorf("checking in someone else's checkout returned %v", err)
+	}
+	if _, err = s.CheckoutPassword(id, vaultId, "", time.Hour, false, admin); !errors.Is(err, ErrCheckedOut) {
+		t.Errorf("admin checkout without override returned %v", err)
+	}
+	if _, err = s.CheckoutPassword(id, vaultId, "incident", time.Hour, true, admin); err != nil {
+		t.Fatalf("admin override returned %v", err)
+	}
+	if p := vaultPasswords(t, s, vaultId, guest); p["root"] != "" {
+		t.Error("password is still readable after the checkout was overridden")
+	}
+
+	if err = s.CheckInPassword(id, vaultId, admin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CheckoutPassword(id, vaultId, "", time.Hour, false, owner); err != nil {
+		t.Errorf("checking out after check-in returned %v", err)
+	}
+
+	checkouts, err := s.GetCheckouts(id, vaultId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkouts) != 3 {
+		t.Errorf("got %d checkouts in history, want 3", len(checkouts))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/jmoiron/sqlx"
 	"github.com/mattn/go-sqlite3"
+	"time"
 )
 
 //go:embed startup.sql
@@ -25,17 +26,6 @@ func IsErrNotFound(err error) bool {
 	return errors.Is(err, sql.ErrNoRows)
 }
 
-func (d *db) getIndex(id int) (index Index, err error) {
-	vaults, err := d.getVaults(id)
-	if err != nil {
-		return
-	}
-
-	index.Vaults = vaults
-
-	return
-}
-
 func (d *db) getUserCount() (n int, err error) {
 	row := d.pool.QueryRow("SELECT COUNT(*) FROM users")
 	err = row.Scan(&n)
@@ -72,7 +62,7 @@ func (d *db) getAdmins() (users []User, err error) {
 }
 
 func (d *db) createVault(vault Vault) (id int, err error) {
-	res, err := d.pool.NamedExec("INSERT INTO vaults (name) VALUES (:name)", vault)
+	res, err := d.pool.NamedExec("INSERT INTO vaults (name, folder_id) VALUES (:name, :folder_id)", vault)
 	if err != nil {
 		return 0, err
 	}
@@ -81,9 +71,10 @@ func (d *db) createVault(vault Vault) (id int, err error) {
 }
 
 type vaultKey struct {
-	UserId       int    `db:"user_id"`
-	VaultId      int    `db:"vault_id"`
-	KeyEncrypted []byte `db:"key_encrypted"`
+	UserId       int        `db:"user_id"`
+	VaultId      int        `db:"vault_id"`
+	KeyEncrypted []byte     `db:"key_encrypted"`
+	ExpiresAt    *time.Time `db:"expires_at"`
 }
 
 func (d *db) createVaultKeys(keys ...vaultKey) error {
@@ -93,20 +84,127 @@ func (d *db) createVaultKeys(keys ...vaultKey) error {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.NamedExec(`INSERT INTO vault_keys (user_id, vault_id, key_encrypted)
-		VALUES (:user_id, :vault_id, :key_encrypted) ON CONFLICT DO NOTHING`, keys)
+	if err = insertVaultKeys(tx, keys); err != nil {
+		return err
+	}
 
 	return tx.Commit()
 }
 
+func insertVaultKeys(tx *sqlx.Tx, keys []vaultKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// Access users already have is left as it is, only grantVaultKey changes it
+	_, err := tx.NamedExec(`INSERT INTO vault_keys (user_id, vault_id, key_encrypted, expires_at)
+		VALUES (:user_id, :vault_id, :key_encrypted, :expires_at) ON CONFLICT DO NOTHING`, keys)
+	return err
+}
+
+// grantVaultKey gives a user access to a vault. Permanent access is never downgraded to a time-limited one,
+// but time-limited access gets the expiry of the new grant, which may make it permanent.
+func (d *db) grantVaultKey(key vaultKey) error {
+	return upsertVaultKey(d.pool, key)
+}
+
+func upsertVaultKey(e sqlx.Ext, key vaultKey) error {
+	_, err := sqlx.NamedExec(e, `INSERT INTO vault_keys (user_id, vault_id, key_encrypted, expires_at)
+		VALUES (:user_id, :vault_id, :key_encrypted, :expires_at)
+		ON CONFLICT DO UPDATE SET expires_at=excluded.expires_at WHERE vault_keys.expires_at IS NOT NULL`, key)
+	return err
+}
+
 func (d *db) getVaultKey(id, userId int) (key []byte, err error) {
-	err = d.pool.Get(&key, "SELECT key_encrypted FROM vault_keys WHERE user_id=? AND vault_id=?", userId, id)
+	err = d.pool.Get(&key, `SELECT key_encrypted FROM vault_keys
+		WHERE user_id=? AND vault_id=? AND (expires_at IS NULL OR expires_at > ?)`, userId, id, time.Now().UTC())
+	return
+}
+
+// getVaultGrant retrieves the user's unexpired access to a vault.
+func (d *db) getVaultGrant(id, userId int) (key vaultKey, err error) {
+	err = d.pool.Get(&key, `SELECT * FROM vault_keys
+		WHERE user_id=? AND vault_id=? AND (expires_at IS NULL OR expires_at > ?)`, userId, id, time.Now().UTC())
+	return
+}
+
+type vaultHolder struct {
+	UserId    int    `db:"user_id"`
+	PublicKey []byte `db:"public_key"`
+}
+
+func (d *db) getVaultHolders(vaultId int) (holders []vaultHolder, err error) {
+	err = d.pool.Select(&holders, `SELECT u.id AS user_id, u.public_key FROM users u
+		INNER JOIN vault_keys vk ON u.id = vk.user_id WHERE vk.vault_id=?`, vaultId)
+	return
+}
+
+// getAllPasswords retrieves passwords of a vault including ones in trash.
+func (d *db) getAllPasswords(vaultId int) (passwords []Password, err error) {
+	passwords = []Password{}
+	err = d.pool.Select(&passwords, "SELECT id, name, password_encrypted FROM passwords WHERE vault_id=?", vaultId)
 	return
 }
 
+// replaceVaultKey swaps out the key of a vault, writing passwords re-encrypted and keys re-wrapped with the new key.
+func (d *db) replaceVaultKey(vaultId int, passwords []Password, keys []vaultKey) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, password := range passwords {
+		_, err = tx.Exec("UPDATE passwords SET password_encrypted=? WHERE id=? AND vault_id=?",
+			password.PasswordEncrypted, password.ID, vaultId)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		_, err = tx.Exec("UPDATE vault_keys SET key_encrypted=? WHERE user_id=? AND vault_id=?",
+			key.KeyEncrypted, key.UserId, vaultId)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE vaults SET key_rotation_pending=0 WHERE id=?", vaultId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// expireVaultKeys removes lapsed time-limited vault keys and marks their vaults for key rotation.
+func (d *db) expireVaultKeys(now time.Time) (n int64, err error) {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE vaults SET key_rotation_pending=1
+		WHERE id IN (SELECT vault_id FROM vault_keys WHERE expires_at <= ?)`, now.UTC())
+	if err != nil {
+		return
+	}
+	res, err := tx.Exec("DELETE FROM vault_keys WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return
+	}
+	n, err = res.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	return n, tx.Commit()
+}
+
 func (d *db) getVault(id, userId int) (vault Vault, err error) {
-	err = d.pool.Get(&vault, `SELECT v.*, vk.key_encrypted FROM vaults v
-        INNER JOIN vault_keys vk ON v.id = vk.vault_id WHERE user_id=? AND vault_id=?`, userId, id)
+	err = d.pool.Get(&vault, `SELECT v.*, vk.key_encrypted, vk.expires_at FROM vaults v
+        INNER JOIN vault_keys vk ON v.id = vk.vault_id
+        WHERE user_id=? AND vault_id=? AND v.deleted_at IS NULL AND (vk.expires_at IS NULL OR vk.expires_at > ?)`,
+		userId, id, time.Now().UTC())
 	if err != nil {
 		return
 	}
@@ -117,8 +215,10 @@ func (d *db) getVault(id, userId int) (vault Vault, err error) {
 // GetVaults retrieves all pairs of vaults and data keys the user with userId has access to.
 func (d *db) getVaults(userId int) (vaults []Vault, err error) {
 	vaults = []Vault{}
-	err = d.pool.Select(&vaults, `SELECT v.*, vk.key_encrypted FROM vaults v
-        INNER JOIN vault_keys vk ON v.id = vk.vault_id WHERE user_id=?`, userId)
+	err = d.pool.Select(&vaults, `SELECT v.*, vk.key_encrypted, vk.expires_at FROM vaults v
+        INNER JOIN vault_keys vk ON v.id = vk.vault_id
+        WHERE user_id=? AND v.deleted_at IS NULL AND (vk.expires_at IS NULL OR vk.expires_at > ?)`,
+		userId, time.Now().UTC())
 	if err != nil {
 		return
 	}
@@ -133,7 +233,7 @@ func (d *db) getVaults(userId int) (vaults []Vault, err error) {
 
 func (d *db) updateVault(vault Vault) error {
 	if vault.Name != "" {
-		_, err := d.pool.Exec("UPDATE vaults SET name=? WHERE id=?", vault.Name, vault.ID)
+		_, err := d.pool.Exec("UPDATE vaults SET name=? WHERE id=? AND deleted_at IS NULL", vault.Name, vault.ID)
 		if err != nil {
 			return err
 		}
@@ -142,20 +242,55 @@ func (d *db) updateVault(vault Vault) error {
 	return nil
 }
 
+// softDelete marks a row of table as deleted, returning sql.ErrNoRows if there is no such row
+// or it is already in trash.
+func (d *db) softDelete(table string, id int) error {
+	res, err := d.pool.Exec("UPDATE "+table+" SET deleted_at=? WHERE id=? AND deleted_at IS NULL",
+		time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// restore takes a row of table out of trash, returning sql.ErrNoRows if it isn't in trash.
+func (d *db) restore(table string, id int) error {
+	res, err := d.pool.Exec("UPDATE "+table+" SET deleted_at=NULL WHERE id=? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (d *db) deleteVault(id int) error {
-	// All passwords get cascade deleted by sqlite
-	_, err := d.pool.Exec("DELETE FROM vaults WHERE id=?", id)
-	return err
+	// Passwords stay untouched so they come back with the vault on restore
+	return d.softDelete("vaults", id)
+}
+
+func (d *db) restoreVault(id int) error {
+	return d.restore("vaults", id)
 }
 
 func (d *db) createPassword(password Password, vaultId int) (id int, err error) {
 	res, err := d.pool.Exec(
-		`INSERT INTO passwords (name, description, password_encrypted, vault_id)
-		VALUES (?, ?, ?, ?)`,
+		`INSERT INTO passwords (name, description, password_encrypted, vault_id, checkout_required)
+		VALUES (?, ?, ?, ?, ?)`,
 		password.Name,
 		password.Description,
 		password.PasswordEncrypted,
 		vaultId,
+		password.CheckoutRequired,
 	)
 	if err != nil {
 		return 0, err
@@ -166,17 +301,54 @@ func (d *db) createPassword(password Password, vaultId int) (id int, err error)
 
 func (d *db) getPasswords(vaultId int) (passwords []Password, err error) {
 	passwords = []Password{}
-	err = d.pool.Select(&passwords, "SELECT id, name, description, password_encrypted FROM passwords WHERE vault_id=?", vaultId)
+	err = d.pool.Select(&passwords, `SELECT id, name, description, password_encrypted, checkout_required,
+		rotation_required FROM passwords WHERE vault_id=? AND deleted_at IS NULL`, vaultId)
+	if err != nil {
+		return
+	}
+
+	var checkouts []Checkout
+	err = d.pool.Select(&checkouts, checkoutQuery+` INNER JOIN passwords p ON c.password_id = p.id
+		WHERE p.vault_id=? AND c.checked_in_at IS NULL AND c.expires_at > ?`, vaultId, time.Now().UTC())
+	if err != nil {
+		return
+	}
+	for i := range checkouts {
+		for j := range passwords {
+			if passwords[j].ID == checkouts[i].PasswordID {
+				passwords[j].Checkout = &checkouts[i]
+			}
+		}
+	}
+	return
+}
+
+func (d *db) getPassword(id, vaultId int) (password Password, err error) {
+	err = d.pool.Get(&password, `SELECT id, name, description, password_encrypted, checkout_required,
+		rotation_required FROM passwords WHERE id=? AND vault_id=? AND deleted_at IS NULL`, id, vaultId)
 	return
 }
 
-func (d *db) updatePassword(password Password) error {
+// updatePassword changes the name, description and value of a password if they're set, and whether it requires
+// checkout if checkoutRequired isn't nil.
+func (d *db) updatePassword(password Password, vaultId int, checkoutRequired *bool) error {
 	tx, err := d.pool.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	// Make sure the password actually belongs to the vault the user was authorized for
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM passwords WHERE id=? AND vault_id=? AND deleted_at IS NULL)",
+		password.ID, vaultId).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
 	if password.Name != "" {
 		_, err = tx.Exec("UPDATE passwords SET name=? WHERE id=?", password.Name, password.ID)
 		if err != nil {
@@ -190,7 +362,15 @@ func (d *db) updatePassword(password Password) error {
 		}
 	}
 	if password.PasswordEncrypted != nil {
-		_, err = tx.Exec("UPDATE passwords SET password_encrypted=? WHERE id=?", password.PasswordEncrypted, password.ID)
+		// A new password value fulfills any pending rotation
+		_, err = tx.Exec("UPDATE passwords SET password_encrypted=?, rotation_required=0 WHERE id=?",
+			password.PasswordEncrypted, password.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if checkoutRequired != nil {
+		_, err = tx.Exec("UPDATE passwords SET checkout_required=? WHERE id=?", *checkoutRequired, password.ID)
 		if err != nil {
 			return err
 		}
@@ -199,87 +379,1325 @@ func (d *db) updatePassword(password Password) error {
 	return tx.Commit()
 }
 
-func (d *db) deletePassword(id int) error {
-	_, err := d.pool.Exec("DELETE FROM passwords WHERE id=?", id)
-	return err
+func (d *db) deletePassword(id, vaultId int) error {
+	res, err := d.pool.Exec("UPDATE passwords SET deleted_at=? WHERE id=? AND vault_id=? AND deleted_at IS NULL",
+		time.Now().UTC(), id, vaultId)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (d *db) restorePassword(id, vaultId int) error {
+	res, err := d.pool.Exec("UPDATE passwords SET deleted_at=NULL WHERE id=? AND vault_id=? AND deleted_at IS NOT NULL",
+		id, vaultId)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
 }
 
 func (d *db) createDevice(device Device) (id int, err error) {
-	res, err := d.pool.NamedExec("INSERT INTO devices (ip, name, description) VALUES (:ip, :name, :description)",
-		device)
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExec(`INSERT INTO devices (ip, name, description, mac)
+		VALUES (:ip, :name, :description, :mac)`, device)
 	if err != nil {
 		return 0, err
 	}
 	i, err := res.LastInsertId()
-	return int(i), err
+	if err != nil {
+		return 0, err
+	}
+	if err = setDeviceAddresses(tx, int(i), device.IPv6); err != nil {
+		return 0, err
+	}
+	return int(i), tx.Commit()
 }
 
 func (d *db) getDevices() (devices []Device, err error) {
 	devices = []Device{}
-	err = d.pool.Select(&devices, "SELECT id, ip, name, description FROM devices")
+	err = d.pool.Select(&devices, "SELECT id, ip, name, description, mac FROM devices WHERE deleted_at IS NULL")
+	if err != nil {
+		return
+	}
+
+	var rows []struct {
+		DeviceId int    `db:"device_id"`
+		IP       string `db:"ip"`
+	}
+	if err = d.pool.Select(&rows, "SELECT device_id, ip FROM device_addresses ORDER BY ip"); err != nil {
+		return
+	}
+	addresses := make(map[int][]string)
+	for _, row := range rows {
+		addresses[row.DeviceId] = append(addresses[row.DeviceId], row.IP)
+	}
+	services, err := d.getDeviceServices()
+	if err != nil {
+		return
+	}
+	for i := range devices {
+		devices[i].IPv6 = addresses[devices[i].ID]
+		if devices[i].IPv6 == nil {
+			devices[i].IPv6 = []string{}
+		}
+		devices[i].Services = services[devices[i].ID]
+		if devices[i].Services == nil {
+			devices[i].Services = []Service{}
+		}
+	}
 	return
 }
 
-func (d *db) updateDevice(device Device) error {
-	_, err := d.pool.Exec("UPDATE devices SET ip=?, name=?, description=? WHERE id=?", device.IP, device.Name, device.Description, device.ID)
-	return err
+// getDeviceServices retrieves the services of all devices, keyed by device id.
+func (d *db) getDeviceServices() (services map[int][]Service, err error) {
+	var rows []struct {
+		DeviceId int `db:"device_id"`
+		Service
+	}
+	err = d.pool.Select(&rows, `SELECT device_id, port, name, banner, cert_subject, cert_issuer, cert_expires_at
+		FROM device_services ORDER BY port`)
+	if err != nil {
+		return
+	}
+
+	services = make(map[int][]Service)
+	for _, row := range rows {
+		services[row.DeviceId] = append(services[row.DeviceId], row.Service)
+	}
+	return
 }
 
-func (d *db) deleteDevice(id int) error {
-	_, err := d.pool.Exec("DELETE FROM devices WHERE id=?", id)
-	return err
+// setDeviceServices replaces the services of a device.
+func (d *db) setDeviceServices(deviceId int, services []Service) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM device_services WHERE device_id=?", deviceId); err != nil {
+		return err
+	}
+	for _, service := range services {
+		_, err = tx.Exec(`INSERT INTO device_services
+			(device_id, port, name, banner, cert_subject, cert_issuer, cert_expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			deviceId, service.Port, service.Name, service.Banner, service.CertSubject, service.CertIssuer,
+			service.CertExpiresAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (d *db) createDocument(document Document) (id int, err error) {
-	res, err := d.pool.Exec("INSERT INTO documents (name, payload_encrypted) VALUES (?, ?)",
-		document.Name, document.PayloadEncrypted)
+func (d *db) updateDevice(device Device) error {
+	tx, err := d.pool.Beginx()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	i, err := res.LastInsertId()
-	return int(i), err
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE devices SET ip=?, name=?, description=?, mac=? WHERE id=? AND deleted_at IS NULL`,
+		device.IP, device.Name, device.Description, device.MAC, device.ID)
+	if err != nil {
+		return err
+	}
+	// Leave the addresses of devices that don't exist alone
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if err = setDeviceAddresses(tx, device.ID, device.IPv6); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-type documentKey struct {
-	UserId       int    `db:"user_id"`
-	DocumentId   int    `db:"vault_id"`
-	KeyEncrypted []byte `db:"key_encrypted"`
+// updateDeviceAddress records the IP and MAC a saved device was last found at by a scan.
+func (d *db) updateDeviceAddress(id int, ip string, mac string) error {
+	res, err := d.pool.Exec("UPDATE devices SET ip=?, mac=? WHERE id=? AND deleted_at IS NULL", ip, mac, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
 }
 
-func (d *db) createDocumentKeys(keys ...documentKey) error {
+// setDeviceAddresses replaces the IPv6 addresses of a device.
+func setDeviceAddresses(tx execer, deviceId int, addresses []string) error {
+	if _, err := tx.Exec("DELETE FROM device_addresses WHERE device_id=?", deviceId); err != nil {
+		return err
+	}
+	for _, ip := range addresses {
+		_, err := tx.Exec("INSERT INTO device_addresses (device_id, ip) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			deviceId, ip)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// host is anything scans have found, saved as a device or not.
+type host struct {
+	ID        int       `db:"id"`
+	MAC       *string   `db:"mac"`
+	IP        string    `db:"ip"`
+	DeviceId  *int      `db:"device_id"`
+	FirstSeen time.Time `db:"first_seen"`
+	LastSeen  time.Time `db:"last_seen"`
+}
+
+// observation is a host found by a scan, along with the saved device it was matched to.
+type observation struct {
+	MAC      string
+	IP       string
+	DeviceId *int
+}
+
+// recordScan saves a completed scan of target. Hosts the previous scan of the target found at the same IP
+// have their presence extended, the others start a new period.
+func (d *db) recordScan(target string, startedAt, finishedAt time.Time, observations []observation) error {
 	tx, err := d.pool.Beginx()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.NamedExec(`INSERT INTO document_keys (user_id, document_id, key_encrypted)
-		VALUES (:user_id, :document_id, :key_encrypted)`, keys)
+	var prevId int
+	if err = tx.Get(&prevId, "SELECT COALESCE(MAX(id), 0) FROM scans WHERE target=?", target); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`INSERT INTO scans (target, started_at, finished_at, host_count) VALUES (?, ?, ?, ?)`,
+		target, startedAt.UTC(), finishedAt.UTC(), len(observations))
+	if err != nil {
+		return err
+	}
+	scanId, err := res.LastInsertId()
 	if err != nil {
 		return err
 	}
 
+	for _, o := range observations {
+		hostId, err := upsertHost(tx, o, startedAt, finishedAt)
+		if err != nil {
+			return err
+		}
+		res, err = tx.Exec(`UPDATE host_presence SET last_scan_id=?, to_time=?
+			WHERE host_id=? AND target=? AND ip=? AND last_scan_id=?`,
+			scanId, finishedAt.UTC(), hostId, target, o.IP, prevId)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO host_presence (host_id, target, ip, last_scan_id, from_time, to_time)
+			VALUES (?, ?, ?, ?, ?, ?)`, hostId, target, o.IP, scanId, startedAt.UTC(), finishedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
 	return tx.Commit()
 }
 
-func (d *db) getDocuments(userId int) (docs []Document, err error) {
-	docs = []Document{}
-	err = d.pool.Select(&docs, `SELECT d.*, dk.key_encrypted FROM documents d
-        INNER JOIN document_keys dk on d.id = dk.document_id WHERE dk.user_id=?`, userId)
+// upsertHost records that the observed host was seen by a scan, returning its id. Hosts are identified by MAC
+// where it's known and by IP otherwise.
+func upsertHost(tx *sqlx.Tx, o observation, startedAt, finishedAt time.Time) (id int, err error) {
+	var mac *string
+	if o.MAC != "" {
+		mac = &o.MAC
+		// Hosts first seen before their MAC was known keep their history once it is
+		_, err = tx.Exec(`UPDATE hosts SET mac=? WHERE ip=? AND mac IS NULL
+			AND NOT EXISTS (SELECT 1 FROM hosts WHERE mac=?)`, o.MAC, o.IP, o.MAC)
+		if err != nil {
+			return
+		}
+	}
+	// A host keeps the device it was matched to when it isn't matched to any
+	err = tx.Get(&id, `INSERT INTO hosts (mac, ip, device_id, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (mac) DO UPDATE SET ip=excluded.ip, device_id=COALESCE(excluded.device_id, device_id),
+			last_seen=excluded.last_seen
+		ON CONFLICT (ip) WHERE mac IS NULL DO UPDATE SET device_id=COALESCE(excluded.device_id, device_id),
+			last_seen=excluded.last_seen
+		RETURNING id`, mac, o.IP, o.DeviceId, startedAt.UTC(), finishedAt.UTC())
+	return
+}
 
-	// TODO add attachments
+func (d *db) getHosts() (hosts []host, err error) {
+	err = d.pool.Select(&hosts, "SELECT id, mac, ip, device_id, first_seen, last_seen FROM hosts")
 	return
 }
 
-func (d *db) getDocument(id, userId int) (doc Document, err error) {
-	err = d.pool.Get(&doc, `SELECT d.*, dk.key_encrypted FROM documents d
-        INNER JOIN document_keys dk on d.id = dk.document_id WHERE user_id=? AND document_id=?`, userId, id)
+// getDeviceHistory retrieves the hosts matched to a device along with their presence, oldest first.
+func (d *db) getDeviceHistory(deviceId int) (hosts []host, timeline []Presence, err error) {
+	exists, err := d.deviceExists(deviceId)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return nil, nil, sql.ErrNoRows
+	}
 
-	// TODO add attachments
-	return doc, err
+	err = d.pool.Select(&hosts, `SELECT id, mac, ip, device_id, first_seen, last_seen FROM hosts
+		WHERE device_id=?`, deviceId)
+	if err != nil {
+		return
+	}
+	timeline = []Presence{}
+	err = d.pool.Select(&timeline, `SELECT p.target, p.ip, p.from_time, p.to_time FROM host_presence p
+		INNER JOIN hosts h ON p.host_id = h.id WHERE h.device_id=? ORDER BY p.from_time`, deviceId)
+	return
 }
 
-func (d *db) updateDocument(id, userId int) error {
-	// TODO implement
-	return nil
+// purgeScanHistory deletes scans and presence that ended before cutoff, along with hosts not seen since that
+// aren't matched to a saved device and alerts acknowledged before it. The latest offline alert of each device is
+// kept, as it keeps the device from being alerted on again while it stays offline.
+func (d *db) purgeScanHistory(cutoff time.Time) (n int64, err error) {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM host_presence WHERE to_time < ?", cutoff.UTC())
+	if err != nil {
+		return
+	}
+	n, _ = res.RowsAffected()
+	if _, err = tx.Exec("DELETE FROM scans WHERE finished_at < ?", cutoff.UTC()); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM hosts WHERE last_seen < ? AND device_id IS NULL", cutoff.UTC()); err != nil {
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM alerts WHERE acknowledged_at < ? AND NOT (type=? AND device_id IS NOT NULL
+		AND id = (SELECT MAX(id) FROM alerts a WHERE a.type=alerts.type AND a.device_id=alerts.device_id))`,
+		cutoff.UTC(), AlertDeviceOffline)
+	if err != nil {
+		return
+	}
+	return n, tx.Commit()
+}
+
+// getPreviousScan retrieves the latest scan of target and the MACs of the hosts it found by IP, or a scan id of
+// 0 if the target hasn't been scanned.
+func (d *db) getPreviousScan(target string) (scanId int, macs map[string]string, err error) {
+	if err = d.pool.Get(&scanId, "SELECT COALESCE(MAX(id), 0) FROM scans WHERE target=?", target); err != nil {
+		return
+	}
+	var rows []struct {
+		IP  string `db:"ip"`
+		MAC string `db:"mac"`
+	}
+	err = d.pool.Select(&rows, `SELECT p.ip, COALESCE(h.mac, '') AS mac FROM host_presence p
+		INNER JOIN hosts h ON p.host_id = h.id WHERE p.target=? AND p.last_scan_id=?`, target, scanId)
+	if err != nil {
+		return
+	}
+	macs = make(map[string]string, len(rows))
+	for _, row := range rows {
+		macs[row.IP] = row.MAC
+	}
+	return
+}
+
+func (d *db) deleteDevice(id int) error {
+	return d.softDelete("devices", id)
+}
+
+func (d *db) restoreDevice(id int) error {
+	return d.restore("devices", id)
+}
+
+// createDocument inserts the document along with its first revision authored by authorId.
+func (d *db) createDocument(document Document, authorId int) (id int, err error) {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO documents (name, file_name, payload_encrypted, format, folder_id)
+		VALUES (?, ?, ?, ?, ?)`, document.Name, document.FileName, document.PayloadEncrypted, document.Format,
+		document.FolderID)
+	if err != nil {
+		return 0, err
+	}
+	i, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err = createRevision(tx, int(i), authorId); err != nil {
+		return 0, err
+	}
+
+	return int(i), tx.Commit()
+}
+
+// createRevision snapshots the current state of the document as a new revision.
+func createRevision(tx execer, documentId, authorId int) error {
+	_, err := tx.Exec(`INSERT INTO document_revisions (document_id, name, payload_encrypted, format, author_id,
+		created_at) SELECT id, name, payload_encrypted, format, ?, ? FROM documents WHERE id=?`,
+		authorId, time.Now().UTC(), documentId)
+	return err
+}
+
+type documentKey struct {
+	UserId       int    `db:"user_id"`
+	DocumentId   int    `db:"document_id"`
+	KeyEncrypted []byte `db:"key_encrypted"`
+}
+
+func (d *db) createDocumentKeys(keys ...documentKey) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertDocumentKeys(tx, keys); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertDocumentKeys(tx *sqlx.Tx, keys []documentKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := tx.NamedExec(`INSERT INTO document_keys (user_id, document_id, key_encrypted)
+		VALUES (:user_id, :document_id, :key_encrypted) ON CONFLICT DO NOTHING`, keys)
+	return err
+}
+
+func (d *db) getDocuments(userId int) (docs []Document, err error) {
+	docs = []Document{}
+	err = d.pool.Select(&docs, `SELECT d.*, dk.key_encrypted FROM documents d
+        INNER JOIN document_keys dk on d.id = dk.document_id WHERE dk.user_id=? AND d.deleted_at IS NULL`, userId)
+	if err != nil {
+		return
+	}
+	for i := range docs {
+		docs[i].Attachments, err = d.getAttachments(docs[i].ID)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// getDocumentInfos retrieves the documents in a folder the user has a key for. Size is that of the encrypted
+// payload.
+func (d *db) getDocumentInfos(folderId *int, userId int) (docs []DocumentInfo, err error) {
+	docs = []DocumentInfo{}
+	err = d.pool.Select(&docs, `SELECT d.id, d.name, d.format, d.folder_id, length(d.payload_encrypted) AS size,
+        r.created_at AS updated_at FROM documents d
+        INNER JOIN document_keys dk ON d.id = dk.document_id
+        LEFT JOIN document_revisions r ON r.id = (SELECT MAX(id) FROM document_revisions WHERE document_id = d.id)
+        WHERE dk.user_id=? AND d.folder_id IS ? AND d.deleted_at IS NULL ORDER BY d.id`, userId, folderId)
+	return
+}
+
+func (d *db) getDocument(id, userId int) (doc Document, err error) {
+	err = d.pool.Get(&doc, `SELECT d.*, dk.key_encrypted FROM documents d
+        INNER JOIN document_keys dk on d.id = dk.document_id
+        WHERE user_id=? AND document_id=? AND d.deleted_at IS NULL`, userId, id)
+	if err != nil {
+		return
+	}
+	doc.Attachments, err = d.getAttachments(id)
+	return
+}
+
+// updateDocument changes the name, payload and format if they're set, recording the result as a revision by authorId.
+func (d *db) updateDocument(doc Document, authorId int) error {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM documents WHERE id=? AND deleted_at IS NULL)", doc.ID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	if doc.Name != "" {
+		_, err = tx.Exec("UPDATE documents SET name=? WHERE id=?", doc.Name, doc.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if doc.PayloadEncrypted != nil {
+		_, err = tx.Exec("UPDATE documents SET payload_encrypted=? WHERE id=?", doc.PayloadEncrypted, doc.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if doc.Format != "" {
+		_, err = tx.Exec("UPDATE documents SET format=? WHERE id=?", doc.Format, doc.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if doc.Name != "" || doc.PayloadEncrypted != nil || doc.Format != "" {
+		if err = createRevision(tx, doc.ID, authorId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *db) documentExists(id int) (exists bool, err error) {
+	err = d.pool.Get(&exists, "SELECT EXISTS(SELECT 1 FROM documents WHERE id=? AND deleted_at IS NULL)", id)
+	return
+}
+
+const revisionQuery = `SELECT r.id, r.document_id, r.name, r.payload_encrypted, r.format, r.author_id,
+	u.username AS author, r.created_at FROM document_revisions r LEFT JOIN users u ON r.author_id = u.id`
+
+// getRevisions retrieves the revisions of the document without their payloads, newest first.
+func (d *db) getRevisions(documentId int) (revisions []Revision, err error) {
+	exists, err := d.documentExists(documentId)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	revisions = []Revision{}
+	err = d.pool.Select(&revisions, `SELECT r.id, r.document_id, r.name, r.author_id, u.username AS author,
+		r.created_at FROM document_revisions r LEFT JOIN users u ON r.author_id = u.id
+		WHERE r.document_id=? ORDER BY r.id DESC`, documentId)
+	return
+}
+
+func (d *db) getRevision(id, documentId int) (revision Revision, err error) {
+	err = d.pool.Get(&revision, revisionQuery+` INNER JOIN documents d ON r.document_id = d.id
+		WHERE r.id=? AND r.document_id=? AND d.deleted_at IS NULL`, id, documentId)
+	return
+}
+
+func (d *db) getTemplates() (templates []Template, err error) {
+	templates = []Template{}
+	err = d.pool.Select(&templates, "SELECT * FROM document_templates ORDER BY name")
+	return
+}
+
+func (d *db) getTemplate(id int) (t Template, err error) {
+	err = d.pool.Get(&t, "SELECT * FROM document_templates WHERE id=?", id)
+	return
+}
+
+func (d *db) createTemplate(t Template) (id int, err error) {
+	res, err := d.pool.Exec("INSERT INTO document_templates (name, description, format, body) VALUES (?, ?, ?, ?)",
+		t.Name, t.Description, t.Format, t.Body)
+	if err != nil {
+		return 0, err
+	}
+	i, err := res.LastInsertId()
+	return int(i), err
+}
+
+func (d *db) deleteTemplate(id int) error {
+	res, err := d.pool.Exec("DELETE FROM document_templates WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (d *db) getAttachments(documentId int) (attachments []Attachment, err error) {
+	attachments = []Attachment{}
+	err = d.pool.Select(&attachments, "SELECT * FROM attachments WHERE document_id=? ORDER BY name", documentId)
+	return
+}
+
+// blob is the encrypted content of one or more attachments.
+type blob struct {
+	Hash      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// createAttachment inserts the attachment along with its blob unless the blob exists already.
+func (d *db) createAttachment(attachment Attachment, blob blob) (id int, err error) {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO blobs (hash, size, created_at) VALUES (?, ?, ?) ON CONFLICT (hash) DO NOTHING`,
+		blob.Hash, blob.Size, blob.CreatedAt.UTC())
+	if err != nil {
+		return
+	}
+	res, err := tx.Exec(`INSERT INTO attachments
+		(document_id, name, blob_hash, key_encrypted, content_type, size, uploaded_by, created_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ? WHERE EXISTS(SELECT 1 FROM documents WHERE id=? AND deleted_at IS NULL)`,
+		attachment.DocumentID, attachment.Name, attachment.BlobHash, attachment.KeyEncrypted,
+		attachment.ContentType, attachment.Size, attachment.UploadedBy, attachment.CreatedAt.UTC(),
+		attachment.DocumentID)
+	if err != nil {
+		return
+	}
+	if err = requireAffected(res); err != nil {
+		return
+	}
+	i, err := res.LastInsertId()
+	if err != nil {
+		return
+	}
+	return int(i), tx.Commit()
+}
+
+func (d *db) getAttachment(id, documentId int) (attachment Attachment, err error) {
+	err = d.pool.Get(&attachment, `SELECT a.* FROM attachments a INNER JOIN documents d ON a.document_id = d.id
+		WHERE a.id=? AND a.document_id=? AND d.deleted_at IS NULL`, id, documentId)
+	return
+}
+
+func (d *db) deleteAttachment(id, documentId int) error {
+	res, err := d.pool.Exec(`DELETE FROM attachments WHERE id=? AND document_id IN
+		(SELECT id FROM documents WHERE id=? AND deleted_at IS NULL)`, id, documentId)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (d *db) renameAttachment(id, documentId int, name string) error {
+	res, err := d.pool.Exec(`UPDATE attachments SET name=? WHERE id=? AND document_id IN
+		(SELECT id FROM documents WHERE id=? AND deleted_at IS NULL)`, name, id, documentId)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (d *db) blobExists(hash string) (exists bool, err error) {
+	err = d.pool.Get(&exists, "SELECT EXISTS(SELECT 1 FROM blobs WHERE hash=?)", hash)
+	return
+}
+
+// getStorageUsage sums the sizes of the blobs of attachments the user uploaded, counting each blob once.
+func (d *db) getStorageUsage(userId int) (used int64, err error) {
+	err = d.pool.Get(&used, `SELECT IFNULL(SUM(size), 0) FROM blobs
+		WHERE hash IN (SELECT blob_hash FROM attachments WHERE uploaded_by=?)`, userId)
+	return
+}
+
+// isBlobUploadedBy reports whether the user is already charged for the blob.
+func (d *db) isBlobUploadedBy(hash string, userId int) (uploaded bool, err error) {
+	err = d.pool.Get(&uploaded, "SELECT EXISTS(SELECT 1 FROM attachments WHERE blob_hash=? AND uploaded_by=?)",
+		hash, userId)
+	return
+}
+
+// deleteUnreferencedBlobs deletes the blobs no attachment refers to anymore, returning their hashes.
+func (d *db) deleteUnreferencedBlobs() (hashes []string, err error) {
+	err = d.pool.Select(&hashes, "DELETE FROM blobs WHERE ref_count <= 0 RETURNING hash")
+	return
+}
+
+// getBlobHashes returns the set of hashes of all blobs.
+func (d *db) getBlobHashes() (hashes map[string]bool, err error) {
+	var list []string
+	if err = d.pool.Select(&list, "SELECT hash FROM blobs"); err != nil {
+		return
+	}
+	hashes = make(map[string]bool, len(list))
+	for _, hash := range list {
+		hashes[hash] = true
+	}
+	return
+}
+
+func (d *db) getDocumentKey(id, userId int) (key []byte, err error) {
+	err = d.pool.Get(&key, "SELECT key_encrypted FROM document_keys WHERE user_id=? AND document_id=?", userId, id)
+	return
+}
+
+func (d *db) deleteDocument(id int) error {
+	return d.softDelete("documents", id)
+}
+
+func (d *db) restoreDocument(id int) error {
+	return d.restore("documents", id)
+}
+
+// getTrash retrieves everything in trash the user with userId has keys for. Devices are only included
+// if withDevices is set, as they aren't bound to keys.
+func (d *db) getTrash(userId int, withDevices bool) (items []TrashItem, err error) {
+	items = []TrashItem{}
+
+	var vaults []TrashItem
+	err = d.pool.Select(&vaults, `SELECT v.id, v.name, v.deleted_at FROM vaults v
+		INNER JOIN vault_keys vk ON v.id = vk.vault_id
+		WHERE vk.user_id=? AND v.deleted_at IS NOT NULL AND vk.expires_at IS NULL`, userId)
+	if err != nil {
+		return
+	}
+	for i := range vaults {
+		vaults[i].Type = ObjectVault
+	}
+	items = append(items, vaults...)
+
+	// Passwords of a vault in trash are restored along with the vault, so they aren't listed separately
+	var passwords []TrashItem
+	err = d.pool.Select(&passwords, `SELECT p.id, p.vault_id, p.name, p.deleted_at FROM passwords p
+		INNER JOIN vaults v ON p.vault_id = v.id
+		INNER JOIN vault_keys vk ON v.id = vk.vault_id
+		WHERE vk.user_id=? AND p.deleted_at IS NOT NULL AND v.deleted_at IS NULL AND vk.expires_at IS NULL`, userId)
+	if err != nil {
+		return
+	}
+	for i := range passwords {
+		passwords[i].Type = ObjectPassword
+	}
+	items = append(items, passwords...)
+
+	if withDevices {
+		var devices []TrashItem
+		err = d.pool.Select(&devices, `SELECT id, CASE WHEN name = '' THEN ip ELSE name END AS name, deleted_at
+			FROM devices WHERE deleted_at IS NOT NULL`)
+		if err != nil {
+			return
+		}
+		for i := range devices {
+			devices[i].Type = ObjectDevice
+		}
+		items = append(items, devices...)
+	}
+
+	var docs []TrashItem
+	err = d.pool.Select(&docs, `SELECT d.id, d.name, d.deleted_at FROM documents d
+		INNER JOIN document_keys dk ON d.id = dk.document_id WHERE dk.user_id=? AND d.deleted_at IS NOT NULL`, userId)
+	if err != nil {
+		return
+	}
+	for i := range docs {
+		docs[i].Type = ObjectDocument
+	}
+	items = append(items, docs...)
+
+	return
+}
+
+// purgeTrash permanently deletes everything that was moved to trash before cutoff.
+func (d *db) purgeTrash(cutoff time.Time) (n int64, err error) {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// Passwords and attachments get cascade deleted by sqlite along with their vaults and documents
+	for _, table := range []string{"passwords", "vaults", "devices", "documents"} {
+		res, err := tx.Exec("DELETE FROM "+table+" WHERE deleted_at < ?", cutoff.UTC())
+		if err != nil {
+			return 0, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		n += affected
+	}
+	if n > 0 {
+		if err = deleteOrphanedRefs(tx); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// objectRefColumns are the columns referencing objects by type and id.
+var objectRefColumns = []struct{ table, typeColumn, idColumn string }{
+	{"object_tags", "object_type", "object_id"},
+	{"favorites", "object_type", "object_id"},
+	{"relationships", "source_type", "source_id"},
+	{"relationships", "target_type", "target_id"},
+}
+
+// deleteOrphanedRefs removes rows referencing objects that no longer exist, as sqlite can't cascade
+// deletes to them. It must be called after hard-deleting objects, since their ids can be reused.
+func deleteOrphanedRefs(tx execer) error {
+	for _, c := range objectRefColumns {
+		typeColumn, idColumn := c.typeColumn, c.idColumn
+		_, err := tx.Exec(`DELETE FROM `+c.table+` WHERE
+			(`+typeColumn+`=? AND `+idColumn+` NOT IN (SELECT id FROM vaults)) OR
+			(`+typeColumn+`=? AND `+idColumn+` NOT IN (SELECT id FROM passwords)) OR
+			(`+typeColumn+`=? AND `+idColumn+` NOT IN (SELECT id FROM devices)) OR
+			(`+typeColumn+`=? AND `+idColumn+` NOT IN (SELECT id FROM documents))`,
+			ObjectVault, ObjectPassword, ObjectDevice, ObjectDocument)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM object_tags)")
+	return err
+}
+
+func (d *db) vaultExists(id int) (exists bool, err error) {
+	err = d.pool.Get(&exists, "SELECT EXISTS(SELECT 1 FROM vaults WHERE id=? AND deleted_at IS NULL)", id)
+	return
+}
+
+func (d *db) createAccessRequest(req AccessRequest) (id int, err error) {
+	res, err := d.pool.Exec(`INSERT INTO access_requests (vault_id, user_id, reason, duration, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		req.VaultID, req.UserID, req.Reason, req.Duration, AccessRequestPending, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	i, err := res.LastInsertId()
+	return int(i), err
+}
+
+const accessRequestQuery = `SELECT r.*, v.name AS vault_name, u.username FROM access_requests r
+	INNER JOIN vaults v ON r.vault_id = v.id
+	INNER JOIN users u ON r.user_id = u.id`
+
+func (d *db) getAccessRequest(id int) (req AccessRequest, err error) {
+	err = d.pool.Get(&req, accessRequestQuery+" WHERE r.id=?", id)
+	return
+}
+
+// getAccessRequests retrieves requests made by the user with userId along with pending requests
+// for vaults they have permanent access to.
+func (d *db) getAccessRequests(userId int) (reqs []AccessRequest, err error) {
+	reqs = []AccessRequest{}
+	err = d.pool.Select(&reqs, accessRequestQuery+` WHERE r.user_id=? OR (r.status=? AND r.vault_id IN
+		(SELECT vault_id FROM vault_keys WHERE user_id=? AND expires_at IS NULL))
+		ORDER BY r.created_at DESC`, userId, AccessRequestPending, userId)
+	return
+}
+
+// decideAccessRequest closes a pending request, returning sql.ErrNoRows if it isn't pending.
+func (d *db) decideAccessRequest(id int, status AccessRequestStatus, decidedBy int, expiresAt *time.Time) error {
+	return setAccessRequestDecision(d.pool, id, status, decidedBy, expiresAt)
+}
+
+// approveAccessRequest closes a pending request and grants the key in one transaction, so a request can't
+// stay pending with its access granted.
+func (d *db) approveAccessRequest(id, decidedBy int, key vaultKey) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = setAccessRequestDecision(tx, id, AccessRequestApproved, decidedBy, key.ExpiresAt); err != nil {
+		return err
+	}
+	if err = upsertVaultKey(tx, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setAccessRequestDecision(tx execer, id int, status AccessRequestStatus, decidedBy int,
+	expiresAt *time.Time) error {
+	res, err := tx.Exec(`UPDATE access_requests SET status=?, decided_by=?, decided_at=?, expires_at=?
+		WHERE id=? AND status=?`, status, decidedBy, time.Now().UTC(), expiresAt, id, AccessRequestPending)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+const checkoutQuery = `SELECT c.*, u.username FROM checkouts c INNER JOIN users u ON c.user_id = u.id`
+
+// createCheckout checks out a password, first ending its expired checkout if there is one. It fails with
+// a conflict if the password is still checked out.
+func (d *db) createCheckout(checkout Checkout) (id int, err error) {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if err = expireCheckouts(tx, time.Now(), "AND password_id=?", checkout.PasswordID); err != nil {
+		return
+	}
+
+	res, err := tx.Exec(`INSERT INTO checkouts (password_id, user_id, reason, checked_out_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		checkout.PasswordID, checkout.UserID, checkout.Reason, checkout.CheckedOutAt.UTC(), checkout.ExpiresAt.UTC())
+	if err != nil {
+		return
+	}
+	i, err := res.LastInsertId()
+	if err != nil {
+		return
+	}
+
+	return int(i), tx.Commit()
+}
+
+func (d *db) getActiveCheckout(passwordId int) (checkout Checkout, err error) {
+	err = d.pool.Get(&checkout, checkoutQuery+` WHERE c.password_id=? AND c.checked_in_at IS NULL AND c.expires_at > ?`,
+		passwordId, time.Now().UTC())
+	return
+}
+
+func (d *db) getCheckouts(passwordId int) (checkouts []Checkout, err error) {
+	checkouts = []Checkout{}
+	err = d.pool.Select(&checkouts, checkoutQuery+" WHERE c.password_id=? ORDER BY c.checked_out_at DESC", passwordId)
+	return
+}
+
+// checkIn ends a checkout and marks its password for rotation.
+func (d *db) checkIn(id, userId int) error {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE passwords SET rotation_required=1
+		WHERE id=(SELECT password_id FROM checkouts WHERE id=? AND checked_in_at IS NULL)`, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE checkouts SET checked_in_at=?, checked_in_by=? WHERE id=? AND checked_in_at IS NULL",
+		time.Now().UTC(), userId, id)
+	if err != nil {
+		return err
+	}
+	if err = requireAffected(res); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// expireCheckouts ends checkouts that lapsed before now, filtered by an extra condition, and marks their
+// passwords for rotation.
+func expireCheckouts(tx *sql.Tx, now time.Time, cond string, args ...any) error {
+	args = append([]any{now.UTC()}, args...)
+	_, err := tx.Exec(`UPDATE passwords SET rotation_required=1 WHERE id IN
+		(SELECT password_id FROM checkouts WHERE checked_in_at IS NULL AND expires_at <= ? `+cond+`)`, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE checkouts SET checked_in_at=expires_at
+		WHERE checked_in_at IS NULL AND expires_at <= ? `+cond, args...)
+	return err
+}
+
+func (d *db) expireAllCheckouts(now time.Time) error {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = expireCheckouts(tx, now, ""); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getObjectTitle retrieves the name of an object that isn't in trash, along with its vault if it's a
+// password. Devices without a name are titled by their IP.
+func (d *db) getObjectTitle(ref ObjectRef) (title string, vaultId int, err error) {
+	switch ref.Type {
+	case ObjectVault:
+		err = d.pool.Get(&title, "SELECT name FROM vaults WHERE id=? AND deleted_at IS NULL", ref.ID)
+	case ObjectPassword:
+		err = d.pool.QueryRow(`SELECT p.name, p.vault_id FROM passwords p INNER JOIN vaults v ON p.vault_id = v.id
+			WHERE p.id=? AND p.deleted_at IS NULL AND v.deleted_at IS NULL`, ref.ID).Scan(&title, &vaultId)
+	case ObjectDevice:
+		err = d.pool.Get(&title, "SELECT IIF(name = '', ip, name) FROM devices WHERE id=? AND deleted_at IS NULL",
+			ref.ID)
+	case ObjectDocument:
+		err = d.pool.Get(&title, "SELECT name FROM documents WHERE id=? AND deleted_at IS NULL", ref.ID)
+	default:
+		err = sql.ErrNoRows
+	}
+	return
+}
+
+func (d *db) getPasswordVaultId(id int) (vaultId int, err error) {
+	err = d.pool.Get(&vaultId, "SELECT vault_id FROM passwords WHERE id=? AND deleted_at IS NULL", id)
+	return
+}
+
+func (d *db) deviceExists(id int) (exists bool, err error) {
+	err = d.pool.Get(&exists, "SELECT EXISTS(SELECT 1 FROM devices WHERE id=? AND deleted_at IS NULL)", id)
+	return
+}
+
+// taggedObject is an object along with one of its tags.
+type taggedObject struct {
+	TagId   int    `db:"tag_id"`
+	TagName string `db:"name"`
+	ObjectRef
+}
+
+// getTaggedObjects retrieves every tag of every object, ordered by tag name.
+func (d *db) getTaggedObjects() (objects []taggedObject, err error) {
+	err = d.pool.Select(&objects, `SELECT ot.tag_id, t.name, ot.object_type, ot.object_id FROM object_tags ot
+		INNER JOIN tags t ON ot.tag_id = t.id ORDER BY t.name, t.id`)
+	return
+}
+
+// getTags retrieves the tag names of all objects of objectType, keyed by object id.
+func (d *db) getTags(objectType ObjectType) (tags map[int][]string, err error) {
+	var rows []struct {
+		ObjectId int    `db:"object_id"`
+		Name     string `db:"name"`
+	}
+	err = d.pool.Select(&rows, `SELECT ot.object_id, t.name FROM object_tags ot
+		INNER JOIN tags t ON ot.tag_id = t.id WHERE ot.object_type=? ORDER BY t.name`, objectType)
+	if err != nil {
+		return
+	}
+
+	tags = make(map[int][]string)
+	for _, row := range rows {
+		tags[row.ObjectId] = append(tags[row.ObjectId], row.Name)
+	}
+	return
+}
+
+// setTags replaces the tags of an object.
+func (d *db) setTags(ref ObjectRef, names []string) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM object_tags WHERE object_type=? AND object_id=?", ref.Type, ref.ID)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err = tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT DO NOTHING", name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO object_tags (tag_id, object_type, object_id)
+			SELECT id, ?, ? FROM tags WHERE name=? ON CONFLICT DO NOTHING`, ref.Type, ref.ID, name)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM object_tags)")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getFavorites retrieves the objects the user with userId marked as favorite.
+func (d *db) getFavorites(userId int) (favorites []ObjectRef, err error) {
+	favorites = []ObjectRef{}
+	err = d.pool.Select(&favorites, "SELECT object_type, object_id FROM favorites WHERE user_id=?", userId)
+	return
+}
+
+func (d *db) addFavorite(userId int, ref ObjectRef) error {
+	_, err := d.pool.Exec(`INSERT INTO favorites (user_id, object_type, object_id) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`, userId, ref.Type, ref.ID)
+	return err
+}
+
+func (d *db) removeFavorite(userId int, ref ObjectRef) error {
+	res, err := d.pool.Exec("DELETE FROM favorites WHERE user_id=? AND object_type=? AND object_id=?",
+		userId, ref.Type, ref.ID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (d *db) getFolders() (folders []Folder, err error) {
+	folders = []Folder{}
+	err = d.pool.Select(&folders, "SELECT * FROM folders ORDER BY name")
+	return
+}
+
+func (d *db) createFolder(folder Folder) (id int, err error) {
+	res, err := d.pool.Exec("INSERT INTO folders (name, parent_id) VALUES (?, ?)", folder.Name, folder.ParentID)
+	if err != nil {
+		return 0, err
+	}
+	i, err := res.LastInsertId()
+	return int(i), err
+}
+
+func (d *db) renameFolder(id int, name string) error {
+	res, err := d.pool.Exec("UPDATE folders SET name=? WHERE id=?", name, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (d *db) moveFolder(id int, parentId *int) error {
+	res, err := d.pool.Exec("UPDATE folders SET parent_id=? WHERE id=?", parentId, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// deleteFolder deletes a folder that has no subfolders, vaults or documents outside of trash. Trashed
+// objects are moved to the top level, where their names don't count as taken. Restoring one whose name is
+// taken there conflicts.
+func (d *db) deleteFolder(id int) error {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var empty bool
+	err = tx.QueryRow(`SELECT NOT EXISTS(SELECT 1 FROM folders WHERE parent_id=?1) AND
+		NOT EXISTS(SELECT 1 FROM vaults WHERE folder_id=?1 AND deleted_at IS NULL) AND
+		NOT EXISTS(SELECT 1 FROM documents WHERE folder_id=?1 AND deleted_at IS NULL)`, id).Scan(&empty)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return ErrFolderNotEmpty
+	}
+
+	// Only trashed objects are left, and the names of those aren't unique, so trashed vaults can't collide with
+	// those already at the top level
+	for _, table := range []string{"vaults", "documents"} {
+		_, err = tx.Exec("UPDATE "+table+" SET folder_id=NULL WHERE folder_id=? AND deleted_at IS NOT NULL", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM folders WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	if err = requireAffected(res); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getFolderItems retrieves the vaults and documents directly in a folder, or at the top level if folderId is
+// nil, that the user with userId has keys for.
+func (d *db) getFolderItems(folderId *int, userId int) (items []FolderItem, err error) {
+	items = []FolderItem{}
+	err = d.pool.Select(&items, `SELECT ?1 AS type, v.id, v.name FROM vaults v
+		INNER JOIN vault_keys vk ON v.id = vk.vault_id
+		WHERE vk.user_id=?3 AND v.folder_id IS ?4 AND v.deleted_at IS NULL AND (vk.expires_at IS NULL OR vk.expires_at > ?5)
+		UNION ALL
+		SELECT ?2 AS type, d.id, d.name FROM documents d
+		INNER JOIN document_keys dk ON d.id = dk.document_id
+		WHERE dk.user_id=?3 AND d.folder_id IS ?4 AND d.deleted_at IS NULL
+		ORDER BY name`, ObjectVault, ObjectDocument, userId, folderId, time.Now().UTC())
+	return
+}
+
+// moveVault moves a vault into a folder and gives keys to its default users in one transaction.
+func (d *db) moveVault(id int, folderId *int, keys []vaultKey) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE vaults SET folder_id=? WHERE id=? AND deleted_at IS NULL", folderId, id)
+	if err != nil {
+		return err
+	}
+	if err = requireAffected(res); err != nil {
+		return err
+	}
+	if err = insertVaultKeys(tx, keys); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveDocument moves a document into a folder and gives keys to its default users in one transaction.
+func (d *db) moveDocument(id int, folderId *int, keys []documentKey) error {
+	tx, err := d.pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE documents SET folder_id=? WHERE id=? AND deleted_at IS NULL", folderId, id)
+	if err != nil {
+		return err
+	}
+	if err = requireAffected(res); err != nil {
+		return err
+	}
+	if err = insertDocumentKeys(tx, keys); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getFolderShares retrieves the users the folders are shared with by default.
+func (d *db) getFolderShares(folderIds ...int) (users []User, err error) {
+	users = []User{}
+	if len(folderIds) == 0 {
+		return
+	}
+	query, args, err := sqlx.In(`SELECT DISTINCT u.id, u.username, u.role, u.public_key FROM users u
+		INNER JOIN folder_shares fs ON u.id = fs.user_id WHERE fs.folder_id IN (?) ORDER BY u.username`, folderIds)
+	if err != nil {
+		return
+	}
+	err = d.pool.Select(&users, query, args...)
+	return
+}
+
+func (d *db) setFolderShares(folderId int, userIds []int) error {
+	tx, err := d.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM folder_shares WHERE folder_id=?", folderId); err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		_, err = tx.Exec("INSERT INTO folder_shares (folder_id, user_id) VALUES (?, ?)", folderId, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type relationshipRow struct {
+	ID         int          `db:"id"`
+	SourceType ObjectType   `db:"source_type"`
+	SourceID   int          `db:"source_id"`
+	Kind       RelationKind `db:"kind"`
+	TargetType ObjectType   `db:"target_type"`
+	TargetID   int          `db:"target_id"`
+	CreatedAt  time.Time    `db:"created_at"`
+}
+
+func (r relationshipRow) relationship() Relationship {
+	return Relationship{
+		ID:        r.ID,
+		Source:    ObjectRef{Type: r.SourceType, ID: r.SourceID},
+		Kind:      r.Kind,
+		Target:    ObjectRef{Type: r.TargetType, ID: r.TargetID},
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+func (d *db) createRelationship(rel Relationship) (id int, err error) {
+	res, err := d.pool.Exec(`INSERT INTO relationships (source_type, source_id, kind, target_type, target_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, rel.Source.Type, rel.Source.ID, rel.Kind, rel.Target.Type, rel.Target.ID,
+		time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	i, err := res.LastInsertId()
+	return int(i), err
+}
+
+func (d *db) getRelationship(id int) (rel Relationship, err error) {
+	var row relationshipRow
+	if err = d.pool.Get(&row, "SELECT * FROM relationships WHERE id=?", id); err != nil {
+		return
+	}
+	return row.relationship(), nil
+}
+
+// getRelationships retrieves the relationships the object is the source or target of.
+func (d *db) getRelationships(ref ObjectRef) (rels []Relationship, err error) {
+	var rows []relationshipRow
+	err = d.pool.Select(&rows, `SELECT * FROM relationships
+		WHERE (source_type=?1 AND source_id=?2) OR (target_type=?1 AND target_id=?2) ORDER BY id`, ref.Type, ref.ID)
+	if err != nil {
+		return
+	}
+	rels = make([]Relationship, len(rows))
+	for i, row := range rows {
+		rels[i] = row.relationship()
+	}
+	return
+}
+
+func (d *db) deleteRelationship(id int) error {
+	res, err := d.pool.Exec("DELETE FROM relationships WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// raiseAlert saves the alert, or counts it as a repeat of the unacknowledged alert with the same key. Returns
+// whether it's new.
+func (d *db) raiseAlert(alert Alert) (raised bool, err error) {
+	var count int
+	err = d.pool.Get(&count, `INSERT INTO alerts (type, dedup_key, message, target, ip, mac, device_id, created_at,
+			updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (dedup_key) WHERE acknowledged_at IS NULL DO UPDATE SET count=count+1,
+			updated_at=excluded.updated_at
+		RETURNING count`, alert.Type, alert.Key, alert.Message, alert.Target, alert.IP, alert.MAC, alert.DeviceID,
+		alert.CreatedAt.UTC(), alert.CreatedAt.UTC())
+	return count == 1, err
+}
+
+// isAlertAcknowledged reports whether an alert with the key has been acknowledged.
+func (d *db) isAlertAcknowledged(key string) (acknowledged bool, err error) {
+	err = d.pool.Get(&acknowledged, `SELECT EXISTS(SELECT 1 FROM alerts
+		WHERE dedup_key=? AND acknowledged_at IS NOT NULL)`, key)
+	return
+}
+
+// getAlerts retrieves the unacknowledged alerts, or all of them, latest first.
+func (d *db) getAlerts(all bool) (alerts []Alert, err error) {
+	alerts = []Alert{}
+	err = d.pool.Select(&alerts, `SELECT * FROM alerts WHERE ? OR acknowledged_at IS NULL ORDER BY updated_at DESC`,
+		all)
+	return
+}
+
+// acknowledgeAlert closes an alert, returning sql.ErrNoRows if it's already acknowledged.
+func (d *db) acknowledgeAlert(id, userId int) error {
+	res, err := d.pool.Exec(`UPDATE alerts SET acknowledged_at=?, acknowledged_by=?
+		WHERE id=? AND acknowledged_at IS NULL`, time.Now().UTC(), userId, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
 }
@@ -0,0 +1,222 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+// DiffLine is a line of a line diff. OldLine and NewLine are 1-based and zero if the line is missing from
+// that side.
+type DiffLine struct {
+	Op      DiffOp `json:"op"`
+	Text    string `json:"text"`
+	OldLine int    `json:"oldLine,omitempty"`
+	NewLine int    `json:"newLine,omitempty"`
+}
+
+// Diff is the difference between two revisions of a document. To is 0 if it's the current state of the
+// document.
+type Diff struct {
+	From  int        `json:"from"`
+	To    int        `json:"to"`
+	Lines []DiffLine `json:"lines"`
+}
+
+// maxDiffEdits caps the work done by diffLines, as memory use grows with the square of the edit count.
+const maxDiffEdits = 2000
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes the shortest line diff from a to b using Myers' algorithm. If they differ by more
+// than maxDiffEdits lines the diff replaces all of a with b instead.
+func diffLines(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	// trace[d][k+d] is the furthest x reached on diagonal k after d edits
+	var trace [][]int
+	v := []int{0}
+
+search:
+	for d := 0; ; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+
+		next := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+d-1] < v[k+1+d-1]) {
+				if d == 0 {
+					x = 0
+				} else {
+					x = v[k+1+d-1]
+				}
+			} else {
+				x = v[k-1+d-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			next[k+d] = x
+			if x >= n && y >= m {
+				trace = append(trace, next)
+				break search
+			}
+		}
+		trace = append(trace, next)
+		v = next
+	}
+
+	var lines []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, DiffLine{Op: DiffEqual, Text: a[x-1], OldLine: x, NewLine: y})
+			x--
+			y--
+		}
+		if x == prevX {
+			lines = append(lines, DiffLine{Op: DiffInsert, Text: b[y-1], NewLine: y})
+			y--
+		} else {
+			lines = append(lines, DiffLine{Op: DiffDelete, Text: a[x-1], OldLine: x})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		lines = append(lines, DiffLine{Op: DiffEqual, Text: a[x-1], OldLine: x, NewLine: y})
+		x--
+		y--
+	}
+
+	slices.Reverse(lines)
+	if lines == nil {
+		lines = []DiffLine{}
+	}
+	return lines
+}
+
+func replaceLines(a, b []string) []DiffLine {
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for i, line := range a {
+		lines = append(lines, DiffLine{Op: DiffDelete, Text: line, OldLine: i + 1})
+	}
+	for i, line := range b {
+		lines = append(lines, DiffLine{Op: DiffInsert, Text: line, NewLine: i + 1})
+	}
+	return lines
+}
+
+// Unified formats the diff like diff -u with context lines around each change.
+func (d Diff) Unified(context int) string {
+	var sb strings.Builder
+	to := "current"
+	if d.To != 0 {
+		to = fmt.Sprintf("revision %d", d.To)
+	}
+	fmt.Fprintf(&sb, "--- revision %d\n+++ %s\n", d.From, to)
+
+	lines := d.Lines
+	for start := 0; start < len(lines); {
+		// Find the next change and extend the hunk until changes are more than 2*context lines apart
+		first := slices.IndexFunc(lines[start:], func(l DiffLine) bool { return l.Op != DiffEqual })
+		if first == -1 {
+			break
+		}
+		first += start
+		last := first
+		for i := first + 1; i < len(lines) && i-last <= 2*context; i++ {
+			if lines[i].Op != DiffEqual {
+				last = i
+			}
+		}
+
+		from := max(first-context, start)
+		to := min(last+context+1, len(lines))
+		hunk := lines[from:to]
+
+		var oldStart, oldCount, newStart, newCount int
+		for _, l := range hunk {
+			if l.Op != DiffInsert {
+				if oldStart == 0 {
+					oldStart = l.OldLine
+				}
+				oldCount++
+			}
+			if l.Op != DiffDelete {
+				if newStart == 0 {
+					newStart = l.NewLine
+				}
+				newCount++
+			}
+		}
+		// Empty ranges start at the line before them, like in diff -u
+		if oldCount == 0 {
+			oldStart = oldLineBefore(lines, from)
+		}
+		if newCount == 0 {
+			newStart = newLineBefore(lines, from)
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, l := range hunk {
+			switch l.Op {
+			case DiffEqual:
+				sb.WriteByte(' ')
+			case DiffInsert:
+				sb.WriteByte('+')
+			case DiffDelete:
+				sb.WriteByte('-')
+			}
+			sb.WriteString(l.Text)
+			sb.WriteByte('\n')
+		}
+
+		start = to
+	}
+	return sb.String()
+}
+
+func oldLineBefore(lines []DiffLine, i int) int {
+	for i--; i >= 0; i-- {
+		if lines[i].OldLine != 0 {
+			return lines[i].OldLine
+		}
+	}
+	return 0
+}
+
+func newLineBefore(lines []DiffLine, i int) int {
+	for i--; i >= 0; i-- {
+		if lines[i].NewLine != 0 {
+			return lines[i].NewLine
+		}
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"empty", "", "", []DiffLine{}},
+		{"identical", "a\nb\n", "a\nb\n", []DiffLine{
+			{Op: DiffEqual, Text: "a", OldLine: 1, NewLine: 1},
+			{Op: DiffEqual, Text: "b", OldLine: 2, NewLine: 2},
+		}},
+		{"pure insert", "", "a\nb\n", []DiffLine{
+			{Op: DiffInsert, Text: "a", NewLine: 1},
+			{Op: DiffInsert, Text: "b", NewLine: 2},
+		}},
+		{"pure delete", "a\nb\n", "", []DiffLine{
+			{Op: DiffDelete, Text: "a", OldLine: 1},
+			{Op: DiffDelete, Text: "b", OldLine: 2},
+		}},
+		{"insert in the middle", "a\nc\n", "a\nb\nc\n", []DiffLine{
+			{Op: DiffEqual, Text: "a", OldLine: 1, NewLine: 1},
+			{Op: DiffInsert, Text: "b", NewLine: 2},
+			{Op: DiffEqual, Text: "c", OldLine: 2, NewLine: 3},
+		}},
+		{"delete in the middle", "a\nb\nc\n", "a\nc\n", []DiffLine{
+			{Op: DiffEqual, Text: "a", OldLine: 1, NewLine: 1},
+			{Op: DiffDelete, Text: "b", OldLine: 2},
+			{Op: DiffEqual, Text: "c", OldLine: 3, NewLine: 2},
+		}},
+		{"replace", "a\nb\nc\n", "a\nx\nc\n", []DiffLine{
+			{Op: DiffEqual, Text: "a", OldLine: 1, NewLine: 1},
+			{Op: DiffDelete, Text: "b", OldLine: 2},
+			{Op: DiffInsert, Text: "x", NewLine: 2},
+			{Op: DiffEqual, Text: "c", OldLine: 3, NewLine: 3},
+		}},
+		{"missing final newline", "a\nb", "a\nb\n", []DiffLine{
+			{Op: DiffEqual, Text: "a", OldLine: 1, NewLine: 1},
+			{Op: DiffEqual, Text: "b", OldLine: 2, NewLine: 2},
+		}},
+	}
+	for _, test := range tests {
+		got := diffLines(splitLines(test.a), splitLines(test.b))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// distinctLines returns n lines differing from each other and from those with another prefix.
+func distinctLines(prefix string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return lines
+}
+
+func TestDiffLinesFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		changed  int
+		fallback bool
+	}{
+		{"under the limit", maxDiffEdits/2 - 1, false},
+		{"at the limit", maxDiffEdits / 2, false},
+		{"over the limit", maxDiffEdits/2 + 1, true},
+	}
+	for _, test := range tests {
+		// A common first line, then changed lines that take two edits each
+		a := append([]string{"same"}, distinctLines("a", test.changed)...)
+		b := append([]string{"same"}, distinctLines("b", test.changed)...)
+		got := diffLines(a, b)
+
+		if len(got) != len(a)+len(b)-1 && !test.fallback {
+			t.Errorf("%s: got %d lines, want %d", test.name, len(got), len(a)+len(b)-1)
+		}
+		if test.fallback && !reflect.DeepEqual(got, replaceLines(a, b)) {
+			t.Errorf("%s: diff didn't fall back to replacing all lines", test.name)
+		}
+		if !test.fallback && got[0].Op != DiffEqual {
+			t.Errorf("%s: common line got %q, want %q", test.name, got[0].Op, DiffEqual)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	numbered := func(n int, replace map[int]string) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			if line, ok := replace[i]; ok {
+				if line != "" {
+					sb.WriteString(line + "\n")
+				}
+				continue
+			}
+			fmt.Fprintf(&sb, "%d\n", i)
+		}
+		return sb.String()
+	}
+
+	tests := []struct {
+		name string
+		to   int
+		a, b string
+		want string
+	}{
+		{"no changes", 0, "a\nb\n", "a\nb\n",
+			"--- revision 1\n+++ current\n"},
+		{"pure insert", 3, "", "a\nb\n",
+			"--- revision 1\n+++ revision 3\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"pure delete", 0, "a\nb\n", "",
+			"--- revision 1\n+++ current\n@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"change with context", 0, numbered(10, nil), numbered(10, map[int]string{5: "x"}),
+			"--- revision 1\n+++ current\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n"},
+		{"insert after a line", 0, numbered(6, nil), "1\n2\n3\nx\n4\n5\n6\n",
+			"--- revision 1\n+++ current\n@@ -1,6 +1,7 @@\n 1\n 2\n 3\n+x\n 4\n 5\n 6\n"},
+		{"delete at the start", 0, numbered(8, nil), numbered(8, map[int]string{1: ""}),
+			"--- revision 1\n+++ current\n@@ -1,4 +1,3 @@\n-1\n 2\n 3\n 4\n"},
+		{"changes joined in one hunk", 0, numbered(12, nil), numbered(12, map[int]string{3: "x", 9: "y"}),
+			"--- revision 1\n+++ current\n@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+x\n 4\n 5\n 6\n 7\n 8\n-9\n+y\n 10\n 11\n 12\n"},
+		{"changes in separate hunks", 0, numbered(20, nil), numbered(20, map[int]string{3: "x", 17: ""}),
+			"--- revision 1\n+++ current\n@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+x\n 4\n 5\n 6\n" +
+				"@@ -14,7 +14,6 @@\n 14\n 15\n 16\n-17\n 18\n 19\n 20\n"},
+	}
+	for _, test := range tests {
+		d := Diff{From: 1, To: test.to, Lines: diffLines(splitLines(test.a), splitLines(test.b))}
+		if got := d.Unified(3); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
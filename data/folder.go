@@ -0,0 +1,278 @@
+package data
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"github.com/TaeKwonZeus/pva/crypt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrFolderNotFound is returned when a folder to put something in doesn't exist.
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+	ErrFolderCycle    = errors.New("folder can't be moved into itself")
+)
+
+// folderTree indexes all folders by id for resolving paths.
+type folderTree map[int]Folder
+
+func (s *Store) getFolderTree() (folderTree, error) {
+	folders, err := s.db.getFolders()
+	if err != nil {
+		return nil, err
+	}
+	tree := make(folderTree, len(folders))
+	for _, f := range folders {
+		tree[f.ID] = f
+	}
+	return tree, nil
+}
+
+// path returns the folder with id and its ancestors, starting from the top. Returns an empty path for nil.
+func (t folderTree) path(id *int) []FolderRef {
+	path := []FolderRef{}
+	// Bounded by the number of folders in case the tree was corrupted into a cycle
+	for i := 0; id != nil && i < len(t); i++ {
+		f, ok := t[*id]
+		if !ok {
+			break
+		}
+		path = append(path, FolderRef{ID: f.ID, Name: f.Name})
+		id = f.ParentID
+	}
+	slices.Reverse(path)
+	return path
+}
+
+func (t folderTree) folder(id int) Folder {
+	f := t[id]
+	f.Path = t.path(f.ParentID)
+	return f
+}
+
+// exists reports whether id is nil, meaning the top level, or an existing folder.
+func (t folderTree) exists(id *int) bool {
+	if id == nil {
+		return true
+	}
+	_, ok := t[*id]
+	return ok
+}
+
+func (s *Store) checkFolder(id *int) error {
+	if id == nil {
+		return nil
+	}
+	tree, err := s.getFolderTree()
+	if err != nil {
+		return err
+	}
+	if !tree.exists(id) {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// folderDefaultUsers retrieves the users everything in the folder gets shared with, including those
+// inherited from its ancestors.
+func (s *Store) folderDefaultUsers(id *int) ([]User, error) {
+	if id == nil {
+		return nil, nil
+	}
+	tree, err := s.getFolderTree()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, ref := range tree.path(id) {
+		ids = append(ids, ref.ID)
+	}
+	return s.db.getFolderShares(ids...)
+}
+
+// GetFolder lists the subfolders of a folder along with the vaults and documents in it the user has access to.
+// If id is nil the top level is listed.
+func (s *Store) GetFolder(id *int, user User) (listing FolderListing, err error) {
+	tree, err := s.getFolderTree()
+	if err != nil {
+		return
+	}
+	if !tree.exists(id) {
+		return listing, sql.ErrNoRows
+	}
+	if id != nil {
+		f := tree.folder(*id)
+		listing.Folder = &f
+	}
+
+	listing.Folders = []Folder{}
+	for _, f := range tree {
+		if (f.ParentID == nil && id == nil) || (f.ParentID != nil && id != nil && *f.ParentID == *id) {
+			listing.Folders = append(listing.Folders, tree.folder(f.ID))
+		}
+	}
+	slices.SortFunc(listing.Folders, func(a, b Folder) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+
+	listing.Items, err = s.db.getFolderItems(id, user.ID)
+	return
+}
+
+// CreateFolder creates a folder, returning ErrFolderNotFound if its parent doesn't exist.
+func (s *Store) CreateFolder(folder Folder) (id int, err error) {
+	if err = s.checkFolder(folder.ParentID); err != nil {
+		return
+	}
+	return s.db.createFolder(folder)
+}
+
+func (s *Store) RenameFolder(id int, name string) error {
+	return s.db.renameFolder(id, name)
+}
+
+// MoveFolder moves a folder under parentId, or to the top level if it's nil. Returns ErrFolderCycle if the
+// parent is the folder itself or one of its subfolders.
+func (s *Store) MoveFolder(id int, parentId *int) error {
+	tree, err := s.getFolderTree()
+	if err != nil {
+		return err
+	}
+	if _, ok := tree[id]; !ok {
+		return sql.ErrNoRows
+	}
+	if !tree.exists(parentId) {
+		return ErrFolderNotFound
+	}
+	if slices.ContainsFunc(tree.path(parentId), func(ref FolderRef) bool { return ref.ID == id }) {
+		return ErrFolderCycle
+	}
+	return s.db.moveFolder(id, parentId)
+}
+
+// DeleteFolder deletes an empty folder, returning ErrFolderNotEmpty otherwise.
+func (s *Store) DeleteFolder(id int) error {
+	return s.db.deleteFolder(id)
+}
+
+// FolderShares holds the users a folder's contents get shared with by default.
+type FolderShares struct {
+	Users []string `json:"users"`
+	// Inherited are the users of ancestor folders
+	Inherited []string `json:"inherited"`
+}
+
+func (s *Store) GetFolderShares(id int) (shares FolderShares, err error) {
+	tree, err := s.getFolderTree()
+	if err != nil {
+		return
+	}
+	if _, ok := tree[id]; !ok {
+		return shares, sql.ErrNoRows
+	}
+
+	shares.Users, shares.Inherited = []string{}, []string{}
+	users, err := s.db.getFolderShares(id)
+	if err != nil {
+		return
+	}
+	for _, u := range users {
+		shares.Users = append(shares.Users, u.Username)
+	}
+
+	var ancestors []int
+	for _, ref := range tree.path(tree[id].ParentID) {
+		ancestors = append(ancestors, ref.ID)
+	}
+	inherited, err := s.db.getFolderShares(ancestors...)
+	if err != nil {
+		return
+	}
+	for _, u := range inherited {
+		shares.Inherited = append(shares.Inherited, u.Username)
+	}
+	return
+}
+
+// SetFolderShares replaces the users vaults and documents created in or moved into the folder get shared
+// with. Existing contents aren't affected.
+func (s *Store) SetFolderShares(id int, users []User) error {
+	if err := s.checkFolder(&id); err != nil {
+		if errors.Is(err, ErrFolderNotFound) {
+			return sql.ErrNoRows
+		}
+		return err
+	}
+	ids := make([]int, 0, len(users))
+	for _, u := range users {
+		if !slices.Contains(ids, u.ID) {
+			ids = append(ids, u.ID)
+		}
+	}
+	return s.db.setFolderShares(id, ids)
+}
+
+// wrapForFolder encrypts key for each of the folder's default users, mapping user ids to encrypted keys.
+func (s *Store) wrapForFolder(folderId *int, key []byte) (map[int][]byte, error) {
+	users, err := s.folderDefaultUsers(folderId)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make(map[int][]byte, len(users))
+	for _, u := range users {
+		wrapped[u.ID], err = crypt.RsaEncrypt(key, u.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return wrapped, nil
+}
+
+// MoveVault moves a vault into a folder, or to the top level if folderId is nil, sharing it with the
+// folder's default users. Users who already have access keep it as it is.
+func (s *Store) MoveVault(id int, folderId *int, user User) error {
+	if err := s.checkFolder(folderId); err != nil {
+		return err
+	}
+
+	s.rotationMu.Lock()
+	defer s.rotationMu.Unlock()
+
+	key, err := s.vaultKeyForWrite(id, user)
+	if err != nil {
+		return err
+	}
+	wrapped, err := s.wrapForFolder(folderId, key)
+	if err != nil {
+		return err
+	}
+	keys := make([]vaultKey, 0, len(wrapped))
+	for userId, keyEncrypted := range wrapped {
+		keys = append(keys, vaultKey{UserId: userId, VaultId: id, KeyEncrypted: keyEncrypted})
+	}
+	return s.db.moveVault(id, folderId, keys)
+}
+
+// MoveDocument moves a document into a folder, or to the top level if folderId is nil, sharing it with the
+// folder's default users.
+func (s *Store) MoveDocument(id int, folderId *int, user User) error {
+	if err := s.checkFolder(folderId); err != nil {
+		return err
+	}
+	key, err := s.getDecryptedDocumentKey(id, user)
+	if err != nil {
+		return err
+	}
+	wrapped, err := s.wrapForFolder(folderId, key)
+	if err != nil {
+		return err
+	}
+	keys := make([]documentKey, 0, len(wrapped))
+	for userId, keyEncrypted := range wrapped {
+		keys = append(keys, documentKey{UserId: userId, DocumentId: id, KeyEncrypted: keyEncrypted})
+	}
+	return s.db.moveDocument(id, folderId, keys)
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMoveIntoSharedFolder(t *testing.T) {
+	s := newTestStore(t)
+	owner := newTestUser(t, s, "owner", RoleManager)
+	parentUser := newTestUser(t, s, "parent", RoleManager)
+	childUser := newTestUser(t, s, "child", RoleManager)
+	guest := newTestUser(t, s, "guest", RoleManager)
+	outsider := newTestUser(t, s, "outsider", RoleManager)
+
+	parentId, err := s.CreateFolder(Folder{Name: "Infrastructure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	childId, err := s.CreateFolder(Folder{Name: "Servers", ParentID: &parentId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetFolderShares(parentId, []User{parentUser}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetFolderShares(childId, []User{childUser, guest}); err != nil {
+		t.Fatal(err)
+	}
+
+	vaultId := newTestVault(t, s, "Servers", owner)
+	if err = s.CreatePassword(Password{Name: "root", Password: "secret"}, vaultId, owner); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if err = s.ShareVault(vaultId, guest, owner, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := childId + 100
+	if err = s.MoveVault(vaultId, &missing, owner); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("moving into a missing folder returned %v", err)
+	}
+	if err = s.MoveVault(vaultId, &childId, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// The vault is shared with the users of the folder and those inherited from its parent
+	for _, u := range []User{parentUser, childUser} {
+		if p := vaultPasswords(t, s, vaultId, u); p["root"] != "secret" {
+			t.Errorf("%s can't read the vault moved into their folder", u.Username)
+		}
+	}
+	if s.CheckVaultOwnership(vaultId, outsider) {
+		t.Error("moving the vault shared it with a user outside the folder")
+	}
+	if s.CheckVaultAdministration(vaultId, guest) {
+		t.Error("moving the vault made time-limited access permanent")
+	}
+
+	doc := Document{Name: "Runbook", Payload: "restart everything"}
+	docId, err := s.CreateDocument(doc, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.MoveDocument(docId, &childId, owner); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []User{parentUser, childUser} {
+		got, err := s.GetDocument(docId, u)
+		if err != nil {
+			t.Fatalf("%s can't open the document moved into their folder: %v", u.Username, err)
+		}
+		if got.Payload != doc.Payload {
+			t.Errorf("%s got payload %q", u.Username, got.Payload)
+		}
+	}
+	if s.CheckDocumentOwnership(docId, outsider) {
+		t.Error("moving the document shared it with a user outside the folder")
+	}
+
+	listing, err := s.GetFolder(&childId, parentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Items) != 2 {
+		t.Errorf("got %d items in the folder, want the vault and the document", len(listing.Items))
+	}
+	if len(listing.Folder.Path) != 1 || listing.Folder.Path[0].ID != parentId {
+		t.Errorf("got folder path %v", listing.Folder.Path)
+	}
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"github.com/TaeKwonZeus/pva/crypt"
+	"testing"
+	"time"
+)
+
+func TestGrantExpiry(t *testing.T) {
+	s := newTestStore(t)
+	owner := newTestUser(t, s, "owner", RoleManager)
+	guest := newTestUser(t, s, "guest", RoleManager)
+	vaultId := newTestVault(t, s, "Servers", owner)
+	if err := s.CreatePassword(Password{Name: "root", Password: "secret"}, vaultId, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := s.ShareVault(vaultId, guest, owner, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if !s.CheckVaultOwnership(vaultId, guest) {
+		t.Fatal("guest can't open the vault shared with them")
+	}
+	if s.CheckVaultAdministration(vaultId, guest) {
+		t.Error("time-limited access allows sharing the vault")
+	}
+	if !s.CheckVaultAdministration(vaultId, owner) {
+		t.Error("permanent access doesn't allow sharing the vault")
+	}
+
+	// Keys handed out along with folders leave existing access as it is
+	key, err := s.getDecryptedVaultKey(vaultId, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyEncrypted, err := crypt.RsaEncrypt(key, guest.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.createVaultKeys(vaultKey{UserId: guest.ID, VaultId: vaultId, KeyEncrypted: keyEncrypted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CheckVaultAdministration(vaultId, guest) {
+		t.Error("a folder grant made time-limited access permanent")
+	}
+
+	// The old key can't read anything stored after the grant lapses
+	oldKey, err := s.getDecryptedVaultKey(vaultId, guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.expireVaultKeys(expiresAt.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if s.CheckVaultOwnership(vaultId, guest) {
+		t.Error("guest can open the vault after their access lapsed")
+	}
+	if err = s.CreatePassword(Password{Name: "admin", Password: "new secret"}, vaultId, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	vault, err := s.db.getVault(vaultId, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vault.KeyRotationPending {
+		t.Error("key wasn't rotated before writing to the vault")
+	}
+	for _, p := range vault.Passwords {
+		if _, err = crypt.AesDecrypt(p.PasswordEncrypted, oldKey); err == nil {
+			t.Errorf("password %s can be decrypted with the key of lapsed access", p.Name)
+		}
+	}
+	passwords := vaultPasswords(t, s, vaultId, owner)
+	if passwords["root"] != "secret" || passwords["admin"] != "new secret" {
+		t.Errorf("got passwords %v after rotation", passwords)
+	}
+}
+
+func TestGrantRotationOnRead(t *testing.T) {
+	s := newTestStore(t)
+	owner := newTestUser(t, s, "owner", RoleManager)
+	guest := newTestUser(t, s, "guest", RoleManager)
+	vaultId := newTestVault(t, s, "Servers", owner)
+	if err := s.CreatePassword(Password{Name: "root", Password: "secret"}, vaultId, owner); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if err := s.ShareVault(vaultId, guest, owner, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.expireVaultKeys(expiresAt.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the vault rotates the key of remaining holders
+	if got := vaultPasswords(t, s, vaultId, owner)["root"]; got != "secret" {
+		t.Errorf("got password %q after rotation, want %q", got, "secret")
+	}
+	vault, err := s.db.getVault(vaultId, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vault.KeyRotationPending {
+		t.Error("key wasn't rotated when opening the vault")
+	}
+}
+
+func TestGrantUpgrade(t *testing.T) {
+	s := newTestStore(t)
+	owner := newTestUser(t, s, "owner", RoleManager)
+	guest := newTestUser(t, s, "guest", RoleManager)
+	vaultId := newTestVault(t, s, "Servers", owner)
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := s.ShareVault(vaultId, guest, owner, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ShareVault(vaultId, guest, owner, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !s.CheckVaultAdministration(vaultId, guest) {
+		t.Error("sharing permanently didn't make access permanent")
+	}
+	// Permanent access is never limited again
+	if err := s.ShareVault(vaultId, guest, owner, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if !s.CheckVaultAdministration(vaultId, guest) {
+		t.Error("sharing for a limited time limited permanent access")
+	}
+}
+
+func TestApproveAccessRequest(t *testing.T) {
+	s := newTestStore(t)
+	owner := newTestUser(t, s, "owner", RoleManager)
+	guest := newTestUser(t, s, "guest", RoleManager)
+	vaultId := newTestVault(t, s, "Servers", owner)
+
+	err := s.CreateAccessRequest(AccessRequest{VaultID: vaultId, UserID: guest.ID, Reason: "outage", Duration: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := s.GetAccessRequests(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("owner sees %d requests, want 1", len(reqs))
+	}
+	if err = s.ApproveAccessRequest(reqs[0], time.Hour, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := s.GetAccessRequest(reqs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Status != AccessRequestApproved || req.ExpiresAt == nil {
+		t.Errorf("got request %+v, want it approved with an expiry", req)
+	}
+	grant, err := s.db.getVaultGrant(vaultId, guest.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.ExpiresAt == nil || !grant.ExpiresAt.Equal(*req.ExpiresAt) {
+		t.Errorf("access expires at %v, the request says %v", grant.ExpiresAt, req.ExpiresAt)
+	}
+
+	// A request is only decided once
+	if err = s.ApproveAccessRequest(reqs[0], time.Hour, owner); !IsErrNotFound(err) {
+		t.Errorf("approving a decided request: got error %v, want not found", err)
+	}
+}
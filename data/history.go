@@ -0,0 +1,136 @@
+package data
+
+import (
+	"github.com/TaeKwonZeus/pva/network"
+	"github.com/charmbracelet/log"
+	"time"
+)
+
+// RecordScan saves what a completed scan of the target found, matching the devices to saved ones the same way
+// GetDevices does. It's meant to be handed to network.StartAutoDiscovery.
+func (s *Store) RecordScan(target network.Target, devices []network.Device, startedAt time.Time) error {
+	saved, err := s.db.getDevices()
+	if err != nil {
+		return err
+	}
+	index := newDeviceIndex(saved)
+	// Alerts compare the scan with what was found before it
+	var baseline scanBaseline
+	if s.alertRules.enabled() {
+		if baseline, err = s.getScanBaseline(target.Name); err != nil {
+			return err
+		}
+	}
+
+	observations := make([]observation, len(devices))
+	for i, device := range devices {
+		observations[i] = observation{MAC: macString(device.MAC), IP: device.IP.String()}
+		if entry := index.find(device); entry != nil {
+			observations[i].DeviceId = &entry.ID
+		}
+	}
+	if err = s.db.recordScan(target.Name, startedAt, time.Now(), observations); err != nil {
+		return err
+	}
+	if s.alertRules.enabled() {
+		return s.checkScan(target.Name, devices, observations, baseline, saved)
+	}
+	return nil
+}
+
+// GetDeviceHistory returns when scans have found the device, with the periods it was up oldest first.
+func (s *Store) GetDeviceHistory(id int) (history DeviceHistory, err error) {
+	hosts, timeline, err := s.db.getDeviceHistory(id)
+	if err != nil {
+		return
+	}
+	history.FirstSeen, history.LastSeen = seenSpan(hosts)
+	history.Timeline = timeline
+	return
+}
+
+// GetStaleDevices returns the saved devices scans haven't found for the given number of days, including ones
+// they have never found.
+func (s *Store) GetStaleDevices(days int, user User) ([]Device, error) {
+	devices, err := s.GetDevices(user)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	stale := []Device{}
+	for _, device := range devices {
+		if device.ID != 0 && (device.LastSeen == nil || device.LastSeen.Before(cutoff)) {
+			stale = append(stale, device)
+		}
+	}
+	return stale, nil
+}
+
+// StartScanHistoryPurge deletes scan history older than retention, checking every interval. Saved devices keep
+// when they were first and last seen.
+func (s *Store) StartScanHistoryPurge(retention time.Duration, interval time.Duration) {
+	go func() {
+		for ; ; time.Sleep(interval) {
+			n, err := s.db.purgeScanHistory(time.Now().Add(-retention))
+			if err != nil {
+				log.Error("scan history purge error", "err", err)
+				continue
+			}
+			if n > 0 {
+				log.Info("purged scan history", "periods", n)
+			}
+		}
+	}()
+	log.Info("scan history purge started", "retention", retention)
+}
+
+// fillSeen sets when scans first and last found the devices. Saved devices span all hosts matched to them,
+// unsaved ones are looked up by MAC, or by IP if it isn't known.
+func (s *Store) fillSeen(devices []Device) error {
+	hosts, err := s.db.getHosts()
+	if err != nil {
+		return err
+	}
+	byDevice := make(map[int][]host)
+	byMAC := make(map[string]host)
+	byIP := make(map[string]host)
+	for _, h := range hosts {
+		if h.DeviceId != nil {
+			byDevice[*h.DeviceId] = append(byDevice[*h.DeviceId], h)
+		}
+		if h.MAC != nil {
+			byMAC[*h.MAC] = h
+		} else {
+			byIP[h.IP] = h
+		}
+	}
+
+	for i := range devices {
+		if devices[i].ID != 0 {
+			devices[i].FirstSeen, devices[i].LastSeen = seenSpan(byDevice[devices[i].ID])
+			continue
+		}
+		h, ok := byMAC[devices[i].MAC]
+		if devices[i].MAC == "" {
+			h, ok = byIP[devices[i].IP]
+		}
+		if ok {
+			devices[i].FirstSeen, devices[i].LastSeen = seenSpan([]host{h})
+		}
+	}
+	return nil
+}
+
+// seenSpan returns when the first of the hosts was first seen and the last of them last seen, or nils if there
+// are none.
+func seenSpan(hosts []host) (firstSeen, lastSeen *time.Time) {
+	for _, h := range hosts {
+		if firstSeen == nil || h.FirstSeen.Before(*firstSeen) {
+			firstSeen = &h.FirstSeen
+		}
+		if lastSeen == nil || h.LastSeen.After(*lastSeen) {
+			lastSeen = &h.LastSeen
+		}
+	}
+	return
+}
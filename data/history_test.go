@@ -0,0 +1,131 @@
+package data
+
+import (
+	"github.com/TaeKwonZeus/pva/network"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestDevice saves a device, returning its id.
+func newTestDevice(t *testing.T, s *Store, device Device) int {
+	t.Helper()
+	id, err := s.db.createDevice(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// scanned describes a host found by a scan.
+func scanned(ip, mac string) network.Device {
+	device := network.Device{IP: netip.MustParseAddr(ip)}
+	if mac != "" {
+		device.MAC, _ = net.ParseMAC(mac)
+	}
+	return device
+}
+
+func TestDeviceHistory(t *testing.T) {
+	s := newTestStore(t)
+	target := network.Target{Name: "office"}
+	const mac = "00:11:22:33:44:55"
+	id := newTestDevice(t, s, Device{IP: "10.0.0.5", MAC: mac, Name: "printer"})
+
+	start := time.Now().Add(-time.Hour).UTC()
+	scans := [][]network.Device{
+		{scanned("10.0.0.5", mac)},
+		{scanned("10.0.0.5", mac)},
+		{},
+		{scanned("10.0.0.5", mac)},
+		// DHCP handed out a new address, which the MAC still matches to the device
+		{scanned("10.0.0.9", mac), scanned("10.0.0.5", "66:77:88:99:aa:bb")},
+	}
+	for i, devices := range scans {
+		if err := s.RecordScan(target, devices, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := s.GetDeviceHistory(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.FirstSeen == nil || !history.FirstSeen.Equal(start) {
+		t.Errorf("got first seen %v, want %v", history.FirstSeen, start)
+	}
+	if history.LastSeen == nil || !history.LastSeen.After(start.Add(4*time.Minute)) {
+		t.Errorf("got last seen %v, want after the last scan started", history.LastSeen)
+	}
+
+	// The first two scans make one period, the missed scan ends it and so does the new address
+	var got []string
+	for _, p := range history.Timeline {
+		got = append(got, p.IP)
+	}
+	if want := []string{"10.0.0.5", "10.0.0.5", "10.0.0.9"}; !slices.Equal(got, want) {
+		t.Fatalf("got timeline at %v, want %v", got, want)
+	}
+	for i, want := range []time.Time{start, start.Add(3 * time.Minute), start.Add(4 * time.Minute)} {
+		if p := history.Timeline[i]; !p.From.Equal(want) || !p.To.After(p.From) {
+			t.Errorf("got period %d from %v to %v, want it to start at %v", i, p.From, p.To, want)
+		}
+	}
+
+	if _, err = s.GetDeviceHistory(id + 1); !IsErrNotFound(err) {
+		t.Errorf("history of a missing device returned %v", err)
+	}
+	// The other host at the old address isn't the device
+	hosts, err := s.db.getHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hosts {
+		if h.IP == "10.0.0.5" && h.DeviceId != nil && *h.DeviceId == id {
+			t.Error("host that took over the old address was matched to the device")
+		}
+	}
+}
+
+func TestStaleDevices(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s, "admin", RoleAdmin)
+	target := network.Target{Name: "office"}
+	newTestDevice(t, s, Device{IP: "10.0.0.1", Name: "recent"})
+	old := newTestDevice(t, s, Device{IP: "10.0.0.2", Name: "old"})
+	newTestDevice(t, s, Device{IP: "10.0.0.3", Name: "never"})
+
+	devices := []network.Device{scanned("10.0.0.1", ""), scanned("10.0.0.2", "")}
+	if err := s.RecordScan(target, devices, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// As if the old device was last found more than a week ago
+	_, err := s.db.pool.Exec("UPDATE hosts SET last_seen=? WHERE device_id=?", time.Now().AddDate(0, 0, -8).UTC(),
+		old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := s.GetStaleDevices(7, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, device := range stale {
+		got = append(got, device.Name)
+	}
+	slices.Sort(got)
+	if want := []string{"never", "old"}; !slices.Equal(got, want) {
+		t.Errorf("got stale devices %v, want %v", got, want)
+	}
+
+	stale, err = s.GetStaleDevices(10, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].Name != "never" {
+		t.Errorf("got %d stale devices with a longer cutoff, want only the one never found", len(stale))
+	}
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"regexp"
+	"strings"
+)
+
+// migration updates the schema of a database created by an earlier version.
+type migration func(tx *sqlx.Tx) error
+
+// migrations run in order on existing databases before startupQuery, which then creates whatever tables and
+// indexes are new. PRAGMA user_version counts the migrations a database has had applied. Databases from before
+// there were migrations are at version 0 whatever their schema, so migrations check what's there instead of
+// assuming it.
+var migrations = []migration{
+	migrateSoftDelete,
+	migrateGrantExpiry,
+	migrateCheckouts,
+	migrateAttachmentMetadata,
+	migrateDocumentFormats,
+	migrateFolders,
+	migrateBlobs,
+	migrateDeviceMACs,
+}
+
+// migrate brings the database up to date with startupQuery, creating it if it's new.
+func migrate(pool *sqlx.DB) error {
+	ctx := context.Background()
+	conn, err := pool.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var exists bool
+	err = conn.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='users')")
+	if err != nil {
+		return err
+	}
+	var version int
+	if err = conn.GetContext(ctx, &version, "PRAGMA user_version"); err != nil {
+		return err
+	}
+	if !exists {
+		version = len(migrations)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this version of pva", version)
+	}
+
+	if version < len(migrations) {
+		// Rebuilding a table drops it, which mustn't cascade. The pragma has no effect inside a transaction.
+		if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+			return err
+		}
+		for ; version < len(migrations); version++ {
+			if err = runMigration(ctx, conn, version); err != nil {
+				return fmt.Errorf("migrating database to version %d: %w", version+1, err)
+			}
+		}
+		if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=ON"); err != nil {
+			return err
+		}
+	}
+
+	if _, err = conn.ExecContext(ctx, startupQuery); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version=%d", len(migrations)))
+	return err
+}
+
+// runMigration applies migrations[i] in a transaction, leaving the database at version i+1.
+func runMigration(ctx context.Context, conn *sqlx.Conn, i int) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = migrations[i](tx); err != nil {
+		return err
+	}
+	var violations int
+	if err = tx.Get(&violations, "SELECT COUNT(*) FROM pragma_foreign_key_check"); err != nil {
+		return err
+	}
+	if violations > 0 {
+		return fmt.Errorf("%d foreign key violations", violations)
+	}
+	if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version=%d", i+1)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func tableExists(tx *sqlx.Tx, table string) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name=?)", table)
+	return
+}
+
+func columnExists(tx *sqlx.Tx, table, column string) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name=?)", table, column)
+	return
+}
+
+// addColumn adds a column to table unless it has it already. Tables that don't exist are left to startupQuery.
+func addColumn(tx *sqlx.Tx, table, column, definition string) error {
+	exists, err := tableExists(tx, table)
+	if err != nil || !exists {
+		return err
+	}
+	if exists, err = columnExists(tx, table, column); err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// rebuildTable recreates table with its definition rewritten by fix and copies its rows over, as sqlite can't
+// drop constraints in place. Nothing happens if fix leaves the definition as it is. Indexes of the table are
+// dropped along with it, startupQuery creates them again.
+func rebuildTable(tx *sqlx.Tx, table string, fix func(definition string) string) error {
+	var definition string
+	err := tx.Get(&definition, "SELECT sql FROM sqlite_master WHERE type='table' AND name=?", table)
+	if IsErrNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fixed := fix(definition)
+	if fixed == definition {
+		return nil
+	}
+
+	// The definition starts with CREATE TABLE and the name, then the columns in parentheses
+	columns := fixed[strings.Index(fixed, "("):]
+	if _, err = tx.Exec("CREATE TABLE " + table + "_new " + columns); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO " + table + "_new SELECT * FROM " + table); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DROP TABLE " + table); err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE " + table + "_new RENAME TO " + table)
+	return err
+}
+
+// Constraints that made names unique including items in trash.
+var (
+	vaultsNameUnique    = regexp.MustCompile(`(name\s+TEXT\s+NOT NULL)\s+UNIQUE`)
+	passwordsNameUnique = regexp.MustCompile(`,\s*UNIQUE\s*\(name, vault_id\)`)
+	devicesIPUnique     = regexp.MustCompile(`(ip\s+TEXT)\s+UNIQUE`)
+)
+
+// migrateSoftDelete adds trash to vaults, passwords, devices and documents. Names and IPs only have to be unique
+// among what isn't in trash, so the constraints on them are replaced by the partial indexes of startupQuery.
+func migrateSoftDelete(tx *sqlx.Tx) error {
+	for _, table := range []string{"vaults", "passwords", "devices", "documents"} {
+		if err := addColumn(tx, table, "deleted_at", "DATETIME"); err != nil {
+			return err
+		}
+	}
+
+	err := rebuildTable(tx, "vaults", func(definition string) string {
+		return vaultsNameUnique.ReplaceAllString(definition, "$1")
+	})
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(tx, "passwords", func(definition string) string {
+		return passwordsNameUnique.ReplaceAllString(definition, "")
+	})
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(tx, "devices", func(definition string) string {
+		return devicesIPUnique.ReplaceAllString(definition, "$1")
+	})
+	if err != nil {
+		return err
+	}
+
+	// Recreated as a partial index by startupQuery
+	_, err = tx.Exec("DROP INDEX IF EXISTS vaults_name")
+	return err
+}
+
+// migrateGrantExpiry adds time-limited vault access and the rotation of keys it leaves behind.
+func migrateGrantExpiry(tx *sqlx.Tx) error {
+	if err := addColumn(tx, "vaults", "key_rotation_pending", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumn(tx, "vault_keys", "expires_at", "DATETIME")
+}
+
+// migrateCheckouts adds privileged passwords that have to be checked out.
+func migrateCheckouts(tx *sqlx.Tx) error {
+	if err := addColumn(tx, "passwords", "checkout_required", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumn(tx, "passwords", "rotation_required", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// migrateAttachmentMetadata adds the content type, size and upload time of attachments. There was no way to
+// upload attachments before, so the defaults only fill in the definitions.
+func migrateAttachmentMetadata(tx *sqlx.Tx) error {
+	if err := addColumn(tx, "attachments", "content_type", "TEXT NOT NULL DEFAULT 'application/octet-stream'"); err != nil {
+		return err
+	}
+	if err := addColumn(tx, "attachments", "size", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumn(tx, "attachments", "created_at", "DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00+00:00'")
+}
+
+// migrateDocumentFormats adds the format of documents and their revisions. Documents used to all be markdown.
+func migrateDocumentFormats(tx *sqlx.Tx) error {
+	if err := addColumn(tx, "documents", "format", "TEXT NOT NULL DEFAULT 'markdown'"); err != nil {
+		return err
+	}
+	return addColumn(tx, "document_revisions", "format", "TEXT NOT NULL DEFAULT 'markdown'")
+}
+
+// migrateFolders puts vaults and documents in folders. Vault names used to be unique overall, migrateSoftDelete
+// already dropped that constraint for the index of startupQuery, which makes them unique within a folder.
+func migrateFolders(tx *sqlx.Tx) error {
+	for _, table := range []string{"vaults", "documents"} {
+		err := addColumn(tx, table, "folder_id", "INTEGER REFERENCES folders (id) ON DELETE SET NULL")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBlobs moves attachments to blobs. The files of existing attachments become blobs under their old names,
+// with their content still encrypted with the document key directly, which an empty key_encrypted stands for.
+// They aren't charged to anyone.
+func migrateBlobs(tx *sqlx.Tx) error {
+	if exists, err := columnExists(tx, "attachments", "file_name"); err != nil || !exists {
+		return err
+	}
+
+	// The tables as of this migration, startupQuery adds the triggers counting references afterwards
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS blobs
+		(
+			hash       TEXT PRIMARY KEY,
+			size       INTEGER  NOT NULL,
+			ref_count  INTEGER  NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO blobs (hash, size, ref_count, created_at)
+		SELECT file_name, size, 1, created_at FROM attachments`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE attachments_new
+		(
+			id            INTEGER PRIMARY KEY,
+			document_id   INTEGER REFERENCES documents (id) ON DELETE CASCADE,
+			name          TEXT     NOT NULL,
+			blob_hash     TEXT     NOT NULL REFERENCES blobs (hash),
+			key_encrypted BLOB     NOT NULL,
+			content_type  TEXT     NOT NULL,
+			size          INTEGER  NOT NULL,
+			uploaded_by   INTEGER REFERENCES users (id) ON DELETE SET NULL,
+			created_at    DATETIME NOT NULL,
+
+			UNIQUE (document_id, name)
+		)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO attachments_new
+		(id, document_id, name, blob_hash, key_encrypted, content_type, size, uploaded_by, created_at)
+		SELECT id, document_id, name, file_name, X'', content_type, size, NULL, created_at FROM attachments`)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DROP TABLE attachments"); err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE attachments_new RENAME TO attachments")
+	return err
+}
+
+// migrateDeviceMACs adds the MACs devices are recognized by when their IP changes.
+func migrateDeviceMACs(tx *sqlx.Tx) error {
+	return addColumn(tx, "devices", "mac", "TEXT NOT NULL DEFAULT ''")
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"bytes"
+	"github.com/TaeKwonZeus/pva/crypt"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// baselineSchema is the schema of databases from before there were migrations.
+const baselineSchema = `
+CREATE TABLE users
+(
+    id                    INTEGER PRIMARY KEY,
+    username              TEXT NOT NULL UNIQUE,
+    role                  TEXT NOT NULL,
+
+    salt                  BLOB NOT NULL,
+    public_key            BLOB NOT NULL,
+    private_key_encrypted BLOB NOT NULL
+);
+
+CREATE TABLE vaults
+(
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE passwords
+(
+    id                 INTEGER PRIMARY KEY,
+    name               TEXT NOT NULL,
+    description        TEXT NOT NULL,
+    password_encrypted BLOB NOT NULL,
+    vault_id           INTEGER REFERENCES vaults (id) ON DELETE CASCADE,
+
+    UNIQUE (name, vault_id)
+);
+
+CREATE TABLE vault_keys
+(
+    user_id       INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    vault_id      INTEGER REFERENCES vaults (id) ON DELETE CASCADE,
+
+    -- Encrypted with user's public key
+    key_encrypted BLOB NOT NULL,
+
+    PRIMARY KEY (user_id, vault_id)
+);
+
+CREATE TABLE devices
+(
+    id          INTEGER PRIMARY KEY,
+    ip          TEXT UNIQUE NOT NULL,
+    name        TEXT        NOT NULL,
+    description TEXT        NOT NULL
+);
+
+CREATE TABLE documents
+(
+    id                INTEGER PRIMARY KEY,
+    name              TEXT        NOT NULL,
+    file_name         TEXT UNIQUE NOT NULL,
+    payload_encrypted BLOB        NOT NULL
+);
+
+CREATE TABLE attachments
+(
+    id          INTEGER PRIMARY KEY,
+    document_id INTEGER REFERENCES documents (id) ON DELETE CASCADE,
+    name        TEXT        NOT NULL,
+    file_name   TEXT UNIQUE NOT NULL,
+
+    UNIQUE (document_id, name)
+);
+
+CREATE TABLE document_keys
+(
+    user_id       INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    document_id   INTEGER REFERENCES documents (id) ON DELETE CASCADE,
+
+    key_encrypted BLOB NOT NULL,
+
+    PRIMARY KEY (user_id, document_id)
+);
+
+INSERT INTO vaults (id, name) VALUES (1, 'Servers');
+INSERT INTO passwords (id, name, description, password_encrypted, vault_id) VALUES (1, 'root', '', X'00', 1);
+INSERT INTO devices (id, ip, name, description) VALUES (1, '10.0.0.1', 'gateway', '');
+INSERT INTO documents (id, name, file_name, payload_encrypted) VALUES (1, 'Runbook', 'runbook', X'00');
+INSERT INTO attachments (id, document_id, name, file_name) VALUES (1, 1, 'notes.txt', 'oldattachment');
+`
+
+func TestMigrateBaseline(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.sqlite")
+	pool, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pool.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	pool.Close()
+
+	// An attachment in the format from before blobs, encrypted with the document key and old enough to be
+	// collected if it wasn't referenced
+	docKey, err := crypt.NewAesKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("attachment from before blobs")
+	attachmentPath := filepath.Join(dir, "attachments", "oldattachment")
+	if err = os.MkdirAll(filepath.Dir(attachmentPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	ew, err := crypt.NewEncryptWriter(&buf, docKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ew.Write(content)
+	if err = ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(attachmentPath, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	if err = os.Chtimes(attachmentPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+
+	var version int
+	if err = s.db.pool.Get(&version, "PRAGMA user_version"); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("got version %d, want %d", version, len(migrations))
+	}
+
+	if err = s.CreateUser(User{Username: "admin", Role: RoleAdmin}, "password"); err != nil {
+		t.Fatal(err)
+	}
+	_, user := s.VerifyPassword("admin", "password")
+	if _, err = user.DecryptPrivateKey(user.DeriveKey("password")); err != nil {
+		t.Fatal(err)
+	}
+	keyEncrypted, err := crypt.RsaEncrypt(docKey, user.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.createDocumentKeys(documentKey{UserId: user.ID, DocumentId: 1, KeyEncrypted: keyEncrypted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.collectBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	_, r, err := s.GetAttachment(1, 1, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got attachment %q, want %q", got, content)
+	}
+
+	// Names and IPs of what's in trash are free to take
+	if err = s.db.deleteVault(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.createVault(Vault{Name: "Servers"}); err != nil {
+		t.Errorf("creating a vault named like one in trash: %v", err)
+	}
+	if err = s.db.deletePassword(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.createPassword(Password{Name: "root", PasswordEncrypted: []byte{0}}, 1); err != nil {
+		t.Errorf("creating a password named like one in trash: %v", err)
+	}
+	if err = s.db.deleteDevice(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.createDevice(Device{IP: "10.0.0.1"}); err != nil {
+		t.Errorf("creating a device with the IP of one in trash: %v", err)
+	}
+
+	// Opening a database that's up to date runs no migrations
+	s.Close()
+	if s, err = NewStore(path); err != nil {
+		t.Fatal(err)
+	}
+}
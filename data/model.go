@@ -4,6 +4,7 @@ import (
 	"github.com/TaeKwonZeus/pva/crypt"
 	"log"
 	"slices"
+	"time"
 )
 
 type Role string
@@ -29,6 +30,7 @@ const (
 	PermissionManageDevices              = "devices.manage"
 	PermissionViewDocuments              = "documents.view"
 	PermissionManageDocuments            = "documents.manage"
+	PermissionManageFolders              = "folders.manage"
 )
 
 var permissions = map[Role][]Permission{
@@ -39,6 +41,7 @@ var permissions = map[Role][]Permission{
 		PermissionManageDevices,
 		PermissionViewDocuments,
 		PermissionManageDocuments,
+		PermissionManageFolders,
 	},
 	RoleViewer: {
 		PermissionViewPasswords,
@@ -90,46 +93,375 @@ type Vault struct {
 	ID        int        `json:"id,omitempty" db:"id"`
 	Name      string     `json:"name" db:"name"`
 	Passwords []Password `json:"passwords"`
+	Tags      []string   `json:"tags"`
+	Favorite  bool       `json:"favorite"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+	// ExpiresAt is when the user's access to the vault lapses, nil if it doesn't
+	ExpiresAt *time.Time  `json:"expiresAt,omitempty" db:"expires_at"`
+	FolderID  *int        `json:"folderId" db:"folder_id"`
+	Path      []FolderRef `json:"path"`
 
-	KeyEncrypted []byte `json:"-" db:"key_encrypted"`
+	KeyEncrypted       []byte `json:"-" db:"key_encrypted"`
+	KeyRotationPending bool   `json:"-" db:"key_rotation_pending"`
 }
 
 type Password struct {
-	ID          int    `json:"id,omitempty" db:"id"`
-	Name        string `json:"name" db:"name"`
-	Description string `json:"description" db:"description"`
-	Password    string `json:"password,omitempty"`
+	ID          int        `json:"id,omitempty" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	Password    string     `json:"password,omitempty"`
+	Tags        []string   `json:"tags"`
+	Favorite    bool       `json:"favorite"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+
+	// CheckoutRequired passwords are only decrypted for the user who has them checked out
+	CheckoutRequired bool      `json:"checkoutRequired" db:"checkout_required"`
+	RotationRequired bool      `json:"rotationRequired" db:"rotation_required"`
+	Checkout         *Checkout `json:"checkout,omitempty"`
 
 	PasswordEncrypted []byte `json:"-" db:"password_encrypted"`
 }
 
+// Checkout is a user's exclusive, time-limited hold on a privileged password.
+type Checkout struct {
+	ID           int        `json:"id" db:"id"`
+	PasswordID   int        `json:"passwordId" db:"password_id"`
+	UserID       int        `json:"userId" db:"user_id"`
+	Username     string     `json:"username" db:"username"`
+	Reason       string     `json:"reason" db:"reason"`
+	CheckedOutAt time.Time  `json:"checkedOutAt" db:"checked_out_at"`
+	ExpiresAt    time.Time  `json:"expiresAt" db:"expires_at"`
+	CheckedInAt  *time.Time `json:"checkedInAt,omitempty" db:"checked_in_at"`
+	CheckedInBy  *int       `json:"checkedInBy,omitempty" db:"checked_in_by"`
+}
+
 type Device struct {
-	ID          int    `json:"id" db:"id"`
-	IP          string `json:"ip" db:"ip"`
-	Name        string `json:"name" db:"name"`
-	Description string `json:"description" db:"description"`
-	//NetworkName string `json:"networkName"`
-	//MAC       string `json:"mac"`
-	Connected bool `json:"connected"`
+	ID          int      `json:"id" db:"id"`
+	IP          string   `json:"ip" db:"ip"`
+	IPv6        []string `json:"ipv6"`
+	Name        string   `json:"name" db:"name"`
+	Description string   `json:"description" db:"description"`
+	MAC         string   `json:"mac" db:"mac"`
+	Vendor      string   `json:"vendor,omitempty"`
+	// NetworkName is what the device calls itself on the network, resolved by NameSource
+	NetworkName string    `json:"networkName,omitempty"`
+	NameSource  string    `json:"nameSource,omitempty"`
+	Services    []Service `json:"services"`
+	Connected   bool      `json:"connected"`
+	Target      string    `json:"target,omitempty"`
+	// FirstSeen and LastSeen are when scans first and last found the device, nil if they never have
+	FirstSeen *time.Time `json:"firstSeen,omitempty"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	Tags      []string   `json:"tags"`
+	Favorite  bool       `json:"favorite"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+}
+
+// Service is an open TCP port of a device.
+type Service struct {
+	Port   int    `json:"port" db:"port"`
+	Name   string `json:"name" db:"name"`
+	Banner string `json:"banner" db:"banner"`
+	// The certificate fields are only set for services speaking TLS
+	CertSubject   *string    `json:"certSubject,omitempty" db:"cert_subject"`
+	CertIssuer    *string    `json:"certIssuer,omitempty" db:"cert_issuer"`
+	CertExpiresAt *time.Time `json:"certExpiresAt,omitempty" db:"cert_expires_at"`
+}
+
+// Presence is a period a device was up, spanning consecutive scans of a target that found it at IP.
+type Presence struct {
+	Target string    `json:"target" db:"target"`
+	IP     string    `json:"ip" db:"ip"`
+	From   time.Time `json:"from" db:"from_time"`
+	To     time.Time `json:"to" db:"to_time"`
+}
+
+// DeviceHistory is when scans have found a device.
+type DeviceHistory struct {
+	FirstSeen *time.Time `json:"firstSeen"`
+	LastSeen  *time.Time `json:"lastSeen"`
+	Timeline  []Presence `json:"timeline"`
+}
+
+// AlertType is the rule an alert was raised by.
+type AlertType string
+
+const (
+	// AlertNewDevice is raised when a scan finds a MAC no scan has found before
+	AlertNewDevice AlertType = "new_device"
+	// AlertDeviceOffline is raised when a saved device hasn't been found for too long
+	AlertDeviceOffline AlertType = "device_offline"
+	// AlertMACChanged is raised when an IP answers with a different MAC than in the previous scan
+	AlertMACChanged AlertType = "mac_changed"
+)
+
+type Alert struct {
+	ID   int       `json:"id" db:"id"`
+	Type AlertType `json:"type" db:"type"`
+	// Key identifies repeats of the alert, which are counted instead of raising it again until it's acknowledged
+	Key      string `json:"-" db:"dedup_key"`
+	Message  string `json:"message" db:"message"`
+	Target   string `json:"target,omitempty" db:"target"`
+	IP       string `json:"ip" db:"ip"`
+	MAC      string `json:"mac" db:"mac"`
+	DeviceID *int   `json:"deviceId,omitempty" db:"device_id"`
+	Count    int    `json:"count" db:"count"`
+	// UpdatedAt is when the alert was last raised
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty" db:"acknowledged_at"`
+	AcknowledgedBy *int       `json:"acknowledgedBy,omitempty" db:"acknowledged_by"`
+}
+
+// ScanStatus describes the scans of every target.
+type ScanStatus struct {
+	// Running is set while any target is being scanned, Manual while a requested scan is running
+	Running bool               `json:"running"`
+	Manual  bool               `json:"manual"`
+	Targets []TargetScanStatus `json:"targets"`
+}
+
+type TargetScanStatus struct {
+	Target  string `json:"target"`
+	Running bool   `json:"running"`
+	// StartedAt, Done and Total describe the running scan, or else the last one, Done and Total counting probes
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	Done      int        `json:"done"`
+	Total     int        `json:"total"`
+	// LastCompleted is when the last successful scan finished, and Devices how many devices it found
+	LastCompleted *time.Time `json:"lastCompleted,omitempty"`
+	Devices       int        `json:"devices"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// ScanEvent reports the progress of a scan of a target.
+type ScanEvent struct {
+	// Kind is one of started, host, progress, done and error
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	// Device is a host that just answered, with what the probe learned about it
+	Device  *Device `json:"device,omitempty"`
+	Done    int     `json:"done"`
+	Total   int     `json:"total"`
+	Devices int     `json:"devices,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// DocumentFormat is how the payload of a document gets rendered.
+type DocumentFormat string
+
+const (
+	FormatMarkdown DocumentFormat = "markdown"
+	FormatPlain    DocumentFormat = "plain"
+	FormatHTML     DocumentFormat = "html"
+)
+
+func (f DocumentFormat) Valid() bool {
+	return f == FormatMarkdown || f == FormatPlain || f == FormatHTML
 }
 
 type Document struct {
-	ID          int          `json:"id" db:"id"`
-	Name        string       `json:"name" db:"name"`
-	Payload     string       `json:"payload"`
-	FileName    string       `json:"-" db:"file_name"`
-	Attachments []Attachment `json:"attachments"`
+	ID          int            `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Payload     string         `json:"payload"`
+	Format      DocumentFormat `json:"format" db:"format"`
+	FolderID    *int           `json:"folderId" db:"folder_id"`
+	Path        []FolderRef    `json:"path"`
+	FileName    string         `json:"-" db:"file_name"`
+	Attachments []Attachment   `json:"attachments"`
+	Tags        []string       `json:"tags"`
+	Favorite    bool           `json:"favorite"`
+	DeletedAt   *time.Time     `json:"deletedAt,omitempty" db:"deleted_at"`
 
 	PayloadEncrypted []byte `json:"-" db:"payload_encrypted"`
 	KeyEncrypted     []byte `json:"-" db:"key_encrypted"`
 }
 
+// Revision is a saved state of a document.
+// DocumentInfo describes a document without decrypting it.
+type DocumentInfo struct {
+	ID       int            `json:"id" db:"id"`
+	Name     string         `json:"name" db:"name"`
+	Format   DocumentFormat `json:"format" db:"format"`
+	FolderID *int           `json:"folderId" db:"folder_id"`
+	Size     int64          `json:"size" db:"size"`
+	// UpdatedAt is when the latest revision was made, nil for documents predating revisions
+	UpdatedAt *time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+type Revision struct {
+	ID         int    `json:"id" db:"id"`
+	DocumentID int    `json:"documentId" db:"document_id"`
+	Name       string `json:"name" db:"name"`
+	Payload    string `json:"payload,omitempty"`
+	// Format is empty when listing revisions
+	Format DocumentFormat `json:"format,omitempty" db:"format"`
+	// AuthorID and Author are nil if the author's account was deleted
+	AuthorID  *int      `json:"authorId" db:"author_id"`
+	Author    *string   `json:"author" db:"author"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+
+	PayloadEncrypted []byte `json:"-" db:"payload_encrypted"`
+}
+
 type Attachment struct {
+	ID          int       `json:"id" db:"id"`
+	DocumentID  int       `json:"documentId" db:"document_id"`
+	Name        string    `json:"name" db:"name"`
+	ContentType string    `json:"contentType" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	// UploadedBy is charged for the attachment's blob, nil if their account was deleted
+	UploadedBy *int `json:"-" db:"uploaded_by"`
+
+	BlobHash string `json:"-" db:"blob_hash"`
+	// KeyEncrypted is the blob's key encrypted with the document key. It's empty for attachments from before
+	// blobs, whose content is encrypted with the document key itself.
+	KeyEncrypted []byte `json:"-" db:"key_encrypted"`
+}
+
+// StorageUsage is how many bytes of attachments a user is charged for, with 0 for Quota meaning unlimited.
+type StorageUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
+
+type AccessRequestStatus string
+
+const (
+	AccessRequestPending  AccessRequestStatus = "pending"
+	AccessRequestApproved AccessRequestStatus = "approved"
+	AccessRequestDenied   AccessRequestStatus = "denied"
+)
+
+// AccessRequest is a user asking for time-limited access to a vault they don't have a key for.
+type AccessRequest struct {
+	ID        int                 `json:"id" db:"id"`
+	VaultID   int                 `json:"vaultId" db:"vault_id"`
+	VaultName string              `json:"vaultName" db:"vault_name"`
+	UserID    int                 `json:"userId" db:"user_id"`
+	Username  string              `json:"username" db:"username"`
+	Reason    string              `json:"reason" db:"reason"`
+	Duration  int                 `json:"duration" db:"duration"`
+	Status    AccessRequestStatus `json:"status" db:"status"`
+	CreatedAt time.Time           `json:"createdAt" db:"created_at"`
+
+	DecidedBy *int       `json:"decidedBy,omitempty" db:"decided_by"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty" db:"decided_at"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+}
+
+// ObjectType identifies the kind of object in responses spanning multiple kinds.
+type ObjectType string
+
+const (
+	ObjectVault    ObjectType = "vault"
+	ObjectPassword ObjectType = "password"
+	ObjectDevice   ObjectType = "device"
+	ObjectDocument ObjectType = "document"
+)
+
+// TrashItem is a soft-deleted object that can still be restored until PurgeAt.
+type TrashItem struct {
+	Type      ObjectType `json:"type" db:"type"`
+	ID        int        `json:"id" db:"id"`
+	VaultID   int        `json:"vaultId,omitempty" db:"vault_id"`
+	Name      string     `json:"name" db:"name"`
+	DeletedAt time.Time  `json:"deletedAt" db:"deleted_at"`
+	PurgeAt   time.Time  `json:"purgeAt"`
+}
+
+// SearchResult is an object matching a search query, with higher Score meaning a better match.
+type SearchResult struct {
+	Type     ObjectType `json:"type"`
+	ID       int        `json:"id"`
+	VaultID  int        `json:"vaultId,omitempty"`
+	Title    string     `json:"title"`
+	Snippet  string     `json:"snippet,omitempty"`
+	URL      string     `json:"url"`
+	Tags     []string   `json:"tags"`
+	Favorite bool       `json:"favorite"`
+	Score    int        `json:"score"`
+}
+
+// Tag groups objects of any type. Count is how many objects have it.
+type Tag struct {
+	ID    int    `json:"id" db:"id"`
+	Name  string `json:"name" db:"name"`
+	Count int    `json:"count" db:"count"`
+}
+
+// ObjectRef identifies an object of any type.
+type ObjectRef struct {
+	Type ObjectType `json:"type" db:"object_type"`
+	ID   int        `json:"id" db:"object_id"`
+}
+
+// RelationKind is the meaning of a link from one object to another.
+type RelationKind string
+
+const (
+	// RelationUses links an object to a credential or device it uses
+	RelationUses RelationKind = "uses"
+	// RelationDescribes links a document to what it documents
+	RelationDescribes RelationKind = "describes"
+	// RelationDependsOn links an object to one it needs to function
+	RelationDependsOn RelationKind = "depends_on"
+	RelationRelated   RelationKind = "related"
+)
+
+func (k RelationKind) Valid() bool {
+	return k == RelationUses || k == RelationDescribes || k == RelationDependsOn || k == RelationRelated
+}
+
+// Relationship is a directed, typed link between two objects.
+type Relationship struct {
+	ID        int          `json:"id"`
+	Source    ObjectRef    `json:"source"`
+	Kind      RelationKind `json:"kind"`
+	Target    ObjectRef    `json:"target"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+// RelatedItem is an object linked to another one. Outgoing is set if the relationship points from the other
+// object to this one.
+type RelatedItem struct {
+	RelationshipID int          `json:"relationshipId"`
+	Kind           RelationKind `json:"kind"`
+	Outgoing       bool         `json:"outgoing"`
+	Type           ObjectType   `json:"type"`
+	ID             int          `json:"id"`
+	// VaultID is only set for passwords
+	VaultID int    `json:"vaultId,omitempty"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+}
+
+// Folder organizes vaults and documents into a tree. ParentID is nil for top level folders.
+type Folder struct {
 	ID       int    `json:"id" db:"id"`
 	Name     string `json:"name" db:"name"`
-	FileName string `json:"-" db:"file_name"`
+	ParentID *int   `json:"parentId" db:"parent_id"`
+	// Path holds the folders containing this one, starting from the top
+	Path []FolderRef `json:"path"`
+}
+
+// FolderRef is a breadcrumb of a folder path.
+type FolderRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// FolderItem is a vault or document in a folder listing.
+type FolderItem struct {
+	Type ObjectType `json:"type" db:"type"`
+	ID   int        `json:"id" db:"id"`
+	Name string     `json:"name" db:"name"`
 }
 
-type Index struct {
-	Vaults []Vault `json:"vaults"`
+// FolderListing holds the children of a folder, or top level ones if Folder is nil.
+type FolderListing struct {
+	Folder  *Folder      `json:"folder"`
+	Folders []Folder     `json:"folders"`
+	Items   []FolderItem `json:"items"`
 }
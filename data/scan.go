@@ -0,0 +1,75 @@
+package data
+
+import (
+	"github.com/TaeKwonZeus/pva/network"
+	"time"
+)
+
+var (
+	// ErrScanRunning is returned when a scan is requested while the previous one is running
+	ErrScanRunning = network.ErrScanRunning
+	// ErrScanTooSoon is returned when scans are requested too often
+	ErrScanTooSoon = network.ErrScanTooSoon
+)
+
+// ScanNow starts scanning every target right away.
+func (s *Store) ScanNow() error {
+	return network.ScanNow()
+}
+
+func (s *Store) GetScanStatus() ScanStatus {
+	status := network.GetStatus()
+	res := ScanStatus{Manual: status.Manual, Targets: make([]TargetScanStatus, len(status.Targets))}
+	for i, t := range status.Targets {
+		res.Running = res.Running || t.Running
+		res.Targets[i] = TargetScanStatus{
+			Target:        t.Target,
+			Running:       t.Running,
+			StartedAt:     optionalTime(t.StartedAt),
+			Done:          t.Done,
+			Total:         t.Total,
+			LastCompleted: optionalTime(t.LastCompleted),
+			Devices:       t.Devices,
+			LastError:     t.LastError,
+		}
+	}
+	return res
+}
+
+// SubscribeScan returns a channel receiving the events of all scans until cancel is called.
+func (s *Store) SubscribeScan() (events <-chan ScanEvent, cancel func()) {
+	in, unsubscribe := network.Subscribe()
+	out := make(chan ScanEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		for e := range in {
+			event := ScanEvent{Kind: e.Kind, Target: e.Target, Done: e.Done, Total: e.Total, Devices: e.Devices}
+			if e.Device != nil {
+				device := scannedDevice(*e.Device)
+				event.Device = &device
+			}
+			if e.Err != nil {
+				event.Error = e.Err.Error()
+			}
+			select {
+			case out <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return out, func() {
+		close(done)
+		unsubscribe()
+	}
+}
+
+// optionalTime returns nil for the zero time.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
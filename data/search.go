@@ -0,0 +1,200 @@
+package data
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field weights for ranking search results. A term matching the start of a word ranks higher than one
+// matching its middle, and matching the whole field ranks highest.
+const (
+	weightTitle   = 4
+	weightDetails = 2
+	weightBody    = 1
+
+	bonusPrefix = 2
+	bonusExact  = 4
+
+	snippetRadius = 40
+)
+
+type searchField struct {
+	text   string
+	weight int
+}
+
+// scoreFields scores how well the fields match all terms, each term counting its best matching field.
+// It returns 0 if any term doesn't match at all,
+// along with the first matching field other than the title, for use as a snippet.
+func scoreFields(terms []string, fields ...searchField) (score int, snippetField string, snippetTerm string) {
+	for _, term := range terms {
+		termScore := 0
+		for i, field := range fields {
+			text := strings.ToLower(field.text)
+			idx := strings.Index(text, term)
+			if idx < 0 {
+				continue
+			}
+
+			s := field.weight
+			if idx == 0 || !isWordChar(text[idx-1]) {
+				s += bonusPrefix
+			}
+			if text == term {
+				s += bonusExact
+			}
+			termScore = max(termScore, s)
+
+			if i > 0 && snippetField == "" {
+				snippetField, snippetTerm = field.text, term
+			}
+		}
+		if termScore == 0 {
+			return 0, "", ""
+		}
+		score += termScore
+	}
+	return
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c >= utf8.RuneSelf
+}
+
+// snippet cuts out the part of text around the first occurrence of term.
+func snippet(text, term string) string {
+	if text == "" {
+		return ""
+	}
+
+	idx := strings.Index(strings.ToLower(text), term)
+	if idx < 0 {
+		idx = 0
+	}
+	start := max(0, idx-snippetRadius)
+	end := min(len(text), idx+len(term)+snippetRadius)
+	// Don't cut multi-byte characters in half
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	s := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// SearchOptions narrow down a search. Query is split into whitespace-separated terms which all have to
+// match, and an empty Query matches everything.
+type SearchOptions struct {
+	Query         string
+	Tag           string
+	FavoritesOnly bool
+	Limit         int
+	Offset        int
+}
+
+// Search finds everything the user can access matching opts, favorites first and then best matches first.
+// Passwords are matched by name and description only, and documents by their decrypted text. It returns
+// at most opts.Limit results starting from opts.Offset, along with the total number of matches.
+func (s *Store) Search(opts SearchOptions, user User) (results []SearchResult, total int, err error) {
+	terms := strings.Fields(strings.ToLower(opts.Query))
+	tag := NormalizeTag(opts.Tag)
+	results = []SearchResult{}
+
+	a, err := s.getAnnotations(user)
+	if err != nil {
+		return
+	}
+
+	add := func(result SearchResult, fields ...searchField) {
+		result.Tags, result.Favorite = a.of(result.Type, result.ID)
+		if opts.FavoritesOnly && !result.Favorite || tag != "" && !slices.Contains(result.Tags, tag) {
+			return
+		}
+
+		// Tags are matched like any other text
+		fields = append(fields, searchField{strings.Join(result.Tags, " "), weightDetails})
+		score, field, term := scoreFields(terms, fields...)
+		if score == 0 && len(terms) > 0 {
+			return
+		}
+		result.Score = score
+		if field != "" {
+			result.Snippet = snippet(field, term)
+		}
+		results = append(results, result)
+	}
+
+	if CheckPermission(user.Role, PermissionViewPasswords) {
+		vaults, err := s.db.getVaults(user.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, vault := range vaults {
+			add(SearchResult{Type: ObjectVault, ID: vault.ID, VaultID: vault.ID, Title: vault.Name},
+				searchField{vault.Name, weightTitle})
+			for _, password := range vault.Passwords {
+				add(SearchResult{Type: ObjectPassword, ID: password.ID, VaultID: vault.ID, Title: password.Name},
+					searchField{password.Name, weightTitle},
+					searchField{password.Description, weightDetails},
+					searchField{vault.Name, weightBody})
+			}
+		}
+	}
+
+	if CheckPermission(user.Role, PermissionViewDevices) {
+		devices, err := s.db.getDevices()
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, device := range devices {
+			title := device.Name
+			if title == "" {
+				title = device.IP
+			}
+			add(SearchResult{Type: ObjectDevice, ID: device.ID, Title: title},
+				searchField{device.Name, weightTitle},
+				searchField{device.IP, weightTitle},
+				searchField{device.Description, weightDetails})
+		}
+	}
+
+	if CheckPermission(user.Role, PermissionViewDocuments) {
+		docs, err := s.GetDocuments(user)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, doc := range docs {
+			add(SearchResult{Type: ObjectDocument, ID: doc.ID, Title: doc.Name},
+				searchField{doc.Name, weightTitle},
+				searchField{doc.Payload, weightBody})
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		if a.Favorite != b.Favorite {
+			if a.Favorite {
+				return -1
+			}
+			return 1
+		}
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	})
+
+	total = len(results)
+	offset := min(opts.Offset, total)
+	return results[offset:min(offset+opts.Limit, total)], total, nil
+}
@@ -11,12 +11,41 @@ CREATE TABLE IF NOT EXISTS users
     private_key_encrypted BLOB NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS folders
+(
+    id        INTEGER PRIMARY KEY,
+    name      TEXT NOT NULL,
+    parent_id INTEGER REFERENCES folders (id) ON DELETE CASCADE
+);
+
+-- Names are unique among siblings, top level folders have a NULL parent
+CREATE UNIQUE INDEX IF NOT EXISTS folders_name ON folders (IFNULL(parent_id, 0), name);
+
+-- Users that vaults and documents in a folder or its subfolders get shared with
+CREATE TABLE IF NOT EXISTS folder_shares
+(
+    folder_id INTEGER REFERENCES folders (id) ON DELETE CASCADE,
+    user_id   INTEGER REFERENCES users (id) ON DELETE CASCADE,
+
+    PRIMARY KEY (folder_id, user_id)
+);
+
 CREATE TABLE IF NOT EXISTS vaults
 (
-    id   INTEGER PRIMARY KEY,
-    name TEXT NOT NULL UNIQUE
+    id                   INTEGER PRIMARY KEY,
+    name                 TEXT    NOT NULL,
+    folder_id            INTEGER REFERENCES folders (id) ON DELETE SET NULL,
+
+    -- Set when a grant lapses; the key is rotated the next time a key holder opens the vault
+    key_rotation_pending INTEGER NOT NULL DEFAULT 0,
+
+    -- Set when the vault is moved to trash
+    deleted_at           DATETIME
 );
 
+-- Vaults in trash don't keep their names taken
+CREATE UNIQUE INDEX IF NOT EXISTS vaults_name ON vaults (IFNULL(folder_id, 0), name) WHERE deleted_at IS NULL;
+
 CREATE TABLE IF NOT EXISTS passwords
 (
     id                 INTEGER PRIMARY KEY,
@@ -24,10 +53,33 @@ CREATE TABLE IF NOT EXISTS passwords
     description        TEXT NOT NULL,
     password_encrypted BLOB NOT NULL,
     vault_id           INTEGER REFERENCES vaults (id) ON DELETE CASCADE,
+    -- Privileged credentials can only be viewed while checked out
+    checkout_required  INTEGER NOT NULL DEFAULT 0,
+    -- Set when a checkout ends, cleared when the password is changed
+    rotation_required  INTEGER NOT NULL DEFAULT 0,
+    deleted_at         DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS passwords_name ON passwords (vault_id, name) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS checkouts
+(
+    id             INTEGER PRIMARY KEY,
+    password_id    INTEGER  NOT NULL REFERENCES passwords (id) ON DELETE CASCADE,
+    user_id        INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reason         TEXT     NOT NULL,
+    checked_out_at DATETIME NOT NULL,
+    expires_at     DATETIME NOT NULL,
 
-    UNIQUE (name, vault_id)
+    -- NULL while the checkout is active
+    checked_in_at  DATETIME,
+    -- Differs from user_id when an admin overrides the checkout
+    checked_in_by  INTEGER REFERENCES users (id) ON DELETE SET NULL
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS checkouts_active
+    ON checkouts (password_id) WHERE checked_in_at IS NULL;
+
 CREATE TABLE IF NOT EXISTS vault_keys
 (
     user_id       INTEGER REFERENCES users (id) ON DELETE CASCADE,
@@ -35,36 +87,207 @@ CREATE TABLE IF NOT EXISTS vault_keys
 
     -- Encrypted with user's public key
     key_encrypted BLOB NOT NULL,
+    -- NULL for permanent access
+    expires_at    DATETIME,
 
     PRIMARY KEY (user_id, vault_id)
 );
 
+CREATE TABLE IF NOT EXISTS access_requests
+(
+    id         INTEGER PRIMARY KEY,
+    vault_id   INTEGER  NOT NULL REFERENCES vaults (id) ON DELETE CASCADE,
+    user_id    INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reason     TEXT     NOT NULL,
+    -- Requested access duration in seconds
+    duration   INTEGER  NOT NULL,
+    status     TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+
+    decided_by INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    decided_at DATETIME,
+    expires_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS access_requests_pending
+    ON access_requests (vault_id, user_id) WHERE status = 'pending';
+
 CREATE TABLE IF NOT EXISTS devices
 (
     id          INTEGER PRIMARY KEY,
-    ip          TEXT UNIQUE NOT NULL,
-    name        TEXT        NOT NULL,
-    description TEXT        NOT NULL
+    ip          TEXT     NOT NULL,
+    name        TEXT     NOT NULL,
+    description TEXT     NOT NULL,
+    -- Empty if unknown, otherwise used to find the device again when DHCP hands it a new IP
+    mac         TEXT     NOT NULL DEFAULT '',
+    deleted_at  DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS devices_ip ON devices (ip) WHERE deleted_at IS NULL;
+
+-- IPv6 addresses of devices, which usually have several alongside their IPv4 address
+CREATE TABLE IF NOT EXISTS device_addresses
+(
+    device_id INTEGER NOT NULL REFERENCES devices (id) ON DELETE CASCADE,
+    ip        TEXT    NOT NULL,
+
+    PRIMARY KEY (device_id, ip)
 );
 
+-- Open TCP ports of saved devices as of the last scan that found them
+CREATE TABLE IF NOT EXISTS device_services
+(
+    device_id       INTEGER NOT NULL REFERENCES devices (id) ON DELETE CASCADE,
+    port            INTEGER NOT NULL,
+    name            TEXT    NOT NULL,
+    banner          TEXT    NOT NULL,
+    -- Set for services speaking TLS
+    cert_subject    TEXT,
+    cert_issuer     TEXT,
+    cert_expires_at DATETIME,
+
+    PRIMARY KEY (device_id, port)
+);
+
+-- Completed scans of each target
+CREATE TABLE IF NOT EXISTS scans
+(
+    id          INTEGER PRIMARY KEY,
+    target      TEXT     NOT NULL,
+    started_at  DATETIME NOT NULL,
+    finished_at DATETIME NOT NULL,
+    host_count  INTEGER  NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS scans_target ON scans (target, id);
+
+-- Everything scans have found, saved as a device or not, identified by MAC where known and by IP otherwise
+CREATE TABLE IF NOT EXISTS hosts
+(
+    id         INTEGER PRIMARY KEY,
+    mac        TEXT UNIQUE,
+    ip         TEXT     NOT NULL,
+    -- The saved device the host was last matched to
+    device_id  INTEGER REFERENCES devices (id) ON DELETE SET NULL,
+    first_seen DATETIME NOT NULL,
+    last_seen  DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS hosts_ip ON hosts (ip) WHERE mac IS NULL;
+CREATE INDEX IF NOT EXISTS hosts_device_id ON hosts (device_id);
+
+-- Periods hosts were up, each spanning consecutive scans of a target that found them
+CREATE TABLE IF NOT EXISTS host_presence
+(
+    id           INTEGER PRIMARY KEY,
+    host_id      INTEGER  NOT NULL REFERENCES hosts (id) ON DELETE CASCADE,
+    target       TEXT     NOT NULL,
+    ip           TEXT     NOT NULL,
+    -- The scan that last found the host, the period goes on if the next scan of the target does too
+    last_scan_id INTEGER  NOT NULL,
+    from_time    DATETIME NOT NULL,
+    to_time      DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS host_presence_host_id ON host_presence (host_id, target, last_scan_id);
+CREATE INDEX IF NOT EXISTS host_presence_to_time ON host_presence (to_time);
+
+-- Alerts raised by scans, an unacknowledged alert absorbs repeats of itself
+CREATE TABLE IF NOT EXISTS alerts
+(
+    id              INTEGER PRIMARY KEY,
+    type            TEXT     NOT NULL,
+    dedup_key       TEXT     NOT NULL,
+    message         TEXT     NOT NULL,
+    target          TEXT     NOT NULL,
+    ip              TEXT     NOT NULL,
+    mac             TEXT     NOT NULL,
+    device_id       INTEGER REFERENCES devices (id) ON DELETE SET NULL,
+    count           INTEGER  NOT NULL DEFAULT 1,
+    created_at      DATETIME NOT NULL,
+    updated_at      DATETIME NOT NULL,
+    acknowledged_at DATETIME,
+    acknowledged_by INTEGER REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS alerts_open ON alerts (dedup_key) WHERE acknowledged_at IS NULL;
+
 CREATE TABLE IF NOT EXISTS documents
 (
     id                INTEGER PRIMARY KEY,
     name              TEXT        NOT NULL,
     file_name         TEXT UNIQUE NOT NULL,
-    payload_encrypted BLOB        NOT NULL
+    payload_encrypted BLOB        NOT NULL,
+    format            TEXT        NOT NULL DEFAULT 'markdown',
+    folder_id         INTEGER REFERENCES folders (id) ON DELETE SET NULL,
+    deleted_at        DATETIME
 );
 
-CREATE TABLE IF NOT EXISTS attachments
+CREATE TABLE IF NOT EXISTS document_revisions
+(
+    id                INTEGER PRIMARY KEY,
+    document_id       INTEGER  NOT NULL REFERENCES documents (id) ON DELETE CASCADE,
+    name              TEXT     NOT NULL,
+    payload_encrypted BLOB     NOT NULL,
+    format            TEXT     NOT NULL,
+    author_id         INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    created_at        DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS document_revisions_document_id ON document_revisions (document_id);
+
+CREATE TABLE IF NOT EXISTS document_templates
 (
     id          INTEGER PRIMARY KEY,
-    document_id INTEGER REFERENCES documents (id) ON DELETE CASCADE,
-    name        TEXT        NOT NULL,
-    file_name   TEXT UNIQUE NOT NULL,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL,
+    format      TEXT NOT NULL,
+    body        TEXT NOT NULL
+);
+
+-- Encrypted attachment contents, stored in files named by their keyed hash so equal ones are only kept once
+CREATE TABLE IF NOT EXISTS blobs
+(
+    hash       TEXT PRIMARY KEY,
+    size       INTEGER  NOT NULL,
+    -- Number of attachments referencing the blob, maintained by the triggers below
+    ref_count  INTEGER  NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS attachments
+(
+    id            INTEGER PRIMARY KEY,
+    document_id   INTEGER REFERENCES documents (id) ON DELETE CASCADE,
+    name          TEXT     NOT NULL,
+    blob_hash     TEXT     NOT NULL REFERENCES blobs (hash),
+    -- The blob's key encrypted with the document key, empty if the blob is encrypted with the document key itself
+    key_encrypted BLOB     NOT NULL,
+    content_type  TEXT     NOT NULL,
+    size          INTEGER  NOT NULL,
+    uploaded_by   INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    created_at    DATETIME NOT NULL,
 
     UNIQUE (document_id, name)
 );
 
+CREATE INDEX IF NOT EXISTS attachments_blob_hash ON attachments (blob_hash);
+
+CREATE TRIGGER IF NOT EXISTS attachments_ref
+    AFTER INSERT
+    ON attachments
+BEGIN
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = NEW.blob_hash;
+END;
+
+-- Also fires for attachments deleted along with their documents
+CREATE TRIGGER IF NOT EXISTS attachments_unref
+    AFTER DELETE
+    ON attachments
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = OLD.blob_hash;
+END;
+
 CREATE TABLE IF NOT EXISTS document_keys
 (
     user_id       INTEGER REFERENCES users (id) ON DELETE CASCADE,
@@ -74,3 +297,45 @@ CREATE TABLE IF NOT EXISTS document_keys
 
     PRIMARY KEY (user_id, document_id)
 );
+
+
+CREATE TABLE IF NOT EXISTS tags
+(
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+-- Objects are referenced by type and id, so rows of deleted objects are cleaned up manually
+CREATE TABLE IF NOT EXISTS object_tags
+(
+    tag_id      INTEGER REFERENCES tags (id) ON DELETE CASCADE,
+    object_type TEXT    NOT NULL,
+    object_id   INTEGER NOT NULL,
+
+    PRIMARY KEY (tag_id, object_type, object_id)
+);
+
+-- Directed links between objects, e.g. a device uses a password
+CREATE TABLE IF NOT EXISTS relationships
+(
+    id          INTEGER PRIMARY KEY,
+    source_type TEXT     NOT NULL,
+    source_id   INTEGER  NOT NULL,
+    kind        TEXT     NOT NULL,
+    target_type TEXT     NOT NULL,
+    target_id   INTEGER  NOT NULL,
+    created_at  DATETIME NOT NULL,
+
+    UNIQUE (source_type, source_id, kind, target_type, target_id)
+);
+
+CREATE INDEX IF NOT EXISTS relationships_target ON relationships (target_type, target_id);
+
+CREATE TABLE IF NOT EXISTS favorites
+(
+    user_id     INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    object_type TEXT    NOT NULL,
+    object_id   INTEGER NOT NULL,
+
+    PRIMARY KEY (user_id, object_type, object_id)
+);
\ No newline at end of file
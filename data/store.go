@@ -1,29 +1,63 @@
 package data
 
 import (
+	"errors"
 	"github.com/TaeKwonZeus/pva/crypt"
 	"github.com/TaeKwonZeus/pva/network"
+	"github.com/TaeKwonZeus/pva/notify"
 	"github.com/charmbracelet/log"
 	"github.com/jmoiron/sqlx"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // Store abstracts away cryptographic operations on data from db.
 type Store struct {
 	db *db
+	// attachmentDir holds encrypted attachment contents
+	attachmentDir string
+	// blobSecret keys the hashes blobs are named by
+	blobSecret []byte
+	// blobMu keeps blobs from being collected while an upload starts referring to them
+	blobMu sync.Mutex
+
+	trashRetention time.Duration
+	// rotationMu keeps concurrent requests from rotating the same vault key twice, and vault keys from being
+	// rotated while something is being encrypted with them
+	rotationMu sync.Mutex
+
+	alertRules AlertRules
+	notifier   notify.Notifier
 }
 
 func NewStore(path string) (*Store, error) {
-	pool, err := sqlx.Open("sqlite3", path)
+	// Foreign keys are enabled per connection, so the pragma in startupQuery alone isn't enough
+	pool, err := sqlx.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = pool.Exec(startupQuery)
+	if err = migrate(pool); err != nil {
+		return nil, err
+	}
+
+	attachmentDir := filepath.Join(filepath.Dir(path), "attachments")
+	if err = os.MkdirAll(attachmentDir, 0700); err != nil {
+		return nil, err
+	}
+
+	blobSecret, err := loadSecret(filepath.Join(filepath.Dir(path), blobSecretFilename))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Store{db: &db{pool}}, nil
+	return &Store{db: &db{pool}, attachmentDir: attachmentDir, blobSecret: blobSecret}, nil
 }
 
 func (s *Store) Close() error {
@@ -44,10 +78,6 @@ func (s *Store) VerifyPassword(username string, password string) (verified bool,
 	return true, user
 }
 
-func (s *Store) GetIndex(id int) (index Index, err error) {
-	return s.db.getIndex(id)
-}
-
 func (s *Store) GetUserCount() (n int, err error) {
 	return s.db.getUserCount()
 }
@@ -83,6 +113,10 @@ func (s *Store) CreateUser(user User, password string) error {
 }
 
 func (s *Store) CreateVault(vault Vault, user User) error {
+	if err := s.checkFolder(vault.FolderID); err != nil {
+		return err
+	}
+
 	key, err := crypt.NewAesKey()
 	if err != nil {
 		return err
@@ -116,6 +150,14 @@ func (s *Store) CreateVault(vault Vault, user User) error {
 		}
 		vaultKeys = append(vaultKeys, vaultKey{UserId: admin.ID, VaultId: vaultId, KeyEncrypted: vaultKeyEncrypted})
 	}
+
+	wrapped, err := s.wrapForFolder(vault.FolderID, key)
+	if err != nil {
+		return err
+	}
+	for userId, keyEncrypted := range wrapped {
+		vaultKeys = append(vaultKeys, vaultKey{UserId: userId, VaultId: vaultId, KeyEncrypted: keyEncrypted})
+	}
 	return s.db.createVaultKeys(vaultKeys...)
 }
 
@@ -126,6 +168,9 @@ func decryptVault(vault *Vault, user User) error {
 	}
 
 	for i := range vault.Passwords {
+		if !canView(vault.Passwords[i], user) {
+			continue
+		}
 		passwordDecrypted, err := crypt.AesDecrypt(vault.Passwords[i].PasswordEncrypted, vaultKey)
 		if err != nil {
 			return err
@@ -137,12 +182,110 @@ func decryptVault(vault *Vault, user User) error {
 	return nil
 }
 
+// rotateVaultKey replaces the key of a vault whose time-limited access has lapsed, so that a copy of the
+// old key can't decrypt anything stored from now on. It needs a key holder's private key, so it's done
+// lazily the next time a holder opens the vault or writes to it.
+func (s *Store) rotateVaultKey(vault *Vault, user User) error {
+	s.rotationMu.Lock()
+	defer s.rotationMu.Unlock()
+	return s.rotateVaultKeyLocked(vault, user)
+}
+
+// rotateVaultKeyLocked is rotateVaultKey for callers already holding rotationMu.
+func (s *Store) rotateVaultKeyLocked(vault *Vault, user User) error {
+	// Another request might have rotated the key while we were waiting
+	current, err := s.db.getVault(vault.ID, user.ID)
+	if err != nil {
+		return err
+	}
+	if !current.KeyRotationPending {
+		*vault = current
+		return nil
+	}
+
+	oldKey, err := crypt.RsaDecrypt(current.KeyEncrypted, user.PrivateKey)
+	if err != nil {
+		return err
+	}
+	newKey, err := crypt.NewAesKey()
+	if err != nil {
+		return err
+	}
+
+	passwords, err := s.db.getAllPasswords(vault.ID)
+	if err != nil {
+		return err
+	}
+	for i := range passwords {
+		plaintext, err := crypt.AesDecrypt(passwords[i].PasswordEncrypted, oldKey)
+		if err != nil {
+			return err
+		}
+		passwords[i].PasswordEncrypted, err = crypt.AesEncrypt(plaintext, newKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	holders, err := s.db.getVaultHolders(vault.ID)
+	if err != nil {
+		return err
+	}
+	keys := make([]vaultKey, 0, len(holders))
+	for _, holder := range holders {
+		keyEncrypted, err := crypt.RsaEncrypt(newKey, holder.PublicKey)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, vaultKey{UserId: holder.UserId, VaultId: vault.ID, KeyEncrypted: keyEncrypted})
+	}
+
+	if err = s.db.replaceVaultKey(vault.ID, passwords, keys); err != nil {
+		return err
+	}
+	log.Info("rotated vault key", "vault", vault.ID)
+
+	*vault, err = s.db.getVault(vault.ID, user.ID)
+	return err
+}
+
+// canView reports whether the user may see the password's value, which for privileged passwords requires
+// having it checked out.
+func canView(password Password, user User) bool {
+	if !password.CheckoutRequired {
+		return true
+	}
+	return password.Checkout != nil && password.Checkout.UserID == user.ID
+}
+
+// annotateVault fills in tags and favorites of the vault and its passwords.
+func (a annotations) annotateVault(vault *Vault) {
+	vault.Tags, vault.Favorite = a.of(ObjectVault, vault.ID)
+	vault.Path = a.folders.path(vault.FolderID)
+	for i := range vault.Passwords {
+		vault.Passwords[i].Tags, vault.Passwords[i].Favorite = a.of(ObjectPassword, vault.Passwords[i].ID)
+	}
+}
+
 func (s *Store) GetVault(id int, user User) (vault Vault, err error) {
 	vault, err = s.db.getVault(id, user.ID)
 	if err != nil {
 		return
 	}
-	err = decryptVault(&vault, user)
+	if vault.KeyRotationPending {
+		if err = s.rotateVaultKey(&vault, user); err != nil {
+			return
+		}
+	}
+	if err = decryptVault(&vault, user); err != nil {
+		return
+	}
+
+	a, err := s.getAnnotations(user)
+	if err != nil {
+		return
+	}
+	a.annotateVault(&vault)
 	return
 }
 
@@ -153,11 +296,21 @@ func (s *Store) GetVaults(user User) (vaults []Vault, err error) {
 	}
 	log.Infof("Before: %v", vaults)
 
+	a, err := s.getAnnotations(user)
+	if err != nil {
+		return
+	}
 	for i := range vaults {
+		if vaults[i].KeyRotationPending {
+			if err = s.rotateVaultKey(&vaults[i], user); err != nil {
+				return nil, err
+			}
+		}
 		err = decryptVault(&vaults[i], user)
 		if err != nil {
 			return nil, err
 		}
+		a.annotateVault(&vaults[i])
 	}
 	log.Infof("After: %v", vaults)
 	return
@@ -180,6 +333,37 @@ func (s *Store) DeleteVault(id int) error {
 	return s.db.deleteVault(id)
 }
 
+func (s *Store) RestoreVault(id int) error {
+	return s.db.restoreVault(id)
+}
+
+// vaultKeyForWrite returns the key of the vault to encrypt with, rotating it first if a grant has lapsed. The
+// caller must hold rotationMu until what it encrypted is written, or a rotation in between would leave it
+// encrypted with the old key.
+func (s *Store) vaultKeyForWrite(vaultId int, user User) ([]byte, error) {
+	vault, err := s.db.getVault(vaultId, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if vault.KeyRotationPending {
+		if err = s.rotateVaultKeyLocked(&vault, user); err != nil {
+			return nil, err
+		}
+	}
+	return crypt.RsaDecrypt(vault.KeyEncrypted, user.PrivateKey)
+}
+
+// CheckVaultAdministration reports whether the user has permanent access to the vault, which sharing it and
+// deciding requests for it take. Time-limited access doesn't allow handing out more access.
+func (s *Store) CheckVaultAdministration(vaultId int, user User) bool {
+	grant, err := s.db.getVaultGrant(vaultId, user.ID)
+	if err != nil || grant.ExpiresAt != nil {
+		return false
+	}
+	_, err = crypt.RsaDecrypt(grant.KeyEncrypted, user.PrivateKey)
+	return err == nil
+}
+
 func (s *Store) getDecryptedVaultKey(vaultId int, user User) ([]byte, error) {
 	keyEncrypted, err := s.db.getVaultKey(vaultId, user.ID)
 	if err != nil {
@@ -188,31 +372,105 @@ func (s *Store) getDecryptedVaultKey(vaultId int, user User) ([]byte, error) {
 	return crypt.RsaDecrypt(keyEncrypted, user.PrivateKey)
 }
 
-func (s *Store) ShareVault(vaultId int, target User, user User) error {
-	key, err := s.getDecryptedVaultKey(vaultId, user)
+// ShareVault gives target access to the vault until expiresAt, or permanently if expiresAt is nil. Sharing
+// replaces the expiry of time-limited access target already has, but never limits permanent access.
+func (s *Store) ShareVault(vaultId int, target User, user User, expiresAt *time.Time) error {
+	s.rotationMu.Lock()
+	defer s.rotationMu.Unlock()
+
+	key, err := s.vaultKeyFor(vaultId, target, user, expiresAt)
 	if err != nil {
 		return err
 	}
+	return s.db.grantVaultKey(key)
+}
+
+// vaultKeyFor encrypts the key of the vault for target. The caller must hold rotationMu until the key is
+// written.
+func (s *Store) vaultKeyFor(vaultId int, target User, user User, expiresAt *time.Time) (vaultKey, error) {
+	key, err := s.vaultKeyForWrite(vaultId, user)
+	if err != nil {
+		return vaultKey{}, err
+	}
 
 	keyEncrypted, err := crypt.RsaEncrypt(key, target.PublicKey)
 	if err != nil {
-		return err
+		return vaultKey{}, err
 	}
 
-	return s.db.createVaultKeys(vaultKey{
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+	return vaultKey{
 		UserId:       target.ID,
 		VaultId:      vaultId,
 		KeyEncrypted: keyEncrypted,
-	})
+		ExpiresAt:    expiresAt,
+	}, nil
 }
 
-func (s *Store) CreatePassword(password Password, vaultId int, user User) error {
-	vault, err := s.db.getVault(vaultId, user.ID)
+// StartAccessExpiry revokes lapsed time-limited vault access, checking every interval.
+func (s *Store) StartAccessExpiry(interval time.Duration) {
+	go func() {
+		for ; ; time.Sleep(interval) {
+			n, err := s.db.expireVaultKeys(time.Now())
+			if err != nil {
+				log.Error("access expiry error", "err", err)
+				continue
+			}
+			if n > 0 {
+				log.Info("revoked expired vault access", "keys", n)
+			}
+		}
+	}()
+	log.Info("access expiry started")
+}
+
+func (s *Store) VaultExists(id int) (bool, error) {
+	return s.db.vaultExists(id)
+}
+
+func (s *Store) CreateAccessRequest(req AccessRequest) error {
+	_, err := s.db.createAccessRequest(req)
+	return err
+}
+
+func (s *Store) GetAccessRequest(id int) (AccessRequest, error) {
+	return s.db.getAccessRequest(id)
+}
+
+func (s *Store) GetAccessRequests(user User) ([]AccessRequest, error) {
+	return s.db.getAccessRequests(user.ID)
+}
+
+// ApproveAccessRequest shares the requested vault with the requester for duration and closes the request.
+func (s *Store) ApproveAccessRequest(req AccessRequest, duration time.Duration, user User) error {
+	target, err := s.db.getUser(req.UserID)
 	if err != nil {
 		return err
 	}
 
-	vaultKey, err := crypt.RsaDecrypt(vault.KeyEncrypted, user.PrivateKey)
+	s.rotationMu.Lock()
+	defer s.rotationMu.Unlock()
+
+	expiresAt := time.Now().Add(duration)
+	key, err := s.vaultKeyFor(req.VaultID, target, user, &expiresAt)
+	if err != nil {
+		return err
+	}
+	return s.db.approveAccessRequest(req.ID, user.ID, key)
+}
+
+func (s *Store) DenyAccessRequest(req AccessRequest, user User) error {
+	return s.db.decideAccessRequest(req.ID, AccessRequestDenied, user.ID, nil)
+}
+
+func (s *Store) CreatePassword(password Password, vaultId int, user User) error {
+	s.rotationMu.Lock()
+	defer s.rotationMu.Unlock()
+
+	vaultKey, err := s.vaultKeyForWrite(vaultId, user)
 	if err != nil {
 		return err
 	}
@@ -225,13 +483,18 @@ func (s *Store) CreatePassword(password Password, vaultId int, user User) error
 	return err
 }
 
-func (s *Store) UpdatePassword(password Password, vaultId int, user User) error {
+// UpdatePassword changes the fields of the password that are set, and whether it requires checkout if
+// checkoutRequired isn't nil.
+func (s *Store) UpdatePassword(password Password, vaultId int, checkoutRequired *bool, user User) error {
 	// If password isn't being updated we can skip any cryptographic operations altogether
 	if password.Password == "" {
-		return s.db.updatePassword(password)
+		return s.db.updatePassword(password, vaultId, checkoutRequired)
 	}
 
-	key, err := s.getDecryptedVaultKey(vaultId, user)
+	s.rotationMu.Lock()
+	defer s.rotationMu.Unlock()
+
+	key, err := s.vaultKeyForWrite(vaultId, user)
 	if err != nil {
 		return err
 	}
@@ -241,11 +504,114 @@ func (s *Store) UpdatePassword(password Password, vaultId int, user User) error
 		return err
 	}
 
-	return s.db.updatePassword(password)
+	return s.db.updatePassword(password, vaultId, checkoutRequired)
 }
 
-func (s *Store) DeletePassword(id int) error {
-	return s.db.deletePassword(id)
+func (s *Store) DeletePassword(id, vaultId int) error {
+	return s.db.deletePassword(id, vaultId)
+}
+
+func (s *Store) RestorePassword(id, vaultId int) error {
+	return s.db.restorePassword(id, vaultId)
+}
+
+var (
+	// ErrCheckedOut is returned when checking out a password that is already checked out, or checking in
+	// someone else's checkout.
+	ErrCheckedOut = errors.New("password is already checked out")
+	// ErrCheckoutNotRequired is returned when checking out a password anyone with access can read anyway.
+	ErrCheckoutNotRequired = errors.New("password doesn't require checkout")
+)
+
+// CheckoutPassword gives the user exclusive access to a privileged password for duration, returning
+// the decrypted password. Admins can override an existing checkout, which checks it in.
+func (s *Store) CheckoutPassword(id, vaultId int, reason string, duration time.Duration, override bool,
+	user User) (password Password, err error) {
+	password, err = s.db.getPassword(id, vaultId)
+	if err != nil {
+		return
+	}
+	if !password.CheckoutRequired {
+		return password, ErrCheckoutNotRequired
+	}
+
+	active, err := s.db.getActiveCheckout(id)
+	if err == nil {
+		if active.UserID == user.ID || !override || user.Role != RoleAdmin {
+			return password, ErrCheckedOut
+		}
+		if err = s.db.checkIn(active.ID, user.ID); err != nil {
+			return
+		}
+		log.Warn("checkout overridden", "password", id, "holder", active.Username, "admin", user.Username)
+	} else if !IsErrNotFound(err) {
+		return
+	}
+
+	now := time.Now()
+	checkout := Checkout{
+		PasswordID:   id,
+		UserID:       user.ID,
+		Username:     user.Username,
+		Reason:       reason,
+		CheckedOutAt: now,
+		ExpiresAt:    now.Add(duration),
+	}
+	checkout.ID, err = s.db.createCheckout(checkout)
+	if IsErrConflict(err) {
+		return password, ErrCheckedOut
+	}
+	if err != nil {
+		return
+	}
+	password.Checkout = &checkout
+
+	key, err := s.getDecryptedVaultKey(vaultId, user)
+	if err != nil {
+		return
+	}
+	plaintext, err := crypt.AesDecrypt(password.PasswordEncrypted, key)
+	if err != nil {
+		return
+	}
+	password.Password = string(plaintext)
+	return
+}
+
+// CheckInPassword ends the active checkout of a password. Only the holder or an admin can check it in.
+func (s *Store) CheckInPassword(id, vaultId int, user User) error {
+	if _, err := s.db.getPassword(id, vaultId); err != nil {
+		return err
+	}
+
+	active, err := s.db.getActiveCheckout(id)
+	if err != nil {
+		return err
+	}
+	if active.UserID != user.ID && user.Role != RoleAdmin {
+		return ErrCheckedOut
+	}
+
+	return s.db.checkIn(active.ID, user.ID)
+}
+
+func (s *Store) GetCheckouts(id, vaultId int) ([]Checkout, error) {
+	if _, err := s.db.getPassword(id, vaultId); err != nil {
+		return nil, err
+	}
+	return s.db.getCheckouts(id)
+}
+
+// StartCheckoutExpiry ends lapsed checkouts and marks their passwords for rotation, checking every interval.
+func (s *Store) StartCheckoutExpiry(interval time.Duration) {
+	go func() {
+		for ; ; time.Sleep(interval) {
+			if err := s.db.expireAllCheckouts(time.Now()); err != nil {
+				log.Error("checkout expiry error", "err", err)
+			}
+		}
+	}()
+	log.Info("checkout expiry started")
 }
 
 func (s *Store) CreateDevice(device Device) error {
@@ -253,39 +619,171 @@ func (s *Store) CreateDevice(device Device) error {
 	return err
 }
 
-func (s *Store) GetDevices() (devices []Device, err error) {
+func (s *Store) GetDevices(user User) (devices []Device, err error) {
 	devices, err = s.db.getDevices()
 	if err != nil {
 		return
 	}
-	deviceMap := make(map[string]Device)
-	for _, device := range devices {
-		deviceMap[device.IP] = device
+	a, err := s.getAnnotations(user)
+	if err != nil {
+		return
+	}
+	for i := range devices {
+		devices[i].Tags, devices[i].Favorite = a.of(ObjectDevice, devices[i].ID)
+		if mac, err := net.ParseMAC(devices[i].MAC); err == nil {
+			devices[i].Vendor = network.Vendor(mac)
+		}
 	}
+	index := newDeviceIndex(devices)
 
 	scan := network.Devices()
 
 	// Add connected devices to the response, whether they're saved or not.
 	// If they are saved but not connected, Connected will equal false.
 	// If they are connected but not saved, ID will equal 0.
+	var unsaved []Device
 	for _, device := range scan {
+		scanned := scannedDevice(device)
+		mac, services := scanned.MAC, scanned.Services
+
 		// Entry is a pointer to the entry in the slice so we can just edit it
-		entry, ok := deviceMap[device.IP.String()]
-		if ok {
-			//entry.NetworkName = device.Name
-			//entry.MAC = device.MAC.String()
+		if entry := index.find(device); entry != nil {
+			s.trackDevice(entry, device, mac)
+			entry.NetworkName, entry.NameSource = device.Name, device.NameSource
+			if !slices.EqualFunc(entry.Services, services, sameService) {
+				if err := s.db.setDeviceServices(entry.ID, services); err != nil {
+					log.Warn("could not save device services", "device", entry.ID, "err", err)
+				}
+				entry.Services = services
+			}
 			entry.Connected = true
+			entry.Target = device.Target
+			// Addresses found by the scan are listed too, but only saved ones are used for matching
+			for _, ip := range scanned.IPv6 {
+				if !slices.Contains(entry.IPv6, ip) {
+					entry.IPv6 = append(entry.IPv6, ip)
+				}
+			}
 		} else {
-			devices = append(devices, Device{
-				IP: device.IP.String(),
-				//NetworkName: device.Name,
-				//MAC:         device.MAC.String(),
-				Connected: true,
-			})
+			unsaved = append(unsaved, scannedDevice(device))
 		}
 	}
 
-	return
+	devices = append(devices, unsaved...)
+	if err = s.fillSeen(devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// scannedDevice describes a device found by a scan, as it's listed when it isn't saved.
+func scannedDevice(device network.Device) Device {
+	ipv6 := make([]string, len(device.IPv6))
+	for i, ip := range device.IPv6 {
+		ipv6[i] = ip.String()
+	}
+	return Device{
+		IP:          device.IP.String(),
+		IPv6:        ipv6,
+		MAC:         macString(device.MAC),
+		Vendor:      device.Vendor,
+		NetworkName: device.Name,
+		NameSource:  device.NameSource,
+		Services:    convertServices(device.Services),
+		Connected:   true,
+		Target:      device.Target,
+		Tags:        []string{},
+	}
+}
+
+// deviceIndex finds the saved devices scanned devices are, by MAC, which survives DHCP handing out a new IP,
+// or else any of their addresses.
+type deviceIndex struct {
+	byMAC map[string]*Device
+	byIP  map[string]*Device
+}
+
+func newDeviceIndex(devices []Device) deviceIndex {
+	index := deviceIndex{byMAC: make(map[string]*Device), byIP: make(map[string]*Device)}
+	for i := range devices {
+		if devices[i].MAC != "" {
+			index.byMAC[devices[i].MAC] = &devices[i]
+		}
+		index.byIP[devices[i].IP] = &devices[i]
+		for _, ip := range devices[i].IPv6 {
+			index.byIP[ip] = &devices[i]
+		}
+	}
+	return index
+}
+
+// find returns the saved device the scanned one is, or nil if it isn't saved.
+func (index deviceIndex) find(device network.Device) *Device {
+	mac := macString(device.MAC)
+	if entry, ok := index.byMAC[mac]; ok {
+		return entry
+	}
+
+	entry := index.byIP[device.IP.String()]
+	for i := 0; entry == nil && i < len(device.IPv6); i++ {
+		entry = index.byIP[device.IPv6[i].String()]
+	}
+	// A different MAC at a saved address means the address now belongs to another device
+	if entry != nil && entry.MAC != "" && mac != "" && entry.MAC != mac {
+		return nil
+	}
+	return entry
+}
+
+func macString(mac net.HardwareAddr) string {
+	if mac == nil {
+		return ""
+	}
+	return mac.String()
+}
+
+func convertServices(scanned []network.Service) []Service {
+	services := make([]Service, len(scanned))
+	for i, s := range scanned {
+		services[i] = Service{Port: s.Port, Name: s.Name, Banner: s.Banner}
+		if s.Certificate != nil {
+			expires := s.Certificate.NotAfter.UTC()
+			services[i].CertSubject, services[i].CertIssuer = &s.Certificate.Subject, &s.Certificate.Issuer
+			services[i].CertExpiresAt = &expires
+		}
+	}
+	return services
+}
+
+func sameService(a, b Service) bool {
+	sameString := func(a, b *string) bool { return (a == nil) == (b == nil) && (a == nil || *a == *b) }
+	return a.Port == b.Port && a.Name == b.Name && a.Banner == b.Banner &&
+		sameString(a.CertSubject, b.CertSubject) && sameString(a.CertIssuer, b.CertIssuer) &&
+		(a.CertExpiresAt == nil) == (b.CertExpiresAt == nil) &&
+		(a.CertExpiresAt == nil || a.CertExpiresAt.Equal(*b.CertExpiresAt))
+}
+
+// trackDevice saves the MAC of a device the first time it's found, and its new IPv4 address when it's found by
+// MAC somewhere else.
+func (s *Store) trackDevice(entry *Device, device network.Device, mac string) {
+	ip := entry.IP
+	if device.IP.Is4() {
+		ip = device.IP.String()
+	}
+	if mac == "" || (entry.MAC == mac && entry.IP == ip) {
+		return
+	}
+
+	// Another saved device might still hold the address, which then has to be sorted out by hand
+	if err := s.db.updateDeviceAddress(entry.ID, ip, mac); err != nil {
+		log.Warn("could not update device address", "device", entry.ID, "ip", ip, "mac", mac, "err", err)
+		return
+	}
+	if entry.IP != ip {
+		log.Info("device changed address", "device", entry.ID, "from", entry.IP, "to", ip)
+	}
+	entry.IP, entry.MAC = ip, mac
+	entry.Vendor = device.Vendor
 }
 
 func (s *Store) UpdateDevice(device Device) error {
@@ -296,23 +794,39 @@ func (s *Store) DeleteDevice(id int) error {
 	return s.db.deleteDevice(id)
 }
 
-func (s *Store) CreateDocument(doc Document, user User) error {
+func (s *Store) RestoreDevice(id int) error {
+	return s.db.restoreDevice(id)
+}
+
+// CreateDocument encrypts the document with a new key shared with the user and all admins.
+func (s *Store) CreateDocument(doc Document, user User) (id int, err error) {
+	if doc.Format == "" {
+		doc.Format = FormatMarkdown
+	}
+	if err = s.checkFolder(doc.FolderID); err != nil {
+		return
+	}
+
 	key, err := crypt.NewAesKey()
 	if err != nil {
-		return err
+		return
 	}
 
 	keyEncrypted, err := crypt.RsaEncrypt(key, user.PublicKey)
 	if err != nil {
-		return err
+		return
 	}
 	doc.PayloadEncrypted, err = crypt.AesEncrypt([]byte(doc.Payload), key)
 	if err != nil {
-		return err
+		return
 	}
-	docId, err := s.db.createDocument(doc)
+	doc.FileName, err = crypt.RandomName()
 	if err != nil {
-		return err
+		return
+	}
+	docId, err := s.db.createDocument(doc, user.ID)
+	if err != nil {
+		return
 	}
 
 	documentKeys := []documentKey{{
@@ -323,12 +837,12 @@ func (s *Store) CreateDocument(doc Document, user User) error {
 
 	admins, err := s.db.getAdmins()
 	if err != nil {
-		return err
+		return
 	}
 	for _, admin := range admins {
 		keyEncrypted, err = crypt.RsaEncrypt(key, admin.PublicKey)
 		if err != nil {
-			return err
+			return
 		}
 		documentKeys = append(documentKeys, documentKey{
 			UserId:       admin.ID,
@@ -337,7 +851,46 @@ func (s *Store) CreateDocument(doc Document, user User) error {
 		})
 	}
 
-	return s.db.createDocumentKeys(documentKeys...)
+	wrapped, err := s.wrapForFolder(doc.FolderID, key)
+	if err != nil {
+		return
+	}
+	for userId, keyEncrypted := range wrapped {
+		documentKeys = append(documentKeys, documentKey{UserId: userId, DocumentId: docId, KeyEncrypted: keyEncrypted})
+	}
+
+	return docId, s.db.createDocumentKeys(documentKeys...)
+}
+
+func decryptDocument(doc *Document, user User) error {
+	key, err := crypt.RsaDecrypt(doc.KeyEncrypted, user.PrivateKey)
+	if err != nil {
+		return err
+	}
+	payload, err := crypt.AesDecrypt(doc.PayloadEncrypted, key)
+	if err != nil {
+		return err
+	}
+	doc.Payload = string(payload)
+	return nil
+}
+
+func (s *Store) GetDocument(id int, user User) (doc Document, err error) {
+	doc, err = s.db.getDocument(id, user.ID)
+	if err != nil {
+		return
+	}
+	if err = decryptDocument(&doc, user); err != nil {
+		return
+	}
+
+	a, err := s.getAnnotations(user)
+	if err != nil {
+		return
+	}
+	doc.Tags, doc.Favorite = a.of(ObjectDocument, doc.ID)
+	doc.Path = a.folders.path(doc.FolderID)
+	return
 }
 
 func (s *Store) GetDocuments(user User) (docs []Document, err error) {
@@ -345,20 +898,371 @@ func (s *Store) GetDocuments(user User) (docs []Document, err error) {
 	if err != nil {
 		return
 	}
+	a, err := s.getAnnotations(user)
+	if err != nil {
+		return
+	}
 	for i := range docs {
-		key, err := crypt.RsaDecrypt(docs[i].KeyEncrypted, user.PrivateKey)
+		docs[i].Tags, docs[i].Favorite = a.of(ObjectDocument, docs[i].ID)
+		docs[i].Path = a.folders.path(docs[i].FolderID)
+		if err = decryptDocument(&docs[i], user); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// GetDocumentInfos lists the documents in a folder the user has access to, or top level ones if folderId is nil.
+func (s *Store) GetDocumentInfos(folderId *int, user User) ([]DocumentInfo, error) {
+	docs, err := s.db.getDocumentInfos(folderId, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range docs {
+		docs[i].Size = max(docs[i].Size-crypt.AesOverhead, 0)
+	}
+	return docs, nil
+}
+
+func (s *Store) getDecryptedDocumentKey(docId int, user User) ([]byte, error) {
+	keyEncrypted, err := s.db.getDocumentKey(docId, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return crypt.RsaDecrypt(keyEncrypted, user.PrivateKey)
+}
+
+// UpdateDocument changes the name and payload of the document if they're not empty.
+func (s *Store) UpdateDocument(doc Document, user User) error {
+	// If payload isn't being updated we can skip any cryptographic operations altogether
+	if doc.Payload == "" {
+		return s.db.updateDocument(doc, user.ID)
+	}
+
+	key, err := s.getDecryptedDocumentKey(doc.ID, user)
+	if err != nil {
+		return err
+	}
+
+	doc.PayloadEncrypted, err = crypt.AesEncrypt([]byte(doc.Payload), key)
+	if err != nil {
+		return err
+	}
+
+	return s.db.updateDocument(doc, user.ID)
+}
+
+// SetDocumentPayload replaces the payload of a document. Unlike with UpdateDocument, an empty payload empties
+// the document.
+func (s *Store) SetDocumentPayload(id int, payload string, user User) error {
+	key, err := s.getDecryptedDocumentKey(id, user)
+	if err != nil {
+		return err
+	}
+	payloadEncrypted, err := crypt.AesEncrypt([]byte(payload), key)
+	if err != nil {
+		return err
+	}
+	return s.db.updateDocument(Document{ID: id, PayloadEncrypted: payloadEncrypted}, user.ID)
+}
+
+// GetRevisions retrieves the revisions of a document without their payloads, newest first.
+func (s *Store) GetRevisions(docId int) ([]Revision, error) {
+	return s.db.getRevisions(docId)
+}
+
+func (s *Store) GetRevision(id, docId int, user User) (revision Revision, err error) {
+	revision, err = s.db.getRevision(id, docId)
+	if err != nil {
+		return
+	}
+	key, err := s.getDecryptedDocumentKey(docId, user)
+	if err != nil {
+		return
+	}
+	payload, err := crypt.AesDecrypt(revision.PayloadEncrypted, key)
+	if err != nil {
+		return
+	}
+	revision.Payload = string(payload)
+	return
+}
+
+// DiffRevisions computes the line diff between the payloads of two revisions of a document. If to is 0 the
+// current payload is used.
+func (s *Store) DiffRevisions(docId, from, to int, user User) (diff Diff, err error) {
+	old, err := s.GetRevision(from, docId, user)
+	if err != nil {
+		return
+	}
+
+	var payload string
+	if to == 0 {
+		doc, err := s.GetDocument(docId, user)
 		if err != nil {
-			return
+			return diff, err
 		}
-		payload, err := crypt.AesDecrypt(key, docs[i].PayloadEncrypted)
+		payload = doc.Payload
+	} else {
+		revision, err := s.GetRevision(to, docId, user)
+		if err != nil {
+			return diff, err
+		}
+		payload = revision.Payload
+	}
+
+	return Diff{
+		From:  from,
+		To:    to,
+		Lines: diffLines(splitLines(old.Payload), splitLines(payload)),
+	}, nil
+}
+
+// RestoreRevision makes an old revision of a document the current one, recording it as a new revision.
+func (s *Store) RestoreRevision(id, docId int, user User) error {
+	revision, err := s.db.getRevision(id, docId)
+	if err != nil {
+		return err
+	}
+	return s.db.updateDocument(Document{
+		ID:               docId,
+		Name:             revision.Name,
+		PayloadEncrypted: revision.PayloadEncrypted,
+		Format:           revision.Format,
+	}, user.ID)
+}
+
+func (s *Store) ShareDocument(docId int, target User, user User) error {
+	key, err := s.getDecryptedDocumentKey(docId, user)
+	if err != nil {
+		return err
+	}
+
+	keyEncrypted, err := crypt.RsaEncrypt(key, target.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	return s.db.createDocumentKeys(documentKey{
+		UserId:       target.ID,
+		DocumentId:   docId,
+		KeyEncrypted: keyEncrypted,
+	})
+}
+
+func (s *Store) CheckDocumentOwnership(docId int, user User) bool {
+	keyEncrypted, err := s.db.getDocumentKey(docId, user.ID)
+	if err != nil {
+		return false
+	}
+	_, err = crypt.RsaDecrypt(keyEncrypted, user.PrivateKey)
+	return err == nil
+}
+
+func (s *Store) DeleteDocument(id int) error {
+	return s.db.deleteDocument(id)
+}
+
+func (s *Store) RestoreDocument(id int) error {
+	return s.db.restoreDocument(id)
+}
+
+// GetTrash retrieves everything in trash the user has access to, along with when it's going to be purged.
+func (s *Store) GetTrash(user User) (items []TrashItem, err error) {
+	items, err = s.db.getTrash(user.ID, CheckPermission(user.Role, PermissionManageDevices))
+	if err != nil {
+		return
+	}
+	for i := range items {
+		items[i].PurgeAt = items[i].DeletedAt.Add(s.trashRetention)
+	}
+	return
+}
+
+// StartTrashPurge permanently deletes everything that has been in trash for longer than retention,
+// checking every interval.
+func (s *Store) StartTrashPurge(retention time.Duration, interval time.Duration) {
+	s.trashRetention = retention
+	go func() {
+		for ; ; time.Sleep(interval) {
+			n, err := s.db.purgeTrash(time.Now().Add(-retention))
+			if err != nil {
+				log.Error("trash purge error", "err", err)
+				continue
+			}
+			if n > 0 {
+				log.Info("purged trash", "items", n)
+			}
+			if err = s.collectBlobs(); err != nil {
+				log.Error("attachment cleanup error", "err", err)
+			}
+		}
+	}()
+	log.Info("trash purge started", "retention", retention)
+}
+
+// annotations holds the tags of objects and favorites of a user for filling in responses.
+type annotations struct {
+	tags      map[ObjectType]map[int][]string
+	favorites map[ObjectRef]bool
+	folders   folderTree
+}
+
+func (s *Store) getAnnotations(user User) (a annotations, err error) {
+	a.tags = make(map[ObjectType]map[int][]string)
+	for _, t := range []ObjectType{ObjectVault, ObjectPassword, ObjectDevice, ObjectDocument} {
+		a.tags[t], err = s.db.getTags(t)
 		if err != nil {
 			return
 		}
-		docs[i].Payload = string(payload)
 	}
+
+	favorites, err := s.db.getFavorites(user.ID)
+	if err != nil {
+		return
+	}
+	a.favorites = make(map[ObjectRef]bool)
+	for _, ref := range favorites {
+		a.favorites[ref] = true
+	}
+
+	a.folders, err = s.getFolderTree()
 	return
 }
 
-func (s *Store) GetAttachment(id int, user User) (attachment Attachment, err error) {
+func (a annotations) of(objectType ObjectType, id int) (tags []string, favorite bool) {
+	tags = a.tags[objectType][id]
+	if tags == nil {
+		tags = []string{}
+	}
+	return tags, a.favorites[ObjectRef{Type: objectType, ID: id}]
+}
+
+// CheckObjectAccess reports whether the object exists and the user has the key to it if it needs one.
+// Permissions aren't checked.
+func (s *Store) CheckObjectAccess(ref ObjectRef, user User) bool {
+	switch ref.Type {
+	case ObjectVault:
+		exists, err := s.db.vaultExists(ref.ID)
+		return err == nil && exists && s.CheckVaultOwnership(ref.ID, user)
+	case ObjectPassword:
+		vaultId, err := s.db.getPasswordVaultId(ref.ID)
+		return err == nil && s.CheckVaultOwnership(vaultId, user)
+	case ObjectDevice:
+		exists, err := s.db.deviceExists(ref.ID)
+		return err == nil && exists
+	case ObjectDocument:
+		_, err := s.db.getDocument(ref.ID, user.ID)
+		return err == nil && s.CheckDocumentOwnership(ref.ID, user)
+	default:
+		return false
+	}
+}
+
+var ErrSelfRelationship = errors.New("objects can't be related to themselves")
+
+// Link creates a relationship between two objects. Access to them isn't checked.
+func (s *Store) Link(rel Relationship) (id int, err error) {
+	if rel.Source == rel.Target {
+		return 0, ErrSelfRelationship
+	}
+	return s.db.createRelationship(rel)
+}
+
+func (s *Store) GetRelationship(id int) (Relationship, error) {
+	return s.db.getRelationship(id)
+}
+
+func (s *Store) Unlink(id int) error {
+	return s.db.deleteRelationship(id)
+}
+
+// GetRelated retrieves the objects linked to ref in either direction, skipping ones in trash. Access to them
+// isn't checked.
+func (s *Store) GetRelated(ref ObjectRef) (items []RelatedItem, err error) {
+	rels, err := s.db.getRelationships(ref)
+	if err != nil {
+		return
+	}
+
+	items = []RelatedItem{}
+	for _, rel := range rels {
+		item := RelatedItem{RelationshipID: rel.ID, Kind: rel.Kind}
+		other := rel.Target
+		if rel.Target == ref {
+			other = rel.Source
+		} else {
+			item.Outgoing = true
+		}
+		item.Type, item.ID = other.Type, other.ID
+
+		item.Title, item.VaultID, err = s.db.getObjectTitle(other)
+		if IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
 	return
 }
+
+// GetObjectTitle retrieves the name of an object, along with its vault if it's a password. It doesn't check
+// access.
+func (s *Store) GetObjectTitle(ref ObjectRef) (title string, vaultId int, err error) {
+	return s.db.getObjectTitle(ref)
+}
+
+const maxTagLength = 64
+
+// NormalizeTag makes tags case-insensitive and ignores surrounding whitespace.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+var ErrInvalidTag = errors.New("tags must be between 1 and 64 characters long")
+
+// SetTags replaces the tags of an object, returning ErrInvalidTag if a name is empty or too long.
+func (s *Store) SetTags(ref ObjectRef, names []string) error {
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = NormalizeTag(name)
+		if name == "" || utf8.RuneCountInString(name) > maxTagLength {
+			return ErrInvalidTag
+		}
+		if !slices.Contains(normalized, name) {
+			normalized = append(normalized, name)
+		}
+	}
+	return s.db.setTags(ref, normalized)
+}
+
+// GetTags lists the tags of the objects visible returns true for, counting only those objects. Tags only
+// other objects have are left out.
+func (s *Store) GetTags(visible func(ref ObjectRef) bool) ([]Tag, error) {
+	objects, err := s.db.getTaggedObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []Tag{}
+	for _, o := range objects {
+		if !visible(o.ObjectRef) {
+			continue
+		}
+		if len(tags) == 0 || tags[len(tags)-1].ID != o.TagId {
+			tags = append(tags, Tag{ID: o.TagId, Name: o.TagName})
+		}
+		tags[len(tags)-1].Count++
+	}
+	return tags, nil
+}
+
+func (s *Store) AddFavorite(ref ObjectRef, user User) error {
+	return s.db.addFavorite(user.ID, ref)
+}
+
+func (s *Store) RemoveFavorite(ref ObjectRef, user User) error {
+	return s.db.removeFavorite(user.ID, ref)
+}
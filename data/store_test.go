@@ -0,0 +1,66 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestStore opens a store on a new database in a temporary directory.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestUser creates a user and logs them in, so their private key is available.
+func newTestUser(t *testing.T, s *Store, username string, role Role) User {
+	t.Helper()
+	if err := s.CreateUser(User{Username: username, Role: role}, "password"); err != nil {
+		t.Fatal(err)
+	}
+	ok, user := s.VerifyPassword(username, "password")
+	if !ok {
+		t.Fatalf("%s can't log in", username)
+	}
+	if _, err := user.DecryptPrivateKey(user.DeriveKey("password")); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newTestVault creates a vault for the user, returning its id.
+func newTestVault(t *testing.T, s *Store, name string, user User) int {
+	t.Helper()
+	if err := s.CreateVault(Vault{Name: name}, user); err != nil {
+		t.Fatal(err)
+	}
+	vaults, err := s.GetVaults(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vaults {
+		if v.Name == name {
+			return v.ID
+		}
+	}
+	t.Fatalf("vault %s not found after creating it", name)
+	return 0
+}
+
+// vaultPasswords returns the decrypted passwords of a vault by name.
+func vaultPasswords(t *testing.T, s *Store, id int, user User) map[string]string {
+	t.Helper()
+	vault, err := s.GetVault(id, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords := make(map[string]string)
+	for _, p := range vault.Passwords {
+		passwords[p.Name] = p.Password
+	}
+	return passwords
+}
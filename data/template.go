@@ -0,0 +1,209 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/TaeKwonZeus/pva/templates"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Template is a blueprint for documents. Placeholders like {{site}} in the name and body get replaced with
+// variables on instantiation. Built-in templates are identified by their file name, others by their id.
+type Template struct {
+	ID          string         `json:"id" db:"id"`
+	Builtin     bool           `json:"builtin"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	Format      DocumentFormat `json:"format" db:"format"`
+	Body        string         `json:"body" db:"body"`
+	Variables   []string       `json:"variables"`
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// variables lists the placeholders of the template in order of appearance.
+func (t *Template) variables() []string {
+	vars := []string{}
+	for _, m := range placeholderPattern.FindAllStringSubmatch(t.Name+"\n"+t.Body, -1) {
+		if !slices.Contains(vars, m[1]) {
+			vars = append(vars, m[1])
+		}
+	}
+	return vars
+}
+
+// MissingVariablesError is returned when instantiating a template without all of its variables.
+type MissingVariablesError struct {
+	Names []string
+}
+
+func (e MissingVariablesError) Error() string {
+	return "missing template variables: " + strings.Join(e.Names, ", ")
+}
+
+var ErrBuiltinTemplate = errors.New("built-in templates can't be modified")
+
+// parseBuiltin parses a template file starting with a front matter of "key: value" lines between "---".
+func parseBuiltin(id string, content string) (t Template, err error) {
+	t = Template{ID: id, Builtin: true, Format: FormatMarkdown}
+
+	rest, ok := strings.CutPrefix(content, "---\n")
+	if !ok {
+		return t, errors.New("template " + id + " has no front matter")
+	}
+	header, body, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		return t, errors.New("template " + id + " has unterminated front matter")
+	}
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "name":
+			t.Name = value
+		case "description":
+			t.Description = value
+		case "format":
+			t.Format = DocumentFormat(value)
+		}
+	}
+	if t.Name == "" || !t.Format.Valid() {
+		return t, errors.New("template " + id + " has no name or an invalid format")
+	}
+
+	t.Body = body
+	t.Variables = t.variables()
+	return t, nil
+}
+
+var getBuiltinTemplates = sync.OnceValues(func() ([]Template, error) {
+	builtin := templates.Builtin()
+	files, err := fs.Glob(builtin, "*.md")
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Template, 0, len(files))
+	for _, file := range files {
+		content, err := fs.ReadFile(builtin, file)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseBuiltin(strings.TrimSuffix(file, ".md"), string(content))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, nil
+})
+
+// GetTemplates retrieves the built-in templates followed by user-created ones.
+func (s *Store) GetTemplates() ([]Template, error) {
+	list, err := getBuiltinTemplates()
+	if err != nil {
+		return nil, err
+	}
+	custom, err := s.db.getTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for i := range custom {
+		custom[i].Variables = custom[i].variables()
+	}
+	return append(slices.Clone(list), custom...), nil
+}
+
+func (s *Store) GetTemplate(id string) (t Template, err error) {
+	builtin, err := getBuiltinTemplates()
+	if err != nil {
+		return
+	}
+	if i := slices.IndexFunc(builtin, func(t Template) bool { return t.ID == id }); i != -1 {
+		return builtin[i], nil
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return t, sql.ErrNoRows
+	}
+	t, err = s.db.getTemplate(n)
+	if err != nil {
+		return
+	}
+	t.Variables = t.variables()
+	return
+}
+
+func (s *Store) CreateTemplate(t Template) (id int, err error) {
+	if t.Format == "" {
+		t.Format = FormatMarkdown
+	}
+	return s.db.createTemplate(t)
+}
+
+// DeleteTemplate deletes a user-created template, returning ErrBuiltinTemplate for built-in ones.
+func (s *Store) DeleteTemplate(id string) error {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		builtin, err := getBuiltinTemplates()
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(builtin, func(t Template) bool { return t.ID == id }) {
+			return ErrBuiltinTemplate
+		}
+		return sql.ErrNoRows
+	}
+	return s.db.deleteTemplate(n)
+}
+
+// InstantiateTemplate creates a document from a template, replacing its placeholders with vars. The date and
+// author variables default to today and the user's name. If name is empty the template's name is used.
+// Returns MissingVariablesError if vars don't cover every placeholder.
+func (s *Store) InstantiateTemplate(id string, name string, vars map[string]string, user User) (docId int,
+	err error) {
+	t, err := s.GetTemplate(id)
+	if err != nil {
+		return
+	}
+
+	values := map[string]string{
+		"date":   time.Now().Format(time.DateOnly),
+		"author": user.Username,
+	}
+	for k, v := range vars {
+		values[k] = v
+	}
+
+	var missing []string
+	for _, v := range t.Variables {
+		if _, ok := values[v]; !ok {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return 0, MissingVariablesError{missing}
+	}
+
+	fill := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			return values[placeholderPattern.FindStringSubmatch(m)[1]]
+		})
+	}
+	if name == "" {
+		name = fill(t.Name)
+	}
+
+	return s.CreateDocument(Document{
+		Name:    name,
+		Payload: fill(t.Body),
+		Format:  t.Format,
+	}, user)
+}
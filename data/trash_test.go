@@ -0,0 +1,128 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashNameReuse(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s, "admin", RoleAdmin)
+
+	trashed := newTestVault(t, s, "Servers", user)
+	if err := s.CreatePassword(Password{Name: "root", Password: "old"}, trashed, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteVault(trashed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetVault(trashed, user); !IsErrNotFound(err) {
+		t.Errorf("getting a vault in trash: got error %v, want not found", err)
+	}
+	trash, err := s.GetTrash(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].Type != ObjectVault || trash[0].ID != trashed {
+		t.Errorf("got trash %+v, want the vault", trash)
+	}
+
+	// The name is free while the vault is in trash, and restoring it conflicts until it's free again
+	replacement := newTestVault(t, s, "Servers", user)
+	if err = s.RestoreVault(trashed); !IsErrConflict(err) {
+		t.Errorf("restoring a vault whose name is taken: got error %v, want a conflict", err)
+	}
+	if err = s.DeleteVault(replacement); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RestoreVault(trashed); err != nil {
+		t.Fatal(err)
+	}
+	if got := vaultPasswords(t, s, trashed, user)["root"]; got != "old" {
+		t.Errorf("restored vault has password %q, want %q", got, "old")
+	}
+
+	// Passwords work the same within their vault
+	vault, err := s.GetVault(trashed, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := vault.Passwords[0].ID
+	if err = s.DeletePassword(old, trashed); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CreatePassword(Password{Name: "root", Password: "new"}, trashed, user); err != nil {
+		t.Errorf("creating a password named like one in trash: %v", err)
+	}
+	if err = s.RestorePassword(old, trashed); !IsErrConflict(err) {
+		t.Errorf("restoring a password whose name is taken: got error %v, want a conflict", err)
+	}
+
+	// And devices with their IPs
+	if err = s.CreateDevice(Device{IP: "10.0.0.1", Name: "gateway"}); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := s.GetDevices(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteDevice(devices[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CreateDevice(Device{IP: "10.0.0.1", Name: "new gateway"}); err != nil {
+		t.Errorf("creating a device with the IP of one in trash: %v", err)
+	}
+	if err = s.RestoreDevice(devices[0].ID); !IsErrConflict(err) {
+		t.Errorf("restoring a device whose IP is taken: got error %v, want a conflict", err)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s, "admin", RoleAdmin)
+
+	purged := newTestVault(t, s, "Old", user)
+	kept := newTestVault(t, s, "Recent", user)
+	if err := s.SetTags(ObjectRef{Type: ObjectVault, ID: purged}, []string{"prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddFavorite(ObjectRef{Type: ObjectVault, ID: purged}, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteVault(purged); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(time.Second)
+	if err := s.DeleteVault(kept); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted after the cutoff, as if more recently than the retention
+	_, err := s.db.pool.Exec("UPDATE vaults SET deleted_at=? WHERE id=?", cutoff.Add(time.Minute).UTC(), kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.db.purgeTrash(cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d items, want 1", n)
+	}
+	if exists, err := s.VaultExists(purged); err != nil || exists {
+		t.Errorf("purged vault exists: %t, %v", exists, err)
+	}
+	if err = s.RestoreVault(kept); err != nil {
+		t.Errorf("restoring a vault in trash for less than the retention: %v", err)
+	}
+
+	// Tags and favorites of purged objects are deleted too, so they don't end up on objects reusing the id
+	var refs int
+	err = s.db.pool.Get(&refs, "SELECT (SELECT COUNT(*) FROM object_tags) + (SELECT COUNT(*) FROM favorites)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs != 0 {
+		t.Errorf("%d tags and favorites of purged objects left", refs)
+	}
+}
@@ -0,0 +1,178 @@
+package dav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+var errNotDir = errors.New("not a directory")
+
+// fileInfo describes a node. It implements webdav.ContentTyper so documents don't get decrypted just to sniff
+// their type.
+type fileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	dir         bool
+	contentType string
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi fileInfo) ContentType(context.Context) (string, error) {
+	if fi.contentType == "" {
+		return "application/octet-stream", nil
+	}
+	return fi.contentType, nil
+}
+
+// dirFile is an open folder or attachment directory.
+type dirFile struct {
+	info     fileInfo
+	children []fileInfo
+	pos      int
+}
+
+func (d *dirFile) Readdir(count int) ([]fs.FileInfo, error) {
+	rest := d.children[d.pos:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		rest = rest[:min(count, len(rest))]
+	}
+	d.pos += len(rest)
+
+	infos := make([]fs.FileInfo, len(rest))
+	for i, child := range rest {
+		infos[i] = child
+	}
+	return infos, nil
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error)      { return d.info, nil }
+func (d *dirFile) Close() error                    { return nil }
+func (d *dirFile) Read([]byte) (int, error)        { return 0, errNotDir }
+func (d *dirFile) Seek(int64, int) (int64, error)  { return 0, errNotDir }
+func (d *dirFile) Write([]byte) (n int, err error) { return 0, os.ErrPermission }
+
+// readFile is a decrypted document or attachment opened for reading.
+type readFile struct {
+	io.ReadSeekCloser
+	info fileInfo
+}
+
+func (f readFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotDir }
+func (f readFile) Stat() (fs.FileInfo, error)         { return f.info, nil }
+func (f readFile) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+
+// nopCloser turns a bytes.Reader into an io.ReadSeekCloser.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// writeFile collects written content, handing it to commit on Close. Nothing is committed if the file was
+// only opened, e.g. for setting properties, unless it was also truncated, or if writing it failed.
+type writeFile struct {
+	info     fileInfo
+	w        io.Writer
+	written  bool
+	truncate bool
+	// err is the first error writing failed with
+	err error
+	// body is the request body being written, if known
+	body *requestBody
+	// commit is called on Close with whether there's content to store
+	commit func(changed bool) error
+	// abort is called on Close instead of commit if writing failed
+	abort func(err error)
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.written = true
+	n, err := f.w.Write(p)
+	f.info.size += int64(n)
+	f.err = err
+	return n, err
+}
+
+func (f *writeFile) Close() error {
+	err := f.err
+	if err == nil && f.body != nil {
+		err = f.body.err
+	}
+	if err != nil {
+		if f.abort != nil {
+			f.abort(err)
+		}
+		return err
+	}
+	return f.commit(f.written || f.truncate)
+}
+
+func (f *writeFile) Stat() (fs.FileInfo, error)         { return f.info, nil }
+func (f *writeFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotDir }
+func (f *writeFile) Read([]byte) (int, error)           { return 0, os.ErrPermission }
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	// Handlers seek to the end to find the size of what was written
+	if offset == 0 && whence == io.SeekEnd {
+		return f.info.size, nil
+	}
+	if offset == 0 && whence == io.SeekStart && !f.written {
+		return 0, nil
+	}
+	return 0, os.ErrPermission
+}
+
+// limitWriter fails writes that would take the total beyond n bytes.
+type limitWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, l.err
+	}
+	n, err := l.w.Write(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// requestBody records the error reading a request body failed with. The webdav package copies the body into
+// files without telling them when reading it fails, so they'd store whatever arrived before.
+type requestBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
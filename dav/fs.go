@@ -0,0 +1,544 @@
+// Package dav exposes folders, documents and their attachments as a WebDAV tree.
+//
+// Folders map to directories. A document is a file named after it with an extension for its format, e.g.
+// "Runbook.md", next to a "Runbook_files" directory holding its attachments. Documents sharing a name with
+// something else in their folder get their id appended, e.g. "Runbook (12).md".
+package dav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/TaeKwonZeus/pva/crypt"
+	"github.com/TaeKwonZeus/pva/data"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attachmentsSuffix is appended to a document's name to get the directory of its attachments.
+const attachmentsSuffix = "_files"
+
+var extensions = map[data.DocumentFormat]string{
+	data.FormatMarkdown: ".md",
+	data.FormatPlain:    ".txt",
+	data.FormatHTML:     ".html",
+}
+
+var contentTypes = map[data.DocumentFormat]string{
+	data.FormatMarkdown: "text/markdown; charset=utf-8",
+	data.FormatPlain:    "text/plain; charset=utf-8",
+	data.FormatHTML:     "text/html; charset=utf-8",
+}
+
+// splitExt returns the name without its extension and the format the extension stands for.
+func splitExt(name string) (base string, format data.DocumentFormat, ok bool) {
+	ext := path.Ext(name)
+	for f, e := range extensions {
+		if strings.EqualFold(ext, e) && len(name) > len(ext) {
+			return strings.TrimSuffix(name, ext), f, true
+		}
+	}
+	return "", "", false
+}
+
+// sanitize makes a name usable as a path element.
+func sanitize(name string) string {
+	return strings.ReplaceAll(name, "/", "_")
+}
+
+type nodeKind int
+
+const (
+	kindFolder nodeKind = iota
+	kindDocument
+	kindAttachments
+	kindAttachment
+)
+
+// node is something in the tree. The root is a folder with a nil id.
+type node struct {
+	kind nodeKind
+	name string
+	// folderId is the folder itself for folders, the containing folder otherwise
+	folderId   *int
+	doc        data.DocumentInfo
+	attachment data.Attachment
+}
+
+func (n node) info() fileInfo {
+	switch n.kind {
+	case kindDocument:
+		fi := fileInfo{name: n.name, size: n.doc.Size, contentType: contentTypes[n.doc.Format]}
+		if n.doc.UpdatedAt != nil {
+			fi.modTime = *n.doc.UpdatedAt
+		}
+		return fi
+	case kindAttachment:
+		return fileInfo{
+			name:        n.name,
+			size:        n.attachment.Size,
+			modTime:     n.attachment.CreatedAt,
+			contentType: n.attachment.ContentType,
+		}
+	default:
+		return fileInfo{name: n.name, dir: true}
+	}
+}
+
+// FileSystem is the tree as seen by a single user. Everything is decrypted with their keys, so a new one is
+// needed for each request.
+type FileSystem struct {
+	store *data.Store
+	user  data.User
+	// opts limits attachment uploads, documents are held to the same size
+	opts data.UploadOptions
+	// body is the body of the request the file system serves, see TrackBody
+	body *requestBody
+}
+
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+func NewFileSystem(store *data.Store, user data.User, opts data.UploadOptions) *FileSystem {
+	return &FileSystem{store: store, user: user, opts: opts}
+}
+
+// TrackBody watches reading the body of r, which the file system serves, so files written from it are left
+// unchanged if the client doesn't send all of it.
+func (fs *FileSystem) TrackBody(r *http.Request) {
+	fs.body = &requestBody{ReadCloser: r.Body}
+	r.Body = fs.body
+}
+
+// mapError translates store errors into the ones the webdav package understands.
+func mapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case data.IsErrNotFound(err), errors.Is(err, data.ErrFolderNotFound):
+		return os.ErrNotExist
+	case data.IsErrConflict(err):
+		return os.ErrExist
+	case errors.Is(err, data.ErrFolderNotEmpty), errors.Is(err, data.ErrFolderCycle):
+		return os.ErrPermission
+	}
+	return err
+}
+
+func (fs *FileSystem) can(permission data.Permission) bool {
+	return data.CheckPermission(fs.user.Role, permission)
+}
+
+func (fs *FileSystem) canManageDocument(id int) bool {
+	return fs.can(data.PermissionManageDocuments) && fs.store.CheckDocumentOwnership(id, fs.user)
+}
+
+// children lists the nodes in a folder or attachment directory.
+func (fs *FileSystem) children(dir node) ([]node, error) {
+	if dir.kind == kindAttachments {
+		attachments, err := fs.store.GetAttachments(dir.doc.ID)
+		if err != nil {
+			return nil, err
+		}
+		nodes := make([]node, len(attachments))
+		for i, a := range attachments {
+			nodes[i] = node{kind: kindAttachment, name: sanitize(a.Name), doc: dir.doc, attachment: a}
+		}
+		return nodes, nil
+	}
+
+	listing, err := fs.store.GetFolder(dir.folderId, fs.user)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []node
+	taken := make(map[string]bool)
+	for _, f := range listing.Folders {
+		id := f.ID
+		nodes = append(nodes, node{kind: kindFolder, name: sanitize(f.Name), folderId: &id})
+		taken[sanitize(f.Name)] = true
+	}
+	if !fs.can(data.PermissionViewDocuments) {
+		return nodes, nil
+	}
+
+	docs, err := fs.store.GetDocumentInfos(dir.folderId, fs.user)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		base, ext := sanitize(doc.Name), extensions[doc.Format]
+		if taken[base+ext] || taken[base+attachmentsSuffix] {
+			base += " (" + strconv.Itoa(doc.ID) + ")"
+		}
+		taken[base+ext], taken[base+attachmentsSuffix] = true, true
+		nodes = append(nodes,
+			node{kind: kindDocument, name: base + ext, folderId: dir.folderId, doc: doc},
+			node{kind: kindAttachments, name: base + attachmentsSuffix, folderId: dir.folderId, doc: doc})
+	}
+	return nodes, nil
+}
+
+func (fs *FileSystem) child(dir node, name string) (node, error) {
+	if dir.kind != kindFolder && dir.kind != kindAttachments {
+		return node{}, os.ErrNotExist
+	}
+	nodes, err := fs.children(dir)
+	if err != nil {
+		return node{}, err
+	}
+	for _, n := range nodes {
+		if n.name == name {
+			return n, nil
+		}
+	}
+	return node{}, os.ErrNotExist
+}
+
+// split cleans a path into its parent and the last element, which is empty for the root.
+func split(name string) (dir string, base string) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "/", ""
+	}
+	return path.Split(name)
+}
+
+func (fs *FileSystem) resolve(name string) (node, error) {
+	n := node{kind: kindFolder, name: "/"}
+	for _, elem := range strings.Split(path.Clean("/"+name), "/") {
+		if elem == "" {
+			continue
+		}
+		var err error
+		if n, err = fs.child(n, elem); err != nil {
+			return node{}, mapError(err)
+		}
+	}
+	return n, nil
+}
+
+func (fs *FileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	n, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+func (fs *FileSystem) OpenFile(_ context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	n, err := fs.resolve(name)
+	if errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0 {
+		return fs.create(name, flag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+
+	switch n.kind {
+	case kindDocument:
+		if write {
+			if !fs.canManageDocument(n.doc.ID) {
+				return nil, os.ErrPermission
+			}
+			return fs.writeDocument(n, flag), nil
+		}
+		doc, err := fs.store.GetDocument(n.doc.ID, fs.user)
+		if err != nil {
+			return nil, mapError(err)
+		}
+		return readFile{nopCloser{bytes.NewReader([]byte(doc.Payload))}, n.info()}, nil
+	case kindAttachment:
+		if write {
+			if !fs.canManageDocument(n.doc.ID) {
+				return nil, os.ErrPermission
+			}
+			return fs.writeAttachment(n, flag), nil
+		}
+		_, content, err := fs.store.GetAttachment(n.attachment.ID, n.doc.ID, fs.user)
+		if err != nil {
+			return nil, mapError(err)
+		}
+		return readFile{content, n.info()}, nil
+	default:
+		children, err := fs.children(n)
+		if err != nil {
+			return nil, mapError(err)
+		}
+		infos := make([]fileInfo, len(children))
+		for i, child := range children {
+			infos[i] = child.info()
+		}
+		return &dirFile{info: n.info(), children: infos}, nil
+	}
+}
+
+// create opens a new document or attachment for writing. It's only stored once the file is closed.
+func (fs *FileSystem) create(name string, flag int) (webdav.File, error) {
+	dir, base := split(name)
+	parent, err := fs.resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parent.kind {
+	case kindFolder:
+		docName, format, ok := splitExt(base)
+		if !ok || !fs.can(data.PermissionManageDocuments) {
+			return nil, os.ErrPermission
+		}
+		n := node{
+			kind:     kindDocument,
+			name:     base,
+			folderId: parent.folderId,
+			doc:      data.DocumentInfo{Name: docName, Format: format, FolderID: parent.folderId},
+		}
+		return fs.writeDocument(n, flag|os.O_TRUNC), nil
+	case kindAttachments:
+		if !fs.canManageDocument(parent.doc.ID) {
+			return nil, os.ErrPermission
+		}
+		n := node{kind: kindAttachment, name: base, doc: parent.doc, attachment: data.Attachment{Name: base}}
+		return fs.writeAttachment(n, flag|os.O_TRUNC), nil
+	default:
+		return nil, os.ErrPermission
+	}
+}
+
+// writeDocument replaces the payload of a document with what's written, creating it if it has no id yet.
+func (fs *FileSystem) writeDocument(n node, flag int) webdav.File {
+	var buf bytes.Buffer
+	info := n.info()
+	info.size, info.modTime = 0, time.Now()
+	return &writeFile{
+		info:     info,
+		w:        &limitWriter{w: &buf, n: fs.opts.MaxSize, err: data.ErrTooLarge},
+		truncate: flag&os.O_TRUNC != 0,
+		body:     fs.body,
+		commit: func(changed bool) error {
+			if !changed {
+				return nil
+			}
+			if n.doc.ID == 0 {
+				_, err := fs.store.CreateDocument(data.Document{
+					Name:     n.doc.Name,
+					Payload:  buf.String(),
+					Format:   n.doc.Format,
+					FolderID: n.doc.FolderID,
+				}, fs.user)
+				return mapError(err)
+			}
+			// Unlike with the API, an empty write is applied, since truncating the file is all it can mean
+			return mapError(fs.store.SetDocumentPayload(n.doc.ID, buf.String(), fs.user))
+		},
+	}
+}
+
+// upload streams writes into a new attachment, starting on the first write.
+type upload struct {
+	pw   *io.PipeWriter
+	done chan error
+	// store reads the attachment content until EOF
+	store func(r io.Reader) error
+}
+
+func (u *upload) begin() {
+	pr, pw := io.Pipe()
+	u.pw, u.done = pw, make(chan error, 1)
+	go func() {
+		err := u.store(pr)
+		// Unblocks writes if storing stopped early
+		pr.CloseWithError(cmpErr(err, io.ErrClosedPipe))
+		u.done <- err
+	}()
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	if u.pw == nil {
+		u.begin()
+	}
+	return u.pw.Write(p)
+}
+
+func (u *upload) finish() error {
+	if u.pw == nil {
+		u.begin()
+	}
+	u.pw.Close()
+	return <-u.done
+}
+
+// abort stops storing the attachment, failing its read with err so nothing is kept.
+func (u *upload) abort(err error) {
+	if u.pw == nil {
+		return
+	}
+	u.pw.CloseWithError(err)
+	<-u.done
+}
+
+func cmpErr(err, fallback error) error {
+	if err != nil {
+		return err
+	}
+	return fallback
+}
+
+// writeAttachment stores what's written as an attachment. Existing attachments are replaced only after the
+// new content is stored, so a failed upload leaves them intact.
+func (fs *FileSystem) writeAttachment(n node, flag int) webdav.File {
+	name := n.attachment.Name
+	if n.attachment.ID != 0 {
+		suffix, err := crypt.RandomName()
+		if err != nil {
+			suffix = strconv.FormatInt(time.Now().UnixNano(), 36)
+		}
+		name += ".upload-" + suffix
+	}
+
+	var created data.Attachment
+	u := &upload{store: func(r io.Reader) (err error) {
+		created, err = fs.store.CreateAttachment(n.doc.ID, name, r, fs.opts, fs.user)
+		return
+	}}
+
+	info := n.info()
+	info.size, info.modTime = 0, time.Now()
+	return &writeFile{
+		info:     info,
+		w:        u,
+		truncate: flag&os.O_TRUNC != 0,
+		body:     fs.body,
+		abort:    u.abort,
+		commit: func(changed bool) error {
+			if !changed {
+				return nil
+			}
+			if err := u.finish(); err != nil {
+				return mapError(err)
+			}
+			if n.attachment.ID == 0 {
+				return nil
+			}
+			if err := fs.store.DeleteAttachment(n.attachment.ID, n.doc.ID); err != nil && !data.IsErrNotFound(err) {
+				return err
+			}
+			return mapError(fs.store.RenameAttachment(created.ID, n.doc.ID, n.attachment.Name))
+		},
+	}
+}
+
+func (fs *FileSystem) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	dir, base := split(name)
+	if base == "" {
+		return os.ErrExist
+	}
+	parent, err := fs.resolve(dir)
+	if err != nil {
+		return err
+	}
+	if parent.kind != kindFolder || !fs.can(data.PermissionManageFolders) {
+		return os.ErrPermission
+	}
+	if _, err = fs.child(parent, base); err == nil {
+		return os.ErrExist
+	}
+
+	_, err = fs.store.CreateFolder(data.Folder{Name: base, ParentID: parent.folderId})
+	return mapError(err)
+}
+
+// RemoveAll deletes an empty folder, moves a document to trash or deletes an attachment.
+func (fs *FileSystem) RemoveAll(_ context.Context, name string) error {
+	n, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	switch n.kind {
+	case kindFolder:
+		if n.folderId == nil || !fs.can(data.PermissionManageFolders) {
+			return os.ErrPermission
+		}
+		return mapError(fs.store.DeleteFolder(*n.folderId))
+	case kindDocument:
+		if !fs.canManageDocument(n.doc.ID) {
+			return os.ErrPermission
+		}
+		return mapError(fs.store.DeleteDocument(n.doc.ID))
+	case kindAttachment:
+		if !fs.canManageDocument(n.doc.ID) {
+			return os.ErrPermission
+		}
+		return mapError(fs.store.DeleteAttachment(n.attachment.ID, n.doc.ID))
+	default:
+		return os.ErrPermission
+	}
+}
+
+// Rename renames and moves folders and documents, and renames attachments within their document.
+func (fs *FileSystem) Rename(_ context.Context, oldName, newName string) error {
+	n, err := fs.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	dir, base := split(newName)
+	parent, err := fs.resolve(dir)
+	if err != nil {
+		return err
+	}
+	if _, err = fs.child(parent, base); err == nil {
+		return os.ErrExist
+	}
+
+	switch n.kind {
+	case kindFolder:
+		if n.folderId == nil || parent.kind != kindFolder || !fs.can(data.PermissionManageFolders) {
+			return os.ErrPermission
+		}
+		if !sameFolder(n.folderId, parent.folderId) {
+			if err = fs.store.MoveFolder(*n.folderId, parent.folderId); err != nil {
+				return mapError(err)
+			}
+		}
+		if base != n.name {
+			return mapError(fs.store.RenameFolder(*n.folderId, base))
+		}
+		return nil
+	case kindDocument:
+		docName, format, ok := splitExt(base)
+		if !ok || parent.kind != kindFolder || !fs.canManageDocument(n.doc.ID) {
+			return os.ErrPermission
+		}
+		if !sameFolder(n.folderId, parent.folderId) {
+			if err = fs.store.MoveDocument(n.doc.ID, parent.folderId, fs.user); err != nil {
+				return mapError(err)
+			}
+		}
+		if base != n.name {
+			return mapError(fs.store.UpdateDocument(data.Document{ID: n.doc.ID, Name: docName, Format: format}, fs.user))
+		}
+		return nil
+	case kindAttachment:
+		// Attachments are encrypted with their document's key, so they can't move to another one
+		if parent.kind != kindAttachments || parent.doc.ID != n.doc.ID || !fs.canManageDocument(n.doc.ID) {
+			return os.ErrPermission
+		}
+		return mapError(fs.store.RenameAttachment(n.attachment.ID, n.doc.ID, base))
+	default:
+		return os.ErrPermission
+	}
+}
+
+func sameFolder(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
@@ -0,0 +1,130 @@
+// Package export bundles rendered documents into a single file for handing them to someone outside pva.
+package export
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Bundle is a set of documents exported together.
+type Bundle struct {
+	Title string
+	// Author is the user requesting the export
+	Author      string
+	GeneratedAt time.Time
+	Documents   []Document
+}
+
+type Document struct {
+	Name string
+	// Path holds the names of the folders containing the document, starting from the top
+	Path   []string
+	Format string
+	Tags   []string
+	// UpdatedAt and UpdatedBy describe the latest revision, UpdatedBy is empty if the author is unknown
+	UpdatedAt time.Time
+	UpdatedBy string
+	// HTML is the document rendered and sanitized by the render package
+	HTML        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+// inlineImageTypes are the image types shown inline in HTML exports. SVG is left out since it can carry scripts.
+var inlineImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+func (a Attachment) isImage() bool {
+	for _, t := range inlineImageTypes {
+		if a.ContentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// dataURL encodes the attachment so it can be opened without the server. Anything but inline images is
+// marked as a binary download so browsers don't render it in the export's origin.
+func (a Attachment) dataURL() template.URL {
+	contentType := "application/octet-stream"
+	if a.isImage() {
+		contentType = a.ContentType
+	}
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(a.Content))
+}
+
+var htmlTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"join":    strings.Join,
+	"html":    func(s string) template.HTML { return template.HTML(s) },
+	"date":    func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+	"dataURL": Attachment.dataURL,
+	"isImage": Attachment.isImage,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="Content-Security-Policy" content="default-src 'none'; img-src data:; style-src 'unsafe-inline'">
+<meta name="author" content="{{.Author}}">
+<meta name="generator" content="pva">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 52rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+.meta { color: #666; font-size: .9em; }
+section.document { border-top: 1px solid #ccc; margin-top: 3rem; page-break-before: always; }
+pre { background: #f5f5f5; padding: .5rem; overflow-x: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: .25rem .5rem; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<p class="meta">Exported by {{.Author}} on {{date .GeneratedAt}}, {{len .Documents}} document(s)</p>
+</header>
+<nav>
+<h2>Contents</h2>
+<ol>
+{{- range $i, $doc := .Documents}}
+<li><a href="#document-{{$i}}">{{$doc.Name}}</a></li>
+{{- end}}
+</ol>
+</nav>
+{{- range $i, $doc := .Documents}}
+<section class="document" id="document-{{$i}}">
+<h1>{{$doc.Name}}</h1>
+<p class="meta">
+{{- if $doc.Path}}Folder: {{join $doc.Path " / "}}<br>{{end}}
+Format: {{$doc.Format}}<br>
+{{- if $doc.Tags}}Tags: {{join $doc.Tags ", "}}<br>{{end}}
+{{- if not $doc.UpdatedAt.IsZero}}Last changed: {{date $doc.UpdatedAt}}{{if $doc.UpdatedBy}} by {{$doc.UpdatedBy}}{{end}}{{end}}
+</p>
+<div class="content">
+{{html $doc.HTML}}
+</div>
+{{- if $doc.Attachments}}
+<h2>Attachments</h2>
+<ul>
+{{- range $doc.Attachments}}
+<li><a download="{{.Name}}" href="{{dataURL .}}">{{.Name}}</a> ({{len .Content}} bytes)
+{{- if isImage .}}<br><img src="{{dataURL .}}" alt="{{.Name}}">{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML writes the bundle as a single HTML file with attachments embedded as data URLs.
+func HTML(w io.Writer, b Bundle) error {
+	return htmlTemplate.Execute(w, b)
+}
@@ -0,0 +1,355 @@
+package export
+
+import (
+	"bytes"
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/net/html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	pageMargin = 20.0
+	lineHeight = 5.0
+	// tocEntriesPerPage is how many documents are listed on each page reserved for the table of contents
+	tocEntriesPerPage = 35
+	tocTitleWidth     = 145.0
+)
+
+// pdfImageTypes maps the content types of images embedded into PDF exports to the names fpdf knows them by.
+var pdfImageTypes = map[string]string{
+	"image/png":  "PNG",
+	"image/jpeg": "JPG",
+	"image/gif":  "GIF",
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// utf16Text encodes s the way PDF text strings outside of page content support Unicode.
+func utf16Text(s string) string {
+	b := []byte{0xfe, 0xff}
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return string(b)
+}
+
+// PDF writes the bundle as a PDF with a table of contents and outline. Attachments are embedded as files,
+// images are also shown below their document. The standard PDF fonts only cover Windows-1252, so other
+// characters are replaced.
+func PDF(w io.Writer, b Bundle) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin)
+	pdf.SetTitle(b.Title, true)
+	pdf.SetAuthor(b.Author, true)
+	pdf.SetCreator("pva", true)
+	pdf.SetCreationDate(b.GeneratedAt)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 22)
+	pdf.MultiCell(0, 10, tr(b.Title), "", "L", false)
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.MultiCell(0, 6, tr("Exported by "+b.Author+" on "+b.GeneratedAt.UTC().Format("2006-01-02 15:04 MST")),
+		"", "L", false)
+	pdf.MultiCell(0, 6, strconv.Itoa(len(b.Documents))+" document(s)", "", "L", false)
+
+	// Pages for the table of contents are reserved up front and filled in once page numbers are known
+	tocStart := pdf.PageNo() + 1
+	tocPages := (len(b.Documents) + tocEntriesPerPage - 1) / tocEntriesPerPage
+	for range tocPages {
+		pdf.AddPage()
+	}
+
+	links := make([]int, len(b.Documents))
+	pages := make([]int, len(b.Documents))
+	var attachments []fpdf.Attachment
+	for i, doc := range b.Documents {
+		pdf.AddPage()
+		links[i], pages[i] = pdf.AddLink(), pdf.PageNo()
+		pdf.SetLink(links[i], 0, -1)
+		pdf.Bookmark(utf16Text(doc.Name), 0, 0)
+		writeDocument(pdf, tr, doc)
+
+		for _, a := range doc.Attachments {
+			attachments = append(attachments, fpdf.Attachment{
+				Content:     a.Content,
+				Filename:    a.Name,
+				Description: doc.Name,
+			})
+		}
+	}
+	pdf.SetAttachments(attachments)
+
+	for i, doc := range b.Documents {
+		page := tocStart + i/tocEntriesPerPage
+		pdf.SetPage(page)
+		if i%tocEntriesPerPage == 0 {
+			pdf.SetXY(pageMargin, pageMargin)
+			pdf.SetFont("Helvetica", "B", 16)
+			pdf.CellFormat(0, 10, "Contents", "", 1, "L", false, 0, "")
+			pdf.Ln(2)
+			pdf.SetFont("Helvetica", "", 11)
+		}
+		title := tr(strconv.Itoa(i+1) + ". " + doc.Name)
+		for pdf.GetStringWidth(title) > tocTitleWidth {
+			title = strings.TrimSuffix(title[:len(title)-4], " ") + "..."
+		}
+		pdf.CellFormat(tocTitleWidth+5, 6.5, title, "", 0, "L", false, links[i], "")
+		pdf.CellFormat(0, 6.5, strconv.Itoa(pages[i]), "", 1, "R", false, links[i], "")
+	}
+
+	// Page numbers are drawn last instead of in a footer func since going back to the table of contents would
+	// draw them twice
+	total := strconv.Itoa(pdf.PageCount())
+	pdf.SetAutoPageBreak(false, 0)
+	for page := 1; page <= pdf.PageCount(); page++ {
+		pdf.SetPage(page)
+		_, height := pdf.GetPageSize()
+		pdf.SetXY(pageMargin, height-pageMargin/2-lineHeight)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(0, lineHeight, strconv.Itoa(page)+" / "+total, "", 0, "C", false, 0, "")
+	}
+
+	return pdf.Output(w)
+}
+
+func writeDocument(pdf *fpdf.Fpdf, tr func(string) string, doc Document) {
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.MultiCell(0, 8, tr(doc.Name), "", "L", false)
+
+	meta := []string{"Format: " + doc.Format}
+	if len(doc.Path) > 0 {
+		meta = append([]string{"Folder: " + strings.Join(doc.Path, " / ")}, meta...)
+	}
+	if len(doc.Tags) > 0 {
+		meta = append(meta, "Tags: "+strings.Join(doc.Tags, ", "))
+	}
+	if !doc.UpdatedAt.IsZero() {
+		changed := "Last changed: " + doc.UpdatedAt.UTC().Format("2006-01-02 15:04 MST")
+		if doc.UpdatedBy != "" {
+			changed += " by " + doc.UpdatedBy
+		}
+		meta = append(meta, changed)
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.MultiCell(0, 4.5, tr(strings.Join(meta, "\n")), "", "L", false)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(4)
+
+	newHTMLWriter(pdf, tr).write(doc.HTML)
+
+	if len(doc.Attachments) == 0 {
+		return
+	}
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 13)
+	pdf.MultiCell(0, 7, "Attachments", "", "L", false)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, a := range doc.Attachments {
+		pdf.MultiCell(0, lineHeight, tr("- "+a.Name+" ("+strconv.Itoa(len(a.Content))+" bytes, embedded)"),
+			"", "L", false)
+		writeImage(pdf, a)
+	}
+}
+
+// writeImage shows an image attachment scaled to the page width. Other attachments and images fpdf can't
+// decode are skipped.
+func writeImage(pdf *fpdf.Fpdf, a Attachment) {
+	imageType, ok := pdfImageTypes[a.ContentType]
+	if !ok {
+		return
+	}
+	name := "attachment-" + strconv.Itoa(pdf.PageNo()) + "-" + a.Name
+	options := fpdf.ImageOptions{ImageType: imageType, ReadDpi: true}
+	info := pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(a.Content))
+	if pdf.Err() {
+		pdf.ClearError()
+		return
+	}
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	width, height := info.Extent()
+	maxWidth, maxHeight := pageWidth-2*pageMargin, (pageHeight-2*pageMargin)/2
+	if width > maxWidth {
+		width, height = maxWidth, height*maxWidth/width
+	}
+	if height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	pdf.ImageOptions(name, pageMargin, -1, width, height, true, options, 0, "")
+	pdf.Ln(2)
+}
+
+// htmlWriter lays out sanitized HTML as PDF text. Block elements become paragraphs, inline formatting is
+// dropped.
+type htmlWriter struct {
+	pdf  *fpdf.Fpdf
+	tr   func(string) string
+	text strings.Builder
+
+	heading int
+	pre     int
+	quote   int
+	// lists holds the next item number of each open list, or -1 for unordered ones
+	lists []int
+	// cells counts the cells of the current table row
+	cells int
+}
+
+func newHTMLWriter(pdf *fpdf.Fpdf, tr func(string) string) *htmlWriter {
+	return &htmlWriter{pdf: pdf, tr: tr}
+}
+
+func (h *htmlWriter) write(source string) {
+	z := html.NewTokenizer(strings.NewReader(source))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			h.flush()
+			return
+		case html.TextToken:
+			text := string(z.Text())
+			if h.pre == 0 {
+				text = whitespace.ReplaceAllString(text, " ")
+				if current := h.text.String(); current == "" || strings.HasSuffix(current, " ") {
+					text = strings.TrimPrefix(text, " ")
+				}
+			}
+			h.text.WriteString(text)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			h.start(z.Token())
+		case html.EndTagToken:
+			h.end(z.Token().Data)
+		}
+	}
+}
+
+func (h *htmlWriter) start(t html.Token) {
+	switch t.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		h.flush()
+		h.heading = int(t.Data[1] - '0')
+	case "p", "div", "table":
+		h.flush()
+	case "pre":
+		h.flush()
+		h.pre++
+	case "blockquote":
+		h.flush()
+		h.quote++
+	case "ul":
+		h.flush()
+		h.lists = append(h.lists, -1)
+	case "ol":
+		h.flush()
+		h.lists = append(h.lists, 1)
+	case "li":
+		h.flush()
+		if n := len(h.lists); n > 0 && h.lists[n-1] > 0 {
+			h.text.WriteString(strconv.Itoa(h.lists[n-1]) + ". ")
+			h.lists[n-1]++
+		} else {
+			h.text.WriteString("• ")
+		}
+	case "tr":
+		h.flush()
+		h.cells = 0
+	case "td", "th":
+		if h.cells > 0 {
+			h.text.WriteString("| ")
+		}
+		h.cells++
+	case "br":
+		h.text.WriteString("\n")
+	case "hr":
+		h.flush()
+		y := h.pdf.GetY() + 2
+		width, _ := h.pdf.GetPageSize()
+		h.pdf.SetDrawColor(180, 180, 180)
+		h.pdf.Line(pageMargin, y, width-pageMargin, y)
+		h.pdf.Ln(4)
+	case "input":
+		checked := false
+		for _, attr := range t.Attr {
+			checked = checked || attr.Key == "checked"
+		}
+		if checked {
+			h.text.WriteString("[x] ")
+		} else {
+			h.text.WriteString("[ ] ")
+		}
+	case "img":
+		for _, attr := range t.Attr {
+			if attr.Key == "alt" && attr.Val != "" {
+				h.text.WriteString("[" + attr.Val + "]")
+			}
+		}
+	}
+}
+
+func (h *htmlWriter) end(tag string) {
+	switch tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		h.flush()
+		h.heading = 0
+	case "p", "div", "li", "tr", "table":
+		h.flush()
+	case "pre":
+		h.flush()
+		h.pre = max(h.pre-1, 0)
+	case "blockquote":
+		h.flush()
+		h.quote = max(h.quote-1, 0)
+	case "ul", "ol":
+		h.flush()
+		if len(h.lists) > 0 {
+			h.lists = h.lists[:len(h.lists)-1]
+		}
+	}
+}
+
+// flush writes the collected text as a paragraph styled by the enclosing elements.
+func (h *htmlWriter) flush() {
+	text := h.text.String()
+	h.text.Reset()
+	if h.pre > 0 {
+		text = strings.Trim(text, "\n")
+	} else {
+		text = strings.TrimSpace(text)
+	}
+	if text == "" {
+		return
+	}
+
+	height := lineHeight
+	switch {
+	case h.heading > 0:
+		size := []float64{16, 14, 12, 11, 11, 11}[h.heading-1]
+		h.pdf.SetFont("Helvetica", "B", size)
+		height = size / 2
+		h.pdf.Ln(2)
+	case h.pre > 0:
+		h.pdf.SetFont("Courier", "", 9)
+		height = 4
+	case h.quote > 0:
+		h.pdf.SetFont("Helvetica", "I", 10)
+	default:
+		h.pdf.SetFont("Helvetica", "", 10)
+	}
+
+	indent := 6 * float64(h.quote+max(len(h.lists)-1, 0))
+	h.pdf.SetLeftMargin(pageMargin + indent)
+	h.pdf.SetX(pageMargin + indent)
+	h.pdf.MultiCell(0, height, h.tr(text), "", "L", false)
+	h.pdf.SetLeftMargin(pageMargin)
+	h.pdf.Ln(1.5)
+}
@@ -21,59 +21,82 @@ import {
 } from "@radix-ui/react-icons";
 import { Outlet, useNavigate } from "react-router-dom";
 import { logOut } from "./auth.js";
-import { useState } from "react";
+import { Fragment, useEffect, useState } from "react";
+
+// searchDelay is how long typing has to pause in milliseconds before searching
+const searchDelay = 250;
 
 const searchEntryIcons = {
   vault: <ArchiveIcon />,
   password: <LockClosedIcon />,
+  device: <DesktopIcon />,
+  document: <FileIcon />,
 };
 
-function SearchEntries({ index, text }) {
-  if (text === "") return [];
-
-  const filtered = index.filter((item) =>
-    item.title.toLowerCase().trim().includes(text.toLowerCase().trim()),
-  );
-  if (filtered.length === 0) {
+function SearchEntries({ results }) {
+  if (results.length === 0) {
     return <Text size="2">{"Didn't find anything :P"}</Text>;
   }
 
-  return filtered.map((entry, i) => (
-    <>
+  return results.map((entry, i) => (
+    <Fragment key={entry.url}>
       <Link href={entry.url}>
-        <Flex gap="2" key={i} align="center">
+        <Flex gap="2" align="center">
           <Box>{searchEntryIcons[entry["type"]]}</Box>
-          <Text size="2">{entry.title}</Text>
+          <Flex direction="column">
+            <Text size="2">{entry.title}</Text>
+            {entry.snippet && (
+              <Text size="1" color="gray">
+                {entry.snippet}
+              </Text>
+            )}
+          </Flex>
         </Flex>
       </Link>
 
-      {i !== filtered.length - 1 && <Separator size="4" />}
-    </>
+      {i !== results.length - 1 && <Separator size="4" />}
+    </Fragment>
   ));
 }
 
 function SearchBar() {
-  const [index, setIndex] = useState([]);
-  const [loaded, setLoaded] = useState(false);
-
-  async function loadIndex() {
-    if (loaded) return;
+  const [results, setResults] = useState([]);
+  const [focused, setFocused] = useState(false);
+  const [text, setText] = useState("");
 
-    const res = await fetch("/api/index");
-    if (!res.ok) {
-      alert(res.statusText + " " + (await res.text()));
+  // Searching waits for typing to pause, and a newer query cancels the request of an older one so its
+  // response can't overwrite newer results
+  useEffect(() => {
+    if (text.trim() === "") {
+      setResults([]);
       return;
     }
 
-    setIndex(await res.json());
-    setLoaded(true);
-  }
+    const controller = new AbortController();
+    const timeout = setTimeout(async () => {
+      try {
+        const res = await fetch("/api/search?q=" + encodeURIComponent(text), {
+          signal: controller.signal,
+        });
+        if (!res.ok) {
+          alert(res.statusText + " " + (await res.text()));
+          return;
+        }
 
-  const [focused, setFocused] = useState(false);
-  const [text, setText] = useState("");
+        setResults((await res.json()).results);
+      } catch (e) {
+        if (e.name !== "AbortError") throw e;
+      }
+    }, searchDelay);
+
+    return () => {
+      clearTimeout(timeout);
+      controller.abort();
+    };
+  }, [text]);
 
   return (
-    <Flex onMouseEnter={loadIndex} direction="column">
+    <Flex direction="column">
       <TextField.Root
         radius="full"
         variant="surface"
@@ -95,7 +118,7 @@ function SearchBar() {
         </HoverCard.Trigger>
         <HoverCard.Content width="400px">
           <Flex direction="column" gap="2">
-            <SearchEntries index={index} text={text} />
+            <SearchEntries results={results} />
           </Flex>
         </HoverCard.Content>
       </HoverCard.Root>
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (e *Env) RequestVaultAccessHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid vault id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+		// Duration is the requested access duration in seconds
+		Duration int `json:"duration"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !e.checkGrantDuration(w, body.Duration) {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewPasswords)
+	if !ok {
+		return
+	}
+
+	exists, err := e.Store.VaultExists(id)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "vault not found", http.StatusNotFound)
+		return
+	}
+
+	if e.Store.CheckVaultOwnership(id, user) {
+		http.Error(w, "vault already accessible", http.StatusConflict)
+		return
+	}
+
+	err = e.Store.CreateAccessRequest(data.AccessRequest{
+		VaultID:  id,
+		UserID:   user.ID,
+		Reason:   body.Reason,
+		Duration: body.Duration,
+	})
+	if data.IsErrConflict(err) {
+		http.Error(w, "access already requested", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// checkGrantDuration reports whether a duration of time-limited vault access in seconds is positive and at most
+// the configured maximum, writing an error response otherwise.
+func (e *Env) checkGrantDuration(w http.ResponseWriter, seconds int) bool {
+	if seconds <= 0 || seconds > e.Config.Access.MaxDuration {
+		http.Error(w, "duration must be between 1 and "+strconv.Itoa(e.Config.Access.MaxDuration)+" seconds",
+			http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (e *Env) GetAccessRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionViewPasswords)
+	if !ok {
+		return
+	}
+
+	reqs, err := e.Store.GetAccessRequests(user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(reqs); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// getDecidableAccessRequest gets the access request in the URL if the user can approve or deny it,
+// writing an error response otherwise.
+func (e *Env) getDecidableAccessRequest(w http.ResponseWriter, r *http.Request) (
+	req data.AccessRequest, user data.User, ok bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid request id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok = authenticate(w, r, data.PermissionManagePasswords)
+	if !ok {
+		return
+	}
+	ok = false
+
+	req, err = e.Store.GetAccessRequest(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !e.Store.CheckVaultAdministration(req.VaultID, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if req.Status != data.AccessRequestPending {
+		http.Error(w, "request already "+string(req.Status), http.StatusConflict)
+		return
+	}
+
+	return req, user, true
+}
+
+func (e *Env) ApproveAccessRequestHandler(w http.ResponseWriter, r *http.Request) {
+	req, user, ok := e.getDecidableAccessRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Approvers can shorten or extend the requested duration
+	duration := req.Duration
+	if d := r.URL.Query().Get("duration"); d != "" {
+		seconds, err := strconv.Atoi(d)
+		if err != nil {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		duration = seconds
+	}
+	// Also checked when the requested duration is kept, as the maximum may have been lowered since
+	if !e.checkGrantDuration(w, duration) {
+		return
+	}
+
+	err := e.Store.ApproveAccessRequest(req, time.Duration(duration)*time.Second, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) DenyAccessRequestHandler(w http.ResponseWriter, r *http.Request) {
+	req, user, ok := e.getDecidableAccessRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := e.Store.DenyAccessRequest(req, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// GetAlertsHandler lists the unacknowledged alerts, or all of them with ?all=true.
+func (e *Env) GetAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionViewDevices)
+	if !ok {
+		return
+	}
+
+	alerts, err := e.Store.GetAlerts(r.URL.Query().Get("all") == "true")
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(alerts); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *Env) AcknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionManageDevices)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid alert id", http.StatusBadRequest)
+		return
+	}
+
+	err = e.Store.AcknowledgeAlert(id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "alert not found or already acknowledged", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
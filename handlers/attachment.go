@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// attachmentParams parses the document and attachment ids from the URL.
+func attachmentParams(w http.ResponseWriter, r *http.Request) (docId int, attachmentId int, ok bool) {
+	docId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+	attachmentId, err = strconv.Atoi(chi.URLParam(r, "attachmentId"))
+	if err != nil {
+		http.Error(w, "invalid attachment id", http.StatusBadRequest)
+		return
+	}
+	return docId, attachmentId, true
+}
+
+// uploadOptions converts the attachment config to bytes.
+func (e *Env) uploadOptions() data.UploadOptions {
+	return data.UploadOptions{
+		MaxSize:     int64(e.Config.Attachments.MaxSize) << 20,
+		Quota:       int64(e.Config.Attachments.Quota) << 20,
+		Deduplicate: e.Config.Attachments.Deduplicate,
+	}
+}
+
+// StorageUsageHandler returns how much of their attachment quota the user has used.
+func (e *Env) StorageUsageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	usage, err := e.Store.GetStorageUsage(user, e.uploadOptions().Quota)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(usage); err != nil {
+		log.Error(err.Error())
+		return
+	}
+}
+
+// UploadAttachmentHandler streams every "file" part of a multipart request into a new attachment.
+func (e *Env) UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := e.uploadOptions()
+	attachments := []data.Attachment{}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		// FileName strips any directories the client sent along
+		name := part.FileName()
+		if name == "" || name == "." || name == "/" {
+			http.Error(w, "file name required", http.StatusBadRequest)
+			return
+		}
+
+		attachment, err := e.Store.CreateAttachment(id, name, part, opts, user)
+		if errors.Is(err, data.ErrTooLarge) {
+			http.Error(w, name+" is larger than "+strconv.Itoa(e.Config.Attachments.MaxSize)+" MiB",
+				http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, data.ErrQuotaExceeded) {
+			http.Error(w, "storing "+name+" would exceed your quota of "+
+				strconv.Itoa(e.Config.Attachments.Quota)+" MiB", http.StatusInsufficientStorage)
+			return
+		}
+		if data.IsErrNotFound(err) {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		if data.IsErrConflict(err) {
+			http.Error(w, name+" is already attached", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if len(attachments) == 0 {
+		http.Error(w, "no files uploaded", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(attachments); err != nil {
+		log.Error(err.Error())
+		return
+	}
+}
+
+// DownloadAttachmentHandler streams the decrypted attachment, supporting range requests.
+func (e *Env) DownloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, attachmentId, ok := attachmentParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	attachment, content, err := e.Store.GetAttachment(attachmentId, id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	// Attachments are served as downloads so uploaded HTML can't run scripts on our origin
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": attachment.Name,
+	}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, attachment.Name, attachment.CreatedAt, content)
+}
+
+func (e *Env) DeleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, attachmentId, ok := attachmentParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err := e.Store.DeleteAttachment(attachmentId, id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// passwordParams parses the vault and password ids in the URL, writing an error response if they're invalid.
+func passwordParams(w http.ResponseWriter, r *http.Request) (vaultId, passwordId int, ok bool) {
+	vaultId, err := strconv.Atoi(chi.URLParam(r, "vaultId"))
+	if err != nil {
+		http.Error(w, "invalid vault id", http.StatusBadRequest)
+		return
+	}
+	passwordId, err = strconv.Atoi(chi.URLParam(r, "passwordId"))
+	if err != nil {
+		http.Error(w, "invalid password id", http.StatusBadRequest)
+		return
+	}
+	return vaultId, passwordId, true
+}
+
+func (e *Env) CheckoutPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	vaultId, passwordId, ok := passwordParams(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+		// Duration of the checkout in seconds, the configured default if zero
+		Duration int `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+	if body.Duration == 0 {
+		body.Duration = e.Config.Checkout.Duration
+	}
+	if body.Duration < 0 || body.Duration > e.Config.Checkout.MaxDuration {
+		http.Error(w, "duration must be between 1 and "+strconv.Itoa(e.Config.Checkout.MaxDuration)+" seconds",
+			http.StatusBadRequest)
+		return
+	}
+	override := r.URL.Query().Get("override") == "true"
+
+	user, ok := authenticate(w, r, data.PermissionViewPasswords)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckVaultOwnership(vaultId, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	password, err := e.Store.CheckoutPassword(passwordId, vaultId, body.Reason,
+		time.Duration(body.Duration)*time.Second, override, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "password not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrCheckedOut) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, data.ErrCheckoutNotRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(password); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+func (e *Env) CheckInPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	vaultId, passwordId, ok := passwordParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewPasswords)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckVaultOwnership(vaultId, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err := e.Store.CheckInPassword(passwordId, vaultId, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "password not checked out", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrCheckedOut) {
+		http.Error(w, "password checked out by another user", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) GetCheckoutsHandler(w http.ResponseWriter, r *http.Request) {
+	vaultId, passwordId, ok := passwordParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewPasswords)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckVaultOwnership(vaultId, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	checkouts, err := e.Store.GetCheckouts(passwordId, vaultId)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "password not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(checkouts); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
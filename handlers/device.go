@@ -4,11 +4,44 @@ import (
 	"encoding/json"
 	"github.com/TaeKwonZeus/pva/data"
 	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strconv"
 )
 
+// parseDeviceAddresses validates the addresses of the device, normalizing them so scanned devices can be
+// matched against them.
+func parseDeviceAddresses(w http.ResponseWriter, device *data.Device) bool {
+	ip, err := netip.ParseAddr(device.IP)
+	if err != nil {
+		http.Error(w, "invalid IP address", http.StatusBadRequest)
+		return false
+	}
+	device.IP = ip.Unmap().String()
+
+	for i, s := range device.IPv6 {
+		ip, err = netip.ParseAddr(s)
+		if err != nil || !ip.Is6() || ip.Is4In6() {
+			http.Error(w, "invalid IPv6 address "+s, http.StatusBadRequest)
+			return false
+		}
+		device.IPv6[i] = ip.String()
+	}
+
+	if device.MAC != "" {
+		mac, err := net.ParseMAC(device.MAC)
+		if err != nil {
+			http.Error(w, "invalid MAC address", http.StatusBadRequest)
+			return false
+		}
+		device.MAC = mac.String()
+	}
+	return true
+}
+
 func (e *Env) NewDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	_, ok := authenticate(w, r, data.PermissionManageDevices)
 	if !ok {
@@ -21,8 +54,7 @@ func (e *Env) NewDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if net.ParseIP(body.IP) == nil {
-		http.Error(w, "invalid IP address", http.StatusBadRequest)
+	if !parseDeviceAddresses(w, &body) {
 		return
 	}
 
@@ -41,18 +73,24 @@ func (e *Env) NewDeviceHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (e *Env) GetDevicesHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := authenticate(w, r, data.PermissionViewDevices)
+	user, ok := authenticate(w, r, data.PermissionViewDevices)
 	if !ok {
 		return
 	}
 
-	devices, err := e.Store.GetDevices()
+	devices, err := e.Store.GetDevices(user)
 	if err != nil {
 		log.Error(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if filter := parseListFilter(r); !filter.empty() {
+		devices = slices.DeleteFunc(devices, func(d data.Device) bool {
+			return !filter.matches(d.Tags, d.Favorite)
+		})
+	}
+
 	if err = json.NewEncoder(w).Encode(devices); err != nil {
 		log.Error(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -71,8 +109,7 @@ func (e *Env) UpdateDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if net.ParseIP(body.IP) == nil {
-		http.Error(w, "invalid IP address", http.StatusBadRequest)
+	if !parseDeviceAddresses(w, &body) {
 		return
 	}
 
@@ -118,3 +155,59 @@ func (e *Env) DeleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (e *Env) DeviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionViewDevices)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	history, err := e.Store.GetDeviceHistory(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(history); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// StaleDevicesHandler lists the saved devices scans haven't found for the number of days given by the days
+// query parameter.
+func (e *Env) StaleDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionViewDevices)
+	if !ok {
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 1 {
+		http.Error(w, "invalid number of days", http.StatusBadRequest)
+		return
+	}
+
+	devices, err := e.Store.GetStaleDevices(days, user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(devices); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
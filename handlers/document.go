@@ -2,50 +2,297 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/TaeKwonZeus/pva/data"
+	"github.com/TaeKwonZeus/pva/render"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
 	"net/http"
+	"slices"
+	"strconv"
 )
 
+func (e *Env) GetDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	docs, err := e.Store.GetDocuments(user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if filter := parseListFilter(r); !filter.empty() {
+		docs = slices.DeleteFunc(docs, func(d data.Document) bool {
+			return !filter.matches(d.Tags, d.Favorite)
+		})
+	}
+
+	if err = json.NewEncoder(w).Encode(docs); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
 func (e *Env) GetDocumentHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := authenticate(w, r, data.PermissionViewDocuments)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
 	if !ok {
 		return
 	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	doc, err := e.Store.GetDocument(id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(doc); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 }
 
-func (e *Env) NewDocumentHandler(w http.ResponseWriter, r *http.Request) {
-	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+// refResolver resolves links in documents to objects the user is allowed to view.
+func (e *Env) refResolver(user data.User) render.Resolver {
+	return func(ref render.Ref) (title string, url string, ok bool) {
+		objRef := data.ObjectRef{Type: data.ObjectType(ref.Type), ID: ref.ID}
+		if !e.canView(objRef, user) {
+			return "", "", false
+		}
+
+		title, vaultId, err := e.Store.GetObjectTitle(objRef)
+		if err != nil {
+			if !data.IsErrNotFound(err) {
+				log.Error(err.Error())
+			}
+			return "", "", false
+		}
+		if objRef.Type == data.ObjectPassword && vaultId != ref.VaultID {
+			return "", "", false
+		}
+		return title, objectURL(objRef.Type, ref.ID, vaultId), true
+	}
+}
+
+// renderDocument renders the document to sanitized HTML according to its format.
+func (e *Env) renderDocument(doc data.Document, user data.User) (string, error) {
+	switch doc.Format {
+	case data.FormatPlain:
+		return render.Plain(doc.Payload), nil
+	case data.FormatHTML:
+		return render.HTML(doc.Payload), nil
+	default:
+		return render.Markdown(doc.Payload, e.refResolver(user))
+	}
+}
+
+// RenderDocumentHandler responds with the document rendered to sanitized HTML.
+func (e *Env) RenderDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
 	if !ok {
 		return
 	}
 
-	var body struct {
-		Name string `json:"name"`
-		Body string `json:"body"`
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
 	}
+
+	doc, err := e.Store.GetDocument(id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rendered, err := e.renderDocument(doc, user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The output is sanitized already, the policy is a second line of defense
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src * data:; style-src 'unsafe-inline'")
+	w.Write([]byte(rendered))
+}
+
+func (e *Env) NewDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	var body data.Document
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if body.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if body.Format != "" && !body.Format.Valid() {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	id, err := e.Store.CreateDocument(body, user)
+	if errors.Is(err, data.ErrFolderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/documents/"+strconv.Itoa(id))
+	w.WriteHeader(http.StatusCreated)
 }
 
 func (e *Env) UpdateDocumentHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := authenticate(w, r, data.PermissionManageDocuments)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	var body data.Document
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.ID = id
+	if body.Format != "" && !body.Format.Valid() {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
 	if !ok {
 		return
 	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.UpdateDocument(body, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (e *Env) DeleteDocumentHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := authenticate(w, r, data.PermissionManageDocuments)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
 	if !ok {
 		return
 	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.DeleteDocument(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (e *Env) ShareDocumentHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := authenticate(w, r, data.PermissionManageDocuments)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	targetUsername := r.URL.Query().Get("target")
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
 	if !ok {
 		return
 	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	target, err := e.Store.GetUserByUsername(targetUsername)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = e.Store.ShareDocument(id, target, user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"github.com/TaeKwonZeus/pva/config"
 	"github.com/TaeKwonZeus/pva/data"
 )
 
 type Env struct {
 	Store    *data.Store
 	TokenKey []byte
+	Config   *config.Config
 }
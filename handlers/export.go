@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/TaeKwonZeus/pva/export"
+	"github.com/charmbracelet/log"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type exportRequest struct {
+	Documents []int `json:"documents"`
+	// Format is either html or pdf, html by default
+	Format             string `json:"format"`
+	Title              string `json:"title"`
+	ExcludeAttachments bool   `json:"excludeAttachments"`
+}
+
+// errExportTooLarge is returned when the attachments of an export add up to more than it may hold.
+var errExportTooLarge = errors.New("attachments are too large to export")
+
+// exportDocument decrypts and renders a document the user has access to for an export. Attachments are
+// included if withAttachments is set, taking up to *budget bytes in total, which is reduced by what they took.
+// Returns errExportTooLarge if they don't fit.
+func (e *Env) exportDocument(id int, withAttachments bool, budget *int64, user data.User) (doc export.Document,
+	err error) {
+	d, err := e.Store.GetDocument(id, user)
+	if err != nil {
+		return
+	}
+
+	doc = export.Document{
+		Name:   d.Name,
+		Format: string(d.Format),
+		Tags:   d.Tags,
+	}
+	for _, f := range d.Path {
+		doc.Path = append(doc.Path, f.Name)
+	}
+
+	revisions, err := e.Store.GetRevisions(id)
+	if err != nil {
+		return
+	}
+	if len(revisions) > 0 {
+		doc.UpdatedAt = revisions[0].CreatedAt
+		if revisions[0].Author != nil {
+			doc.UpdatedBy = *revisions[0].Author
+		}
+	}
+
+	doc.HTML, err = e.renderDocument(d, user)
+	if err != nil || !withAttachments {
+		return
+	}
+
+	for _, a := range d.Attachments {
+		if a.Size > *budget {
+			return doc, errExportTooLarge
+		}
+		_, content, err := e.Store.GetAttachment(a.ID, id, user)
+		if err != nil {
+			return doc, err
+		}
+		b, err := io.ReadAll(io.LimitReader(content, *budget+1))
+		content.Close()
+		if err != nil {
+			return doc, err
+		}
+		if int64(len(b)) > *budget {
+			return doc, errExportTooLarge
+		}
+		*budget -= int64(len(b))
+		doc.Attachments = append(doc.Attachments, export.Attachment{
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Content:     b,
+		})
+	}
+	return
+}
+
+// ExportDocumentsHandler bundles the requested documents into a single HTML or PDF file for download.
+func (e *Env) ExportDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	var body exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Documents) == 0 {
+		http.Error(w, "documents required", http.StatusBadRequest)
+		return
+	}
+	if body.Format == "" {
+		body.Format = "html"
+	}
+	if body.Format != "html" && body.Format != "pdf" {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+	if body.Title == "" {
+		body.Title = "Document export"
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	bundle := export.Bundle{
+		Title:       body.Title,
+		Author:      user.Username,
+		GeneratedAt: time.Now(),
+	}
+	// Exports are built in memory, so their attachments are limited to the size of one upload
+	budget := int64(e.Config.Attachments.MaxSize) << 20
+	var seen []int
+	for _, id := range body.Documents {
+		if slices.Contains(seen, id) {
+			continue
+		}
+		seen = append(seen, id)
+
+		// Only documents the user holds a key for can be decrypted, so others fail the whole export
+		if !e.Store.CheckDocumentOwnership(id, user) {
+			http.Error(w, "no access to document "+strconv.Itoa(id), http.StatusForbidden)
+			return
+		}
+
+		doc, err := e.exportDocument(id, !body.ExcludeAttachments, &budget, user)
+		if errors.Is(err, errExportTooLarge) {
+			http.Error(w, "attachments are larger than "+strconv.Itoa(e.Config.Attachments.MaxSize)+
+				" MiB in total, export without them", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if data.IsErrNotFound(err) {
+			http.Error(w, "document "+strconv.Itoa(id)+" not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bundle.Documents = append(bundle.Documents, doc)
+	}
+
+	var buf bytes.Buffer
+	var err error
+	contentType := "text/html; charset=utf-8"
+	if body.Format == "pdf" {
+		contentType = "application/pdf"
+		err = export.PDF(&buf, bundle)
+	} else {
+		err = export.HTML(&buf, bundle)
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	fileName := "pva-export-" + bundle.GeneratedAt.Format(time.DateOnly) + "." + body.Format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w)
+}
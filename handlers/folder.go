@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// moveRequest puts an object into the folder with FolderID, or the top level if it's null.
+type moveRequest struct {
+	FolderID *int `json:"folderId"`
+}
+
+func (e *Env) writeFolder(w http.ResponseWriter, id *int, user data.User) {
+	listing, err := e.Store.GetFolder(id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(listing); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetRootFolderHandler lists the top level folders, vaults and documents.
+func (e *Env) GetRootFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	e.writeFolder(w, nil, user)
+}
+
+// GetFolderHandler lists the subfolders, vaults and documents of a folder.
+func (e *Env) GetFolderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	e.writeFolder(w, &id, user)
+}
+
+func (e *Env) NewFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var body data.Folder
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageFolders)
+	if !ok {
+		return
+	}
+
+	id, err := e.Store.CreateFolder(body)
+	if errors.Is(err, data.ErrFolderNotFound) {
+		http.Error(w, "parent folder not found", http.StatusNotFound)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "folder already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/folders/"+strconv.Itoa(id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (e *Env) RenameFolderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	var body data.Folder
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageFolders)
+	if !ok {
+		return
+	}
+
+	err = e.Store.RenameFolder(id, body.Name)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "folder already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	var body moveRequest
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageFolders)
+	if !ok {
+		return
+	}
+
+	err = e.Store.MoveFolder(id, body.FolderID)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrFolderNotFound) {
+		http.Error(w, "parent folder not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrFolderCycle) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "folder already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) DeleteFolderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageFolders)
+	if !ok {
+		return
+	}
+
+	err = e.Store.DeleteFolder(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrFolderNotEmpty) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) GetFolderSharesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	shares, err := e.Store.GetFolderShares(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(shares); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetFolderSharesHandler sets the users that vaults and documents put into the folder or its subfolders get
+// shared with.
+func (e *Env) SetFolderSharesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	var body data.FolderShares
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageFolders)
+	if !ok {
+		return
+	}
+
+	users := make([]data.User, 0, len(body.Users))
+	for _, username := range body.Users {
+		user, err := e.Store.GetUserByUsername(username)
+		if data.IsErrNotFound(err) {
+			http.Error(w, "user "+username+" not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		users = append(users, user)
+	}
+
+	err = e.Store.SetFolderShares(id, users)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) MoveVaultHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid vault id", http.StatusBadRequest)
+		return
+	}
+
+	var body moveRequest
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManagePasswords)
+	if !ok {
+		return
+	}
+
+	// Moving shares the vault with the folder's users, which time-limited access doesn't allow
+	if !e.Store.CheckVaultAdministration(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.MoveVault(id, body.FolderID, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "vault not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrFolderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "vault already exists in folder", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) MoveDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	var body moveRequest
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.MoveDocument(id, body.FolderID, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, data.ErrFolderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/TaeKwonZeus/pva/data"
 	"github.com/charmbracelet/log"
 	"github.com/go-chi/chi/v5"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 )
 
 func (e *Env) NewVaultHandler(w http.ResponseWriter, r *http.Request) {
@@ -26,6 +29,10 @@ func (e *Env) NewVaultHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "vault already exists", http.StatusConflict)
 		return
 	}
+	if errors.Is(err, data.ErrFolderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Error(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -48,6 +55,23 @@ func (e *Env) GetVaultsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Vaults matching the filter are kept whole, others only with their matching passwords
+	if filter := parseListFilter(r); !filter.empty() {
+		filtered := vaults[:0]
+		for _, v := range vaults {
+			if !filter.matches(v.Tags, v.Favorite) {
+				v.Passwords = slices.DeleteFunc(v.Passwords, func(p data.Password) bool {
+					return !filter.matches(p.Tags, p.Favorite)
+				})
+				if len(v.Passwords) == 0 {
+					continue
+				}
+			}
+			filtered = append(filtered, v)
+		}
+		vaults = filtered
+	}
+
 	err = json.NewEncoder(w).Encode(vaults)
 	if err != nil {
 		log.Error(err.Error())
@@ -116,6 +140,10 @@ func (e *Env) DeleteVaultHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = e.Store.DeleteVault(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "vault not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Error(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -134,12 +162,28 @@ func (e *Env) ShareVaultHandler(w http.ResponseWriter, r *http.Request) {
 
 	targetUsername := r.URL.Query().Get("target")
 
+	// Access is permanent unless a duration in seconds is given
+	var expiresAt *time.Time
+	if d := r.URL.Query().Get("duration"); d != "" {
+		seconds, err := strconv.Atoi(d)
+		if err != nil {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		if !e.checkGrantDuration(w, seconds) {
+			return
+		}
+		t := time.Now().Add(time.Duration(seconds) * time.Second)
+		expiresAt = &t
+	}
+
 	user, ok := authenticate(w, r, data.PermissionManagePasswords)
 	if !ok {
 		return
 	}
 
-	if !e.Store.CheckVaultOwnership(id, user) {
+	// Time-limited access doesn't allow sharing, or holders could extend it for themselves
+	if !e.Store.CheckVaultAdministration(id, user) {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
@@ -154,8 +198,12 @@ func (e *Env) ShareVaultHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if target.ID == user.ID {
+		http.Error(w, "can't share a vault with yourself", http.StatusBadRequest)
+		return
+	}
 
-	err = e.Store.ShareVault(id, target, user)
+	err = e.Store.ShareVault(id, target, user, expiresAt)
 	if err != nil {
 		log.Error(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -214,7 +262,11 @@ func (e *Env) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var body data.Password
+	var body struct {
+		data.Password
+		// CheckoutRequired is only changed if present
+		CheckoutRequired *bool `json:"checkoutRequired"`
+	}
 	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -230,8 +282,18 @@ func (e *Env) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	// Lifting the checkout requirement reveals the password to everyone with access, so it takes the same
+	// access as sharing the vault
+	if body.CheckoutRequired != nil && !e.Store.CheckVaultAdministration(vaultId, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
-	err = e.Store.UpdatePassword(body, vaultId, user)
+	err = e.Store.UpdatePassword(body.Password, vaultId, body.CheckoutRequired, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "password not found", http.StatusNotFound)
+		return
+	}
 	if data.IsErrConflict(err) {
 		http.Error(w, "password already exists in the same vault", http.StatusConflict)
 		return
@@ -267,7 +329,11 @@ func (e *Env) DeletePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = e.Store.DeletePassword(passwordId)
+	err = e.Store.DeletePassword(passwordId, vaultId)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "password not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Error(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
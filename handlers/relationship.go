@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// canLink reports whether the user can view both ends of a relationship and manage its source.
+func (e *Env) canLink(w http.ResponseWriter, rel data.Relationship, user data.User) bool {
+	if !e.canView(rel.Source, user) {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return false
+	}
+	if !e.canView(rel.Target, user) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return false
+	}
+	if permission := objectPermissions[rel.Source.Type][1]; !data.CheckPermission(user.Role, permission) {
+		http.Error(w, "permission not satisfied: "+string(permission), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// GetRelatedHandler lists the objects linked to an object that the user is allowed to view.
+func (e *Env) GetRelatedHandler(w http.ResponseWriter, r *http.Request) {
+	ref, user, ok := e.authenticateObject(w, r, false)
+	if !ok {
+		return
+	}
+
+	items, err := e.Store.GetRelated(ref)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Related passwords and documents are left out unless the user has their key
+	items = slices.DeleteFunc(items, func(item data.RelatedItem) bool {
+		return !e.canView(data.ObjectRef{Type: item.Type, ID: item.ID}, user)
+	})
+	for i := range items {
+		items[i].URL = objectURL(items[i].Type, items[i].ID, items[i].VaultID)
+	}
+
+	if err = json.NewEncoder(w).Encode(items); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) LinkHandler(w http.ResponseWriter, r *http.Request) {
+	var body data.Relationship
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !body.Kind.Valid() {
+		http.Error(w, "invalid relationship kind", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	if !e.canLink(w, body, user) {
+		return
+	}
+
+	id, err := e.Store.Link(body)
+	if errors.Is(err, data.ErrSelfRelationship) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "relationship already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rel, err := e.Store.GetRelationship(id)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(rel); err != nil {
+		log.Error(err.Error())
+		return
+	}
+}
+
+func (e *Env) UnlinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid relationship id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	rel, err := e.Store.GetRelationship(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "relationship not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !e.canLink(w, rel, user) {
+		return
+	}
+
+	err = e.Store.Unlink(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "relationship not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// diffContext is how many unchanged lines surround changes in unified diffs.
+const diffContext = 3
+
+// revisionParams parses the document and revision ids from the URL.
+func revisionParams(w http.ResponseWriter, r *http.Request) (docId int, revisionId int, ok bool) {
+	docId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+	revisionId, err = strconv.Atoi(chi.URLParam(r, "revisionId"))
+	if err != nil {
+		http.Error(w, "invalid revision id", http.StatusBadRequest)
+		return
+	}
+	return docId, revisionId, true
+}
+
+func (e *Env) GetRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	revisions, err := e.Store.GetRevisions(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(revisions); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) GetRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, revisionId, ok := revisionParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	revision, err := e.Store.GetRevision(revisionId, id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(revision); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DiffRevisionsHandler diffs revision "from" against revision "to", or the current document if "to" is
+// missing. Returns a unified diff if format is "unified" and JSON lines otherwise.
+func (e *Env) DiffRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := strconv.Atoi(query.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from revision", http.StatusBadRequest)
+		return
+	}
+	var to int
+	if query.Has("to") {
+		to, err = strconv.Atoi(query.Get("to"))
+		if err != nil || to < 1 {
+			http.Error(w, "invalid to revision", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	diff, err := e.Store.DiffRevisions(id, from, to, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if query.Get("format") == "unified" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.Write([]byte(diff.Unified(diffContext)))
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(diff); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) RestoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, revisionId, ok := revisionParams(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err := e.Store.RestoreRevision(revisionId, id, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
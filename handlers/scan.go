@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"net/http"
+	"time"
+)
+
+// sseKeepAlive is how often idle event streams get a comment, so proxies don't close them
+const sseKeepAlive = 30 * time.Second
+
+// ScanHandler starts scanning every target right away, rather than waiting for the interval.
+func (e *Env) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionManageDevices)
+	if !ok {
+		return
+	}
+
+	err := e.Store.ScanNow()
+	if errors.Is(err, data.ErrScanRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, data.ErrScanTooSoon) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (e *Env) ScanStatusHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionViewDevices)
+	if !ok {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(e.Store.GetScanStatus()); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ScanEventsHandler streams the progress of scans as server-sent events, starting with a status event. Each
+// event is named after its kind and carries it as JSON.
+func (e *Env) ScanEventsHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionViewDevices)
+	if !ok {
+		return
+	}
+
+	events, cancel := e.Store.SubscribeScan()
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := writeEvent(w, rc, "status", e.Store.GetScanStatus()); err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, rc, event.Kind, event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, name string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte("event: " + name + "\ndata: " + string(payload) + "\n\n")); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// objectURL is the frontend page showing an object.
+func objectURL(objectType data.ObjectType, id, vaultId int) string {
+	switch objectType {
+	case data.ObjectVault:
+		return fmt.Sprintf("/passwords?vault=%d", id)
+	case data.ObjectPassword:
+		return fmt.Sprintf("/passwords?vault=%d&password=%d", vaultId, id)
+	case data.ObjectDevice:
+		return fmt.Sprintf("/devices?device=%d", id)
+	case data.ObjectDocument:
+		return fmt.Sprintf("/documents?document=%d", id)
+	default:
+		return "/"
+	}
+}
+
+type searchResponse struct {
+	Total   int                 `json:"total"`
+	Results []data.SearchResult `json:"results"`
+}
+
+func (e *Env) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if o := r.URL.Query().Get("offset"); o != "" {
+		var err error
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	filter := parseListFilter(r)
+	results, total, err := e.Store.Search(data.SearchOptions{
+		Query:         query,
+		Tag:           filter.tag,
+		FavoritesOnly: filter.favorite,
+		Limit:         limit,
+		Offset:        offset,
+	}, user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for i := range results {
+		results[i].URL = objectURL(results[i].Type, results[i].ID, results[i].VaultID)
+	}
+
+	if err = json.NewEncoder(w).Encode(searchResponse{Total: total, Results: results}); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// objectPermissions maps object types to the permissions needed to view and manage them.
+var objectPermissions = map[data.ObjectType][2]data.Permission{
+	data.ObjectVault:    {data.PermissionViewPasswords, data.PermissionManagePasswords},
+	data.ObjectPassword: {data.PermissionViewPasswords, data.PermissionManagePasswords},
+	data.ObjectDevice:   {data.PermissionViewDevices, data.PermissionManageDevices},
+	data.ObjectDocument: {data.PermissionViewDocuments, data.PermissionManageDocuments},
+}
+
+// canView reports whether the user has the permission to view objects of the type and access to the object.
+func (e *Env) canView(ref data.ObjectRef, user data.User) bool {
+	perms, ok := objectPermissions[ref.Type]
+	return ok && data.CheckPermission(user.Role, perms[0]) && e.Store.CheckObjectAccess(ref, user)
+}
+
+// authenticateObject parses the object type and id in the URL and checks the user can view the object,
+// or manage it if manage is set, writing an error response otherwise.
+func (e *Env) authenticateObject(w http.ResponseWriter, r *http.Request, manage bool) (
+	ref data.ObjectRef, user data.User, ok bool) {
+	ref.Type = data.ObjectType(chi.URLParam(r, "type"))
+	perms, ok := objectPermissions[ref.Type]
+	if !ok {
+		http.Error(w, "invalid object type", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid object id", http.StatusBadRequest)
+		return ref, user, false
+	}
+	ref.ID = id
+
+	permission := perms[0]
+	if manage {
+		permission = perms[1]
+	}
+	user, ok = authenticate(w, r, permission)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckObjectAccess(ref, user) {
+		http.Error(w, string(ref.Type)+" not found", http.StatusNotFound)
+		return ref, user, false
+	}
+	return ref, user, true
+}
+
+// listFilter narrows down list endpoints by the tag and favorite query parameters.
+type listFilter struct {
+	tag      string
+	favorite bool
+}
+
+func parseListFilter(r *http.Request) listFilter {
+	return listFilter{
+		tag:      data.NormalizeTag(r.URL.Query().Get("tag")),
+		favorite: r.URL.Query().Get("favorite") == "true",
+	}
+}
+
+func (f listFilter) empty() bool {
+	return f.tag == "" && !f.favorite
+}
+
+func (f listFilter) matches(tags []string, favorite bool) bool {
+	return (f.tag == "" || slices.Contains(tags, f.tag)) && (!f.favorite || favorite)
+}
+
+func (e *Env) GetTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	// Tags of objects the user can't see would give away their labels
+	tags, err := e.Store.GetTags(func(ref data.ObjectRef) bool { return e.canView(ref, user) })
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(tags); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) SetTagsHandler(w http.ResponseWriter, r *http.Request) {
+	var body []string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ref, _, ok := e.authenticateObject(w, r, true)
+	if !ok {
+		return
+	}
+
+	err := e.Store.SetTags(ref, body)
+	if errors.Is(err, data.ErrInvalidTag) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) AddFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	ref, user, ok := e.authenticateObject(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := e.Store.AddFavorite(ref, user); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) RemoveFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	ref, user, ok := e.authenticateObject(w, r, false)
+	if !ok {
+		return
+	}
+
+	err := e.Store.RemoveFavorite(ref, user)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "not a favorite", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+func (e *Env) GetTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	templates, err := e.Store.GetTemplates()
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(templates); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) GetTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionViewDocuments)
+	if !ok {
+		return
+	}
+
+	template, err := e.Store.GetTemplate(chi.URLParam(r, "id"))
+	if data.IsErrNotFound(err) {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(template); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) NewTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var body data.Template
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if body.Format != "" && !body.Format.Valid() {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	id, err := e.Store.CreateTemplate(body)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/templates/"+strconv.Itoa(id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (e *Env) DeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	err := e.Store.DeleteTemplate(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrBuiltinTemplate) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if data.IsErrNotFound(err) {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type instantiateRequest struct {
+	// Name overrides the name of the document given by the template
+	Name      string            `json:"name"`
+	Variables map[string]string `json:"variables"`
+}
+
+// InstantiateTemplateHandler creates a new document from a template.
+func (e *Env) InstantiateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var body instantiateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	id, err := e.Store.InstantiateTemplate(chi.URLParam(r, "id"), body.Name, body.Variables, user)
+	var missing data.MissingVariablesError
+	if errors.As(err, &missing) {
+		http.Error(w, missing.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.IsErrNotFound(err) {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/documents/"+strconv.Itoa(id))
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+func (e *Env) GetTrashHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	items, err := e.Store.GetTrash(user)
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(items); err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (e *Env) RestoreVaultHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid vault id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManagePasswords)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckVaultOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.RestoreVault(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "vault not found in trash", http.StatusNotFound)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "vault already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) RestorePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	vaultId, err := strconv.Atoi(chi.URLParam(r, "vaultId"))
+	if err != nil {
+		http.Error(w, "invalid vault id", http.StatusBadRequest)
+		return
+	}
+	passwordId, err := strconv.Atoi(chi.URLParam(r, "passwordId"))
+	if err != nil {
+		http.Error(w, "invalid password id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManagePasswords)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckVaultOwnership(vaultId, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.RestorePassword(passwordId, vaultId)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "password not found in trash", http.StatusNotFound)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "password already exists in the same vault", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) RestoreDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := authenticate(w, r, data.PermissionManageDevices)
+	if !ok {
+		return
+	}
+
+	err = e.Store.RestoreDevice(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "device not found in trash", http.StatusNotFound)
+		return
+	}
+	if data.IsErrConflict(err) {
+		http.Error(w, "device already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) RestoreDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := authenticate(w, r, data.PermissionManageDocuments)
+	if !ok {
+		return
+	}
+
+	if !e.Store.CheckDocumentOwnership(id, user) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = e.Store.RestoreDocument(id)
+	if data.IsErrNotFound(err) {
+		http.Error(w, "document not found in trash", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
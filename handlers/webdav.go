@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/TaeKwonZeus/pva/data"
+	"github.com/TaeKwonZeus/pva/dav"
+	"github.com/charmbracelet/log"
+	"golang.org/x/net/webdav"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebDAVPrefix is the path the WebDAV tree is served under.
+const WebDAVPrefix = "/dav"
+
+// basicAuthTTL is how long verified basic auth credentials are remembered. WebDAV clients send them with every
+// request, and deriving the key from the password is slow on purpose.
+const basicAuthTTL = 5 * time.Minute
+
+type cachedCredentials struct {
+	userId    int
+	key       []byte
+	expiresAt time.Time
+}
+
+var (
+	davLocks = webdav.NewMemLS()
+	// basicAuthCache maps an HMAC of the credentials to the key they derive
+	basicAuthCache sync.Map
+)
+
+// basicAuthUser verifies basic auth credentials like LoginHandler does, returning the user with their private
+// key decrypted.
+func (e *Env) basicAuthUser(username, password string) (user data.User, ok bool) {
+	mac := hmac.New(sha256.New, e.TokenKey)
+	mac.Write([]byte(username + "\x00" + password))
+	cacheKey := string(mac.Sum(nil))
+
+	if v, found := basicAuthCache.Load(cacheKey); found {
+		c := v.(cachedCredentials)
+		if time.Now().Before(c.expiresAt) {
+			user, err := e.Store.GetUser(c.userId)
+			if err == nil {
+				if _, err = user.DecryptPrivateKey(c.key); err == nil {
+					return user, true
+				}
+			}
+		}
+		basicAuthCache.Delete(cacheKey)
+	}
+
+	user, err := e.Store.GetUserByUsername(username)
+	if err != nil {
+		return data.User{}, false
+	}
+	key := user.DeriveKey(password)
+	if _, err = user.DecryptPrivateKey(key); err != nil {
+		return data.User{}, false
+	}
+	basicAuthCache.Store(cacheKey, cachedCredentials{user.ID, key, time.Now().Add(basicAuthTTL)})
+	return user, true
+}
+
+// WebDAVAuthMiddleware accepts basic auth since file managers can't log in, falling back to the token cookie.
+func (e *Env) WebDAVAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			if _, err := r.Cookie("token"); err == nil {
+				e.AuthMiddleware(next).ServeHTTP(w, r)
+				return
+			}
+		}
+
+		user, verified := e.basicAuthUser(username, password)
+		if !ok || !verified {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pva", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "user", user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// WebDAVHandler serves folders, documents and attachments to WebDAV clients, decrypting and encrypting them
+// with the user's keys.
+func (e *Env) WebDAVHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authenticate(w, r, data.PermissionNone)
+	if !ok {
+		return
+	}
+
+	fs := dav.NewFileSystem(e.Store, user, e.uploadOptions())
+	fs.TrackBody(r)
+	h := &webdav.Handler{
+		Prefix:     WebDAVPrefix,
+		FileSystem: fs,
+		LockSystem: davLocks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Debug(err.Error(), "method", r.Method, "path", r.URL.Path)
+			}
+		},
+	}
+	h.ServeHTTP(w, r)
+}
@@ -6,11 +6,14 @@ import (
 	"github.com/TaeKwonZeus/pva/data"
 	"github.com/TaeKwonZeus/pva/handlers"
 	"github.com/TaeKwonZeus/pva/network"
+	"github.com/TaeKwonZeus/pva/notify"
 	"github.com/charmbracelet/log"
 	"io/fs"
 	stdlog "log"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"path"
 	"time"
 
@@ -58,26 +61,47 @@ func main() {
 	//}
 	// FIXME change in prod
 	tokenKey := make([]byte, 32)
-	env := &handlers.Env{Store: store, TokenKey: tokenKey}
+	env := &handlers.Env{Store: store, TokenKey: tokenKey, Config: cfg}
 
 	ip, err := network.OutboundIP()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if cfg.Alerts.OfflineAfter < 0 {
+		log.Fatal("offline alert delay cannot be negative", "offlineAfter", cfg.Alerts.OfflineAfter)
+	}
+	store.EnableAlerts(data.AlertRules{
+		NewDevices:   cfg.Alerts.NewDevices,
+		OfflineAfter: time.Duration(cfg.Alerts.OfflineAfter) * time.Minute,
+		MACChanges:   cfg.Alerts.MACChanges,
+	}, alertNotifier(cfg))
+	network.StartAutoDiscovery(scanTargets(cfg, ip), store.RecordScan)
 
-	mask := net.IPMask(net.ParseIP(cfg.Scan.Netmask).To4())
-	if mask == nil {
-		log.Fatal("invalid netmask", "mask", mask)
+	if cfg.Scan.HistoryRetention < 1 {
+		log.Fatal("scan history retention cannot be less than 1 day", "retention", cfg.Scan.HistoryRetention)
 	}
-	timeout := time.Duration(cfg.Scan.Timeout) * time.Second
-	if timeout < time.Second {
-		log.Fatal("timeout cannot be less than 1 second", "timeout", timeout)
+	store.StartScanHistoryPurge(time.Duration(cfg.Scan.HistoryRetention)*24*time.Hour, time.Hour)
+
+	if cfg.Trash.Retention < 1 {
+		log.Fatal("trash retention cannot be less than 1 day", "retention", cfg.Trash.Retention)
 	}
-	interval := time.Duration(cfg.Scan.Interval) * time.Second
-	if interval < time.Second {
-		log.Fatal("interval cannot be less than 1 second", "interval", interval)
+	store.StartTrashPurge(time.Duration(cfg.Trash.Retention)*24*time.Hour, time.Hour)
+	store.StartAccessExpiry(time.Minute)
+
+	if cfg.Checkout.Duration < 1 || cfg.Checkout.MaxDuration < cfg.Checkout.Duration {
+		log.Fatal("invalid checkout durations", "duration", cfg.Checkout.Duration, "max", cfg.Checkout.MaxDuration)
+	}
+	if cfg.Access.MaxDuration < 1 {
+		log.Fatal("maximum access duration cannot be less than 1 second", "maxDuration", cfg.Access.MaxDuration)
+	}
+	store.StartCheckoutExpiry(time.Minute)
+
+	if cfg.Attachments.MaxSize < 1 {
+		log.Fatal("max attachment size cannot be less than 1 MiB", "maxSize", cfg.Attachments.MaxSize)
+	}
+	if cfg.Attachments.Quota < 0 {
+		log.Fatal("attachment quota cannot be negative", "quota", cfg.Attachments.Quota)
 	}
-	network.StartAutoDiscovery(mask, timeout, interval)
 
 	log.Infof("starting server on https://%s:%d", ip, cfg.Port)
 	err = http.ListenAndServeTLS(
@@ -94,3 +118,128 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// scanTargets parses the configured scan targets, falling back to the subnet of the server.
+func scanTargets(cfg *config.Config, ip netip.Addr) []network.Target {
+	timeout := time.Duration(cfg.Scan.Timeout) * time.Second
+	if timeout < time.Second {
+		log.Fatal("timeout cannot be less than 1 second", "timeout", timeout)
+	}
+	interval := time.Duration(cfg.Scan.Interval) * time.Second
+	if interval < time.Second {
+		log.Fatal("interval cannot be less than 1 second", "interval", interval)
+	}
+
+	if len(cfg.Scan.Targets) == 0 {
+		mask := net.IPMask(net.ParseIP(cfg.Scan.Netmask).To4())
+		if mask == nil {
+			log.Fatal("invalid netmask", "mask", cfg.Scan.Netmask)
+		}
+		target, err := network.LocalTarget(ip, mask, interval, timeout)
+		if err != nil {
+			log.Fatal("invalid scan target", "err", err)
+		}
+		target.Scanners = scanScanners(cfg.Scan.Scanners, target.Name)
+		target.Ports = scanPorts(cfg.Scan.Ports, target.Name)
+		if err = network.CheckDuration(target); err != nil {
+			log.Fatal("invalid scan target", "err", err)
+		}
+		return []network.Target{target}
+	}
+
+	targets := make([]network.Target, 0, len(cfg.Scan.Targets))
+	names := make(map[string]bool)
+	for _, t := range cfg.Scan.Targets {
+		if t.Name == "" || names[t.Name] {
+			log.Fatal("scan targets need unique names", "name", t.Name)
+		}
+		names[t.Name] = true
+
+		targetInterval, targetTimeout := interval, timeout
+		if t.Interval != 0 {
+			targetInterval = time.Duration(t.Interval) * time.Second
+		}
+		if t.Timeout != 0 {
+			targetTimeout = time.Duration(t.Timeout) * time.Second
+		}
+		if targetInterval < time.Second || targetTimeout < time.Second {
+			log.Fatal("scan target interval and timeout cannot be less than 1 second", "target", t.Name)
+		}
+
+		if len(t.Ranges) == 0 && len(t.Interfaces) == 0 {
+			log.Fatal("scan target needs ranges or interfaces", "target", t.Name)
+		}
+		target, err := network.ParseTarget(t.Name, t.Ranges, t.Exclude, targetInterval, targetTimeout)
+		if err != nil {
+			log.Fatal("invalid scan target", "target", t.Name, "err", err)
+		}
+		for _, name := range t.Interfaces {
+			if _, err = net.InterfaceByName(name); err != nil {
+				log.Fatal("invalid scan target interface", "target", t.Name, "interface", name, "err", err)
+			}
+		}
+		target.Interfaces = t.Interfaces
+		target.Scanners = scanScanners(cfg.Scan.Scanners, t.Name)
+		if t.Scanners != nil {
+			target.Scanners = scanScanners(t.Scanners, t.Name)
+		}
+		target.Ports = scanPorts(cfg.Scan.Ports, t.Name)
+		if t.Ports != nil {
+			target.Ports = scanPorts(t.Ports, t.Name)
+		}
+		if err = network.CheckDuration(target); err != nil {
+			log.Fatal("invalid scan target", "target", t.Name, "err", err)
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// scanScanners validates the names of the scanners enabled on a target.
+func scanScanners(names []string, target string) []string {
+	for _, name := range names {
+		if _, ok := network.LookupScanner(name); !ok {
+			log.Fatal("unknown scanner", "target", target, "scanner", name)
+		}
+	}
+	return names
+}
+
+// scanPorts validates the TCP ports to probe on a target.
+func scanPorts(ports []int, target string) []int {
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			log.Fatal("invalid scan port", "target", target, "port", port)
+		}
+	}
+	return ports
+}
+
+// alertNotifier sets up delivery of alerts to the configured receivers, nil if there are none.
+func alertNotifier(cfg *config.Config) notify.Notifier {
+	var notifiers notify.Multi
+	if cfg.Alerts.Webhook != "" {
+		if u, err := url.Parse(cfg.Alerts.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			log.Fatal("invalid alert webhook", "url", cfg.Alerts.Webhook)
+		}
+		notifiers = append(notifiers, notify.NewWebhook(cfg.Alerts.Webhook))
+	}
+	if smtp := cfg.Alerts.SMTP; smtp.Addr != "" {
+		if smtp.From == "" || len(smtp.To) == 0 {
+			log.Fatal("alert mail needs a sender and recipients", "addr", smtp.Addr)
+		}
+		notifiers = append(notifiers, &notify.SMTP{Addr: smtp.Addr, Username: smtp.Username,
+			Password: smtp.Password, From: smtp.From, To: smtp.To})
+	}
+	if cfg.Alerts.Syslog.Enabled {
+		syslog, err := notify.NewSyslog(cfg.Alerts.Syslog.Network, cfg.Alerts.Syslog.Addr)
+		if err != nil {
+			log.Fatal("error connecting to syslog", "err", err)
+		}
+		notifiers = append(notifiers, syslog)
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return notifiers
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/charmbracelet/log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	etherTypeARP  = 0x0806
+	etherTypeIPv4 = 0x0800
+	arpRequest    = 1
+	arpReply      = 2
+	// arpFrameLen is the size of an Ethernet header followed by an ARP packet for IPv4 over Ethernet
+	arpFrameLen = 42
+)
+
+// arpScanner sends ARP requests to the addresses of a target on directly attached subnets. It finds hosts that
+// drop pings and have no open ports, as they still have to answer ARP to be reachable at all.
+type arpScanner struct{}
+
+// arpUnavailable is warned about once rather than on every scan.
+var arpUnavailable sync.Once
+
+func (arpScanner) Probes(target Target) int {
+	return len(target.Addrs())
+}
+
+func (s arpScanner) MaxDuration(target Target) time.Duration {
+	return time.Duration(s.Probes(target))*sendInterval + target.Timeout
+}
+
+func (arpScanner) Scan(target Target, found chan<- Device, probed func()) error {
+	ips := target.Addrs()
+	var n int
+	err := sweepARP(ips, target.Timeout, found, func() {
+		n++
+		probed()
+	})
+	// Addresses that weren't swept still count as probed
+	for ; n < len(ips); n++ {
+		probed()
+	}
+
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+		arpUnavailable.Do(func() {
+			log.Warn("ARP sweep unavailable, it needs Linux and CAP_NET_RAW", "err", err)
+		})
+		return nil
+	}
+	return err
+}
+
+// attachedSubnet is an IPv4 subnet an Ethernet interface is directly attached to.
+type attachedSubnet struct {
+	iface net.Interface
+	// ip is the address of the interface on the subnet
+	ip     netip.Addr
+	prefix netip.Prefix
+}
+
+// attachedSubnets lists the subnets ARP requests can be broadcast on.
+func attachedSubnets() ([]attachedSubnet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []attachedSubnet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&(net.FlagLoopback|net.FlagPointToPoint) != 0 ||
+			len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			prefix, err := netip.ParsePrefix(addr.String())
+			if err != nil || !prefix.Addr().Is4() {
+				continue
+			}
+			subnets = append(subnets, attachedSubnet{iface: iface, ip: prefix.Addr(), prefix: prefix.Masked()})
+		}
+	}
+	return subnets, nil
+}
+
+// newARPRequest builds an Ethernet frame broadcasting who has ip, asked by the interface at src.
+func newARPRequest(srcMAC net.HardwareAddr, src, ip netip.Addr) []byte {
+	frame := make([]byte, 0, arpFrameLen)
+	frame = append(frame, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	frame = append(frame, srcMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeARP)
+
+	// Ethernet hardware, IPv4 protocol and the lengths of their addresses
+	frame = binary.BigEndian.AppendUint16(frame, 1)
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeIPv4)
+	frame = append(frame, 6, 4)
+	frame = binary.BigEndian.AppendUint16(frame, arpRequest)
+	frame = append(frame, srcMAC...)
+	frame = append(frame, src.AsSlice()...)
+	frame = append(frame, make([]byte, 6)...)
+	frame = append(frame, ip.AsSlice()...)
+	return frame
+}
+
+// parseARPReply returns the sender of an ARP reply in an Ethernet frame.
+func parseARPReply(frame []byte) (ip netip.Addr, mac net.HardwareAddr, ok bool) {
+	if len(frame) < arpFrameLen || binary.BigEndian.Uint16(frame[12:]) != etherTypeARP {
+		return
+	}
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[2:]) != etherTypeIPv4 || arp[4] != 6 || arp[5] != 4 ||
+		binary.BigEndian.Uint16(arp[6:]) != arpReply {
+		return
+	}
+	ip = netip.AddrFrom4([4]byte(arp[14:18]))
+	mac = net.HardwareAddr(slices.Clone(arp[8:14]))
+	return ip, mac, true
+}
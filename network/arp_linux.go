@@ -0,0 +1,143 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"golang.org/x/sync/errgroup"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// arpReadTimeout is how often the receiver of a sweep checks whether it should stop.
+const arpReadTimeout = 100 * time.Millisecond
+
+// broadcastMAC is the destination of ARP requests.
+var broadcastMAC = [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// htons converts a 16-bit value to network byte order, as socket protocols are given in.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
+
+// sweepARP broadcasts an ARP request for each of the addresses on a directly attached subnet, sending the hosts
+// that reply to found. Addresses on no attached subnet are skipped. Opening the raw sockets takes CAP_NET_RAW.
+func sweepARP(ips []netip.Addr, timeout time.Duration, found chan<- Device, probed func()) error {
+	subnets, err := attachedSubnets()
+	if err != nil {
+		return err
+	}
+
+	bySubnet := make(map[int][]netip.Addr)
+	for _, ip := range ips {
+		i := -1
+		for j, subnet := range subnets {
+			if subnet.prefix.Contains(ip) && ip != subnet.ip {
+				i = j
+				break
+			}
+		}
+		if i == -1 {
+			probed()
+			continue
+		}
+		bySubnet[i] = append(bySubnet[i], ip)
+	}
+
+	// probed is called from a goroutine per subnet
+	var probedMu sync.Mutex
+	lockedProbed := func() {
+		probedMu.Lock()
+		defer probedMu.Unlock()
+		probed()
+	}
+
+	var g errgroup.Group
+	for i, ips := range bySubnet {
+		g.Go(func() error {
+			return sweepSubnet(subnets[i], ips, timeout, found, lockedProbed)
+		})
+	}
+	return g.Wait()
+}
+
+// sweepSubnet sends ARP requests for the addresses out of the interface attached to the subnet, collecting
+// replies until timeout after the last request.
+func sweepSubnet(subnet attachedSubnet, ips []netip.Addr, timeout time.Duration, found chan<- Device,
+	probed func()) error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		// Counted as probed by the caller
+		return os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	err = syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: subnet.iface.Index})
+	if err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+	tv := syscall.NsecToTimeval(arpReadTimeout.Nanoseconds())
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+
+	wanted := make(map[netip.Addr]bool, len(ips))
+	for _, ip := range ips {
+		wanted[ip] = true
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+			ip, mac, ok := parseARPReply(buf[:n])
+			if !ok || !wanted[ip] {
+				continue
+			}
+			delete(wanted, ip)
+			found <- Device{IP: ip, MAC: mac}
+		}
+	}()
+
+	to := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  subnet.iface.Index,
+		Halen:    6,
+		Addr:     broadcastMAC,
+	}
+	pace := time.NewTicker(sendInterval)
+	defer pace.Stop()
+	var sendErrs []error
+	for _, ip := range ips {
+		<-pace.C
+		if err := syscall.Sendto(fd, newARPRequest(subnet.iface.HardwareAddr, subnet.ip, ip), 0, to); err != nil {
+			sendErrs = append(sendErrs, os.NewSyscallError("sendto", err))
+		}
+		probed()
+	}
+
+	time.Sleep(timeout)
+	close(stop)
+	wg.Wait()
+
+	if len(sendErrs) == len(ips) {
+		return errors.Join(sendErrs...)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// sweepARP is only implemented on Linux, elsewhere hosts that drop pings are only found by their open ports.
+func sweepARP([]netip.Addr, time.Duration, chan<- Device, func()) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestARPFrames(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	src, ip := netip.MustParseAddr("192.168.1.2"), netip.MustParseAddr("192.168.1.7")
+
+	request := newARPRequest(mac, src, ip)
+	if len(request) != arpFrameLen {
+		t.Fatalf("request is %d bytes, want %d", len(request), arpFrameLen)
+	}
+	if _, _, ok := parseARPReply(request); ok {
+		t.Error("request parsed as a reply")
+	}
+
+	// Answer the request the way its target would
+	replyMAC, _ := net.ParseMAC("66:77:88:99:aa:bb")
+	reply := append([]byte{}, request...)
+	copy(reply[0:6], mac)
+	copy(reply[6:12], replyMAC)
+	reply[21] = arpReply
+	copy(reply[22:28], replyMAC)
+	copy(reply[28:32], ip.AsSlice())
+	copy(reply[32:38], mac)
+	copy(reply[38:42], src.AsSlice())
+
+	gotIP, gotMAC, ok := parseARPReply(reply)
+	if !ok || gotIP != ip || gotMAC.String() != replyMAC.String() {
+		t.Errorf("got %v %v %v, want %v %v", gotIP, gotMAC, ok, ip, replyMAC)
+	}
+	if _, _, ok := parseARPReply(reply[:30]); ok {
+		t.Error("truncated reply parsed")
+	}
+}
@@ -0,0 +1,168 @@
+package network
+
+import (
+	"github.com/charmbracelet/log"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+)
+
+// protocolICMPv6 is the IANA protocol number icmp.ParseMessage expects for ICMPv6.
+const protocolICMPv6 = 58
+
+// neighbor is an entry of the kernel's neighbor table.
+type neighbor struct {
+	IP  netip.Addr
+	MAC net.HardwareAddr
+}
+
+// scanIPv6 finds IPv6 hosts on the interfaces by pinging the all-nodes multicast address, then reading the
+// neighbor table, where hosts end up after resolving our address to reply. A /64 is far too large to probe
+// address by address.
+func scanIPv6(interfaces []string, deadline time.Time) []Device {
+	var ifaces []net.Interface
+	for _, name := range interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			log.Error("invalid scan interface", "interface", name, "err", err)
+			continue
+		}
+		ifaces = append(ifaces, *iface)
+	}
+
+	replies, err := pingAllNodes(ifaces, deadline)
+	if err != nil {
+		log.Debug("ICMPv6 multicast error", "err", err)
+	}
+
+	indexes := make(map[int]bool)
+	for _, iface := range ifaces {
+		indexes[iface.Index] = true
+	}
+	neighbors, err := ipv6Neighbors(indexes)
+	if err != nil {
+		log.Debug("IPv6 neighbor table error", "err", err)
+	}
+
+	// Group the addresses of each host by MAC, hosts whose MAC is unknown are listed by address
+	byMAC := make(map[string]*Device)
+	var devices []*Device
+	seen := make(map[netip.Addr]bool)
+	for _, n := range neighbors {
+		seen[n.IP] = true
+		if n.MAC == nil {
+			devices = append(devices, &Device{IPv6: []netip.Addr{n.IP}})
+			continue
+		}
+		dev, ok := byMAC[n.MAC.String()]
+		if !ok {
+			dev = &Device{MAC: n.MAC}
+			byMAC[n.MAC.String()] = dev
+			devices = append(devices, dev)
+		}
+		dev.IPv6 = append(dev.IPv6, n.IP)
+	}
+	for _, ip := range replies {
+		if !seen[ip] {
+			seen[ip] = true
+			devices = append(devices, &Device{IPv6: []netip.Addr{ip}})
+		}
+	}
+
+	res := make([]Device, len(devices))
+	for i, dev := range devices {
+		dev.IP = preferredIPv6(dev.IPv6)
+		res[i] = *dev
+	}
+	return res
+}
+
+// pingAllNodes sends an echo request to ff02::1 on each interface, collecting who replies until the deadline.
+func pingAllNodes(ifaces []net.Interface, deadline time.Time) ([]netip.Addr, error) {
+	conn, err := icmp.ListenPacket("udp6", "::")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	msg, _ := (&icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:  os.Getpid() & 0xffff,
+			Seq: 1,
+		},
+	}).Marshal(nil)
+	for _, iface := range ifaces {
+		dst := &net.UDPAddr{IP: net.IPv6linklocalallnodes, Zone: iface.Name}
+		if _, err = conn.WriteTo(msg, dst); err != nil {
+			log.Debug("ICMPv6 send error", "interface", iface.Name, "err", err)
+		}
+	}
+
+	var replies []netip.Addr
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(rb)
+		if err != nil {
+			// Reading until the deadline is how this ends
+			return replies, nil
+		}
+		msg, err := icmp.ParseMessage(protocolICMPv6, rb[:n])
+		if err != nil || msg.Type != ipv6.ICMPTypeEchoReply {
+			continue
+		}
+		udp, ok := peer.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(udp.IP)
+		if !ok {
+			continue
+		}
+		if ip.IsLinkLocalUnicast() {
+			ip = ip.WithZone(udp.Zone)
+		}
+		log.Debug("ICMPv6 echo response", "ip", ip)
+		replies = append(replies, ip)
+	}
+}
+
+// preferredIPv6 picks the address a host is best listed by, preferring global ones to link-local ones.
+func preferredIPv6(ips []netip.Addr) netip.Addr {
+	slices.SortFunc(ips, func(a, b netip.Addr) int {
+		if a.IsLinkLocalUnicast() != b.IsLinkLocalUnicast() {
+			if a.IsLinkLocalUnicast() {
+				return 1
+			}
+			return -1
+		}
+		return a.Compare(b)
+	})
+	return ips[0]
+}
+
+// mergeIPv6 adds the IPv6 hosts to the devices, attaching their addresses to IPv4 devices with the same MAC.
+func mergeIPv6(devices []Device, hosts []Device) []Device {
+	byMAC := make(map[string]int)
+	for i, dev := range devices {
+		if dev.MAC != nil {
+			byMAC[dev.MAC.String()] = i
+		}
+	}
+	for _, host := range hosts {
+		if i, ok := byMAC[host.MAC.String()]; ok && host.MAC != nil {
+			devices[i].IPv6 = append(devices[i].IPv6, host.IPv6...)
+			continue
+		}
+		devices = append(devices, host)
+	}
+	return devices
+}
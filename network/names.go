@@ -0,0 +1,276 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"github.com/charmbracelet/log"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/errgroup"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources a device name can come from.
+const (
+	NameSourceDNS     = "dns"
+	NameSourceMDNS    = "mdns"
+	NameSourceLLMNR   = "llmnr"
+	NameSourceNetBIOS = "netbios"
+)
+
+const (
+	// defaultNameTTL is how long names are cached when their source doesn't say
+	defaultNameTTL = 10 * time.Minute
+	// maxNameTTL keeps renamed devices from showing their old name for too long
+	maxNameTTL = time.Hour
+	// negativeNameTTL is how long to wait before asking devices without a name again
+	negativeNameTTL = 5 * time.Minute
+	// nameConcurrency limits how many devices are asked for their name at once
+	nameConcurrency = 32
+
+	mdnsPort  = 5353
+	llmnrPort = 5355
+)
+
+var errNoName = errors.New("no name")
+
+type cachedName struct {
+	name    string
+	source  string
+	expires time.Time
+}
+
+var (
+	nameCache   = make(map[netip.Addr]cachedName)
+	nameCacheMu sync.Mutex
+)
+
+func cachedNameOf(ip netip.Addr) (cachedName, bool) {
+	nameCacheMu.Lock()
+	defer nameCacheMu.Unlock()
+	cached, ok := nameCache[ip]
+	if !ok || time.Now().After(cached.expires) {
+		return cachedName{}, false
+	}
+	return cached, true
+}
+
+func cacheName(ip netip.Addr, name, source string, ttl time.Duration) {
+	nameCacheMu.Lock()
+	defer nameCacheMu.Unlock()
+	nameCache[ip] = cachedName{name: name, source: source, expires: time.Now().Add(min(ttl, maxNameTTL))}
+}
+
+// resolveNames names the devices, asking each source in turn: reverse DNS, mDNS, LLMNR and NetBIOS. Names
+// found by browsing DNS-SD services are used before asking devices directly. Each query is given timeout.
+func resolveNames(devices []Device, browsed map[netip.Addr]string, timeout time.Duration) {
+	var eg errgroup.Group
+	eg.SetLimit(nameConcurrency)
+	for i := range devices {
+		dev := &devices[i]
+		if cached, ok := cachedNameOf(dev.IP); ok {
+			dev.Name, dev.NameSource = cached.name, cached.source
+			continue
+		}
+		if name, ok := browsed[dev.IP]; ok {
+			dev.Name, dev.NameSource = name, NameSourceMDNS
+			cacheName(dev.IP, name, NameSourceMDNS, defaultNameTTL)
+			continue
+		}
+
+		eg.Go(func() error {
+			name, source, ttl := resolveName(dev.IP, timeout)
+			if name == "" {
+				cacheName(dev.IP, "", "", negativeNameTTL)
+				return nil
+			}
+			dev.Name, dev.NameSource = name, source
+			cacheName(dev.IP, name, source, ttl)
+			return nil
+		})
+	}
+	eg.Wait()
+}
+
+func resolveName(ip netip.Addr, timeout time.Duration) (name, source string, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip.WithZone("").String()); err == nil && len(names) > 0 {
+		return strings.TrimSuffix(names[0], "."), NameSourceDNS, defaultNameTTL
+	}
+
+	server := netip.AddrPortFrom(ip, mdnsPort)
+	if name, ttl, err := queryPTR(server, ip, timeout); err == nil {
+		return name, NameSourceMDNS, ttl
+	}
+	server = netip.AddrPortFrom(ip, llmnrPort)
+	if name, ttl, err := queryPTR(server, ip, timeout); err == nil {
+		return name, NameSourceLLMNR, ttl
+	}
+	if ip.Is4() {
+		if name, err := queryNetBIOS(ip, timeout); err == nil {
+			return name, NameSourceNetBIOS, defaultNameTTL
+		}
+	}
+	return "", "", 0
+}
+
+// reverseName is the name PTR records of ip are found under.
+func reverseName(ip netip.Addr) string {
+	ip = ip.Unmap()
+	if ip.Is4() {
+		b := ip.As4()
+		return strconv.Itoa(int(b[3])) + "." + strconv.Itoa(int(b[2])) + "." + strconv.Itoa(int(b[1])) + "." +
+			strconv.Itoa(int(b[0])) + ".in-addr.arpa."
+	}
+
+	const hexDigits = "0123456789abcdef"
+	b := ip.As16()
+	var sb strings.Builder
+	for i := len(b) - 1; i >= 0; i-- {
+		sb.WriteByte(hexDigits[b[i]&0xf])
+		sb.WriteByte('.')
+		sb.WriteByte(hexDigits[b[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String()
+}
+
+// queryPTR asks server for the PTR record of ip. mDNS responders and LLMNR responders both answer such
+// queries sent straight to them, in the same format as DNS.
+func queryPTR(server netip.AddrPort, ip netip.Addr, timeout time.Duration) (name string, ttl time.Duration,
+	err error) {
+	question, err := dnsmessage.NewName(reverseName(ip))
+	if err != nil {
+		return
+	}
+	id := uint16(rand.Uint32())
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id},
+		Questions: []dnsmessage.Question{{Name: question, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return
+	}
+
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(server))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	if _, err = conn.Write(query); err != nil {
+		return
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", 0, err
+		}
+		var msg dnsmessage.Message
+		if err = msg.Unpack(buf[:n]); err != nil || msg.ID != id {
+			continue
+		}
+		for _, answer := range msg.Answers {
+			if ptr, ok := answer.Body.(*dnsmessage.PTRResource); ok {
+				ttl = time.Duration(answer.Header.TTL) * time.Second
+				return strings.TrimSuffix(ptr.PTR.String(), "."), ttl, nil
+			}
+		}
+		return "", 0, errNoName
+	}
+}
+
+// browseMDNS asks mDNS responders for the services they offer, then for the instances of those services,
+// collecting the addresses and host names responders include in their answers. This names devices that don't
+// answer queries sent straight to them. Returns once the deadline has passed.
+func browseMDNS(deadline time.Time) map[netip.Addr]string {
+	names := make(map[netip.Addr]string)
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		log.Debug("mDNS browse error", "err", err)
+		return names
+	}
+	defer conn.Close()
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+	// Half of the time goes to finding services, the rest to their instances
+	half := time.Now().Add(time.Until(deadline) / 2)
+	services := make(map[string]bool)
+	browse(conn, group, []string{"_services._dns-sd._udp.local."}, half, func(msg dnsmessage.Message) {
+		for _, answer := range msg.Answers {
+			if ptr, ok := answer.Body.(*dnsmessage.PTRResource); ok {
+				services[ptr.PTR.String()] = true
+			}
+		}
+	})
+
+	serviceNames := make([]string, 0, len(services))
+	for service := range services {
+		serviceNames = append(serviceNames, service)
+	}
+	browse(conn, group, serviceNames, deadline, func(msg dnsmessage.Message) {
+		for _, resource := range append(msg.Answers, msg.Additionals...) {
+			host := strings.TrimSuffix(resource.Header.Name.String(), ".")
+			switch body := resource.Body.(type) {
+			case *dnsmessage.AResource:
+				names[netip.AddrFrom4(body.A)] = host
+			case *dnsmessage.AAAAResource:
+				names[netip.AddrFrom16(body.AAAA)] = host
+			}
+		}
+	})
+	return names
+}
+
+// browse sends PTR queries for the names to the mDNS group, handing responses to handle until the deadline.
+func browse(conn *net.UDPConn, group *net.UDPAddr, names []string, deadline time.Time,
+	handle func(dnsmessage.Message)) {
+	if len(names) == 0 {
+		return
+	}
+	questions := make([]dnsmessage.Question, 0, len(names))
+	for _, name := range names {
+		n, err := dnsmessage.NewName(name)
+		if err != nil {
+			continue
+		}
+		questions = append(questions, dnsmessage.Question{Name: n, Type: dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET})
+	}
+	query, err := (&dnsmessage.Message{Questions: questions}).Pack()
+	if err != nil {
+		return
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	if _, err = conn.WriteToUDP(query, group); err != nil {
+		log.Debug("mDNS browse error", "err", err)
+		return
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// Reading until the deadline is how this ends
+			return
+		}
+		var msg dnsmessage.Message
+		if err = msg.Unpack(buf[:n]); err != nil || !msg.Response {
+			continue
+		}
+		handle(msg)
+	}
+}
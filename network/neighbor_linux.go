@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Neighbor table attributes and states from linux/neighbour.h.
+const (
+	ndaDst    = 1
+	ndaLLAddr = 2
+
+	nudIncomplete = 0x01
+	nudFailed     = 0x20
+	nudNoARP      = 0x40
+
+	// ndMsgLen is the size of struct ndmsg preceding the attributes
+	ndMsgLen = 12
+)
+
+// arpPath is the kernel's IPv4 neighbor table.
+const arpPath = "/proc/net/arp"
+
+// atfCom flags complete entries of the ARP table.
+const atfCom = 0x2
+
+// ipv4Neighbors reads the resolved entries of the ARP table, falling back to netlink if /proc isn't mounted.
+func ipv4Neighbors() ([]neighbor, error) {
+	file, err := os.Open(arpPath)
+	if err != nil {
+		return netlinkNeighbors(syscall.AF_INET, nil)
+	}
+	defer file.Close()
+
+	var neighbors []neighbor
+	scanner := bufio.NewScanner(file)
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil || flags&atfCom == 0 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || isZero(mac) {
+			continue
+		}
+		neighbors = append(neighbors, neighbor{IP: ip, MAC: mac})
+	}
+	return neighbors, scanner.Err()
+}
+
+func isZero(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ipv6Neighbors dumps the resolved IPv6 neighbors on the interfaces with the given indexes.
+func ipv6Neighbors(indexes map[int]bool) ([]neighbor, error) {
+	return netlinkNeighbors(syscall.AF_INET6, indexes)
+}
+
+// netlinkNeighbors dumps the resolved neighbors of an address family, on the interfaces with the given indexes
+// or all of them if indexes is nil.
+func netlinkNeighbors(family int, indexes map[int]bool) ([]neighbor, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, family)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbors []neighbor
+	for _, msg := range msgs {
+		if msg.Header.Type != syscall.RTM_NEWNEIGH || len(msg.Data) < ndMsgLen {
+			continue
+		}
+		index := int(int32(binary.NativeEndian.Uint32(msg.Data[4:8])))
+		state := binary.NativeEndian.Uint16(msg.Data[8:10])
+		if (indexes != nil && !indexes[index]) || state == 0 || state&(nudIncomplete|nudFailed|nudNoARP) != 0 {
+			continue
+		}
+
+		var n neighbor
+		for attrs := msg.Data[ndMsgLen:]; len(attrs) >= syscall.SizeofRtAttr; {
+			l := int(binary.NativeEndian.Uint16(attrs[0:2]))
+			if l < syscall.SizeofRtAttr || l > len(attrs) {
+				break
+			}
+			value := attrs[syscall.SizeofRtAttr:l]
+			switch binary.NativeEndian.Uint16(attrs[2:4]) {
+			case ndaDst:
+				n.IP, _ = netip.AddrFromSlice(value)
+			case ndaLLAddr:
+				n.MAC = net.HardwareAddr(append([]byte(nil), value...))
+			}
+			attrs = attrs[min((l+syscall.RTA_ALIGNTO-1)&^(syscall.RTA_ALIGNTO-1), len(attrs)):]
+		}
+		if !n.IP.IsValid() || n.IP.IsMulticast() {
+			continue
+		}
+		if n.IP.IsLinkLocalUnicast() {
+			if iface, err := net.InterfaceByIndex(index); err == nil {
+				n.IP = n.IP.WithZone(iface.Name)
+			}
+		}
+		neighbors = append(neighbors, n)
+	}
+	return neighbors, nil
+}
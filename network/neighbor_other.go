@@ -0,0 +1,15 @@
+//go:build !linux
+
+package network
+
+import "errors"
+
+// ipv4Neighbors is only implemented on Linux, elsewhere devices are listed without their MAC.
+func ipv4Neighbors() ([]neighbor, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// ipv6Neighbors is only implemented on Linux, elsewhere hosts are only found by their echo replies.
+func ipv6Neighbors(map[int]bool) ([]neighbor, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,97 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+const (
+	netBIOSPort = 137
+	// nbstat is the type of node status requests, which return the names registered by a host
+	nbstat = 0x21
+	// netBIOSGroup flags names shared by a group of hosts, like workgroups
+	netBIOSGroup = 0x8000
+	// netBIOSWorkstation is the suffix of the name a host registers for itself
+	netBIOSWorkstation = 0x00
+)
+
+var errInvalidNetBIOS = errors.New("invalid NetBIOS response")
+
+// queryNetBIOS asks a host for its NetBIOS name with a node status request.
+func queryNetBIOS(ip netip.Addr, timeout time.Duration) (string, error) {
+	id := uint16(rand.Uint32())
+	// Header: id, flags, one question, no answer, authority or additional records
+	req := binary.BigEndian.AppendUint16(nil, id)
+	req = append(req, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0)
+	// The wildcard name "*" padded with NULs, first-level encoded into 32 letters
+	req = append(req, 32, 'C', 'K')
+	req = append(req, strings.Repeat("A", 30)...)
+	req = append(req, 0)
+	req = binary.BigEndian.AppendUint16(req, nbstat)
+	req = binary.BigEndian.AppendUint16(req, 1)
+
+	conn, err := net.DialUDP("udp4", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, netBIOSPort)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	if _, err = conn.Write(req); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if n < 12 || binary.BigEndian.Uint16(buf) != id {
+			continue
+		}
+		return parseNodeStatus(buf[:n])
+	}
+}
+
+// parseNodeStatus picks the workstation name out of a node status response.
+func parseNodeStatus(res []byte) (string, error) {
+	// Skip the header and the name of the answer, which is either a pointer or a sequence of labels
+	i := 12
+	if i >= len(res) {
+		return "", errInvalidNetBIOS
+	}
+	if res[i]&0xc0 == 0xc0 {
+		i += 2
+	} else {
+		for i < len(res) && res[i] != 0 {
+			i += int(res[i]) + 1
+		}
+		i++
+	}
+	// Type, class, TTL and data length, followed by the number of names
+	i += 10
+	if i >= len(res) {
+		return "", errInvalidNetBIOS
+	}
+	count := int(res[i])
+	i++
+
+	// Each name is 15 bytes padded with spaces, a suffix and flags
+	for ; count > 0 && i+18 <= len(res); count, i = count-1, i+18 {
+		suffix := res[i+15]
+		flags := binary.BigEndian.Uint16(res[i+16:])
+		if suffix == netBIOSWorkstation && flags&netBIOSGroup == 0 {
+			if name := strings.TrimRight(string(res[i:i+15]), " \x00"); name != "" {
+				return name, nil
+			}
+		}
+	}
+	return "", errNoName
+}
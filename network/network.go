@@ -1,16 +1,14 @@
 package network
 
 import (
-	"encoding/binary"
+	"errors"
 	"github.com/charmbracelet/log"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/sync/errgroup"
-	"maps"
 	"net"
 	"net/netip"
 	"os"
-	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -32,13 +30,29 @@ func OutboundIP() (netip.Addr, error) {
 }
 
 type Device struct {
-	IP    netip.Addr
-	Name  string
-	MAC   net.HardwareAddr
-	IsTCP bool
+	// IP is the IPv4 address of the device, or its preferred IPv6 address if it has none
+	IP   netip.Addr
+	IPv6 []netip.Addr
+	Name string
+	// NameSource is the protocol Name was resolved by
+	NameSource string
+	MAC        net.HardwareAddr
+	// Vendor is the organization the MAC is registered to, if it's in the embedded registry
+	Vendor string
+	// IsTCP is set for devices with an open port, which are listed in Services
+	IsTCP    bool
+	Services []Service
+	// Target is the name of the target the device was found by
+	Target string
 }
 
-var cachedResult []Device
+var (
+	// targets are scanned by auto discovery, in the order their results are listed
+	targets []Target
+	// cachedResults holds the latest result of each target by name
+	cachedResults = make(map[string][]Device)
+	cacheMu       sync.RWMutex
+)
 
 func instantTick(interval time.Duration) <-chan time.Time {
 	c := make(chan time.Time)
@@ -52,129 +66,162 @@ func instantTick(interval time.Duration) <-chan time.Time {
 	return c
 }
 
-func StartAutoDiscovery(mask net.IPMask, timeout time.Duration, interval time.Duration) {
-	go func() {
-		var err error
-		for range instantTick(interval) {
-			cachedResult, err = Scan(mask, timeout)
-			if err != nil {
-				log.Error("scanning error", "err", err.Error())
-			} else {
-				log.Debug("auto device scan complete")
+// ScanHandler is given the devices found by each completed scan, along with when the scan started.
+type ScanHandler func(target Target, devices []Device, startedAt time.Time) error
+
+// StartAutoDiscovery scans each target on its own interval, handing the results to handle.
+func StartAutoDiscovery(scanTargets []Target, handle ScanHandler) {
+	targets, handler = scanTargets, handle
+	targetLocks = make(map[string]*sync.Mutex, len(targets))
+	for _, target := range targets {
+		targetLocks[target.Name] = &sync.Mutex{}
+	}
+	for _, target := range targets {
+		go func() {
+			for range instantTick(target.Interval) {
+				runScan(target)
 			}
-		}
-	}()
-	log.Info("auto device discovery started")
+		}()
+	}
+	log.Info("auto device discovery started", "targets", len(targets))
 }
 
+// Devices returns the devices found by the latest scan of every target, leaving out targets that haven't
+// finished their first scan yet. A device in several targets is listed once, tagged with the first of them.
 func Devices() []Device {
-	for cachedResult == nil {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	seen := make(map[netip.Addr]bool)
+	var devices []Device
+	for _, target := range targets {
+		for _, device := range cachedResults[target.Name] {
+			if !seen[device.IP] {
+				seen[device.IP] = true
+				devices = append(devices, device)
+			}
+		}
 	}
-	return slices.Clone(cachedResult)
+	return devices
 }
 
-func Scan(mask net.IPMask, timeout time.Duration) (devices []Device, err error) {
-	hostIP, err := OutboundIP()
-	if err != nil {
-		return nil, err
+// Scan probes every address of the target with the scanners enabled on it, returning the devices that
+// answered. Each probe waits up to the target's timeout, so scanning takes as long as the slowest scanner's
+// MaxDuration, plus resolving names. Its progress is reported to observe unless it's nil.
+func Scan(target Target, observe func(Event)) (devices []Device, err error) {
+	log.Debug("starting scan", "target", target.Name)
+
+	deadline := time.Now().Add(target.Timeout)
+	var ipv6Hosts chan []Device
+	if len(target.Interfaces) > 0 {
+		ipv6Hosts = make(chan []Device, 1)
+		go func() {
+			ipv6Hosts <- scanIPv6(target.Interfaces, deadline)
+		}()
 	}
 
-	log.Debug("starting scan", "host", hostIP.String())
-
-	ips := localIPs(hostIP, mask)
-	res := make(map[netip.Addr]Device)
+	browsed := make(chan map[netip.Addr]string, 1)
+	go func() {
+		browsed <- browseMDNS(deadline)
+	}()
 
-	icmpScanner, err := scanICMP(ips, timeout)
+	devices, err = collect(target, observe)
 	if err != nil {
 		return nil, err
 	}
-	tcpScanner := scanTCP(ips, timeout)
-	t := time.After(timeout)
+	fillMACs(devices)
+	if ipv6Hosts != nil {
+		hosts := <-ipv6Hosts
+		for i := range hosts {
+			hosts[i].Target = target.Name
+		}
+		devices = mergeIPv6(devices, hosts)
+	}
+	for i := range devices {
+		devices[i].Vendor = Vendor(devices[i].MAC)
+	}
+	resolveNames(devices, <-browsed, target.Timeout)
+	log.Info("scan complete", "target", target.Name, "devices", devices)
+	return devices, nil
+}
 
-	for {
-		select {
-		case dev, k := <-icmpScanner:
-			if !k {
-				continue
-			}
-			if _, ok := res[dev.IP]; !ok {
-				res[dev.IP] = dev
-			}
-		case dev, k := <-tcpScanner:
-			if !k {
-				continue
-			}
-			res[dev.IP] = dev
-		case <-t:
-			devices = slices.Collect(maps.Values(res))
-			log.Info("scan complete", "devices", devices)
-			return devices, nil
+// fillMACs looks up the MACs of the devices in the ARP table, which has entries for the ones on a directly
+// connected subnet after they've been probed. Devices behind a router are left without one.
+func fillMACs(devices []Device) {
+	neighbors, err := ipv4Neighbors()
+	if err != nil {
+		log.Debug("ARP table error", "err", err)
+		return
+	}
+	macs := make(map[netip.Addr]net.HardwareAddr, len(neighbors))
+	for _, n := range neighbors {
+		macs[n.IP] = n.MAC
+	}
+	for i := range devices {
+		if mac, ok := macs[devices[i].IP]; ok {
+			devices[i].MAC = mac
 		}
 	}
 }
 
-func localIPs(hostIP netip.Addr, mask net.IPMask) []netip.Addr {
-	host := binary.BigEndian.Uint32(hostIP.AsSlice())
-	netmask := binary.BigEndian.Uint32(mask)
-	networkAddr := host & netmask
-	broadcastAddr := networkAddr | ^netmask
+// icmpScanner pings each address of a target.
+type icmpScanner struct{}
 
-	var ips []netip.Addr
-	for i := networkAddr + 1; i <= broadcastAddr; i++ {
-		var ip [4]byte
-		binary.BigEndian.PutUint32(ip[:], i)
-		ips = append(ips, netip.AddrFrom4(ip))
-	}
-	return ips
+func (icmpScanner) Probes(target Target) int {
+	return len(target.Addrs())
 }
 
-func scanICMP(ips []netip.Addr, timeout time.Duration) (<-chan Device, error) {
-	c := make(chan Device)
+func (s icmpScanner) MaxDuration(target Target) time.Duration {
+	return time.Duration(s.Probes(target))*sendInterval + target.Timeout
+}
 
+func (icmpScanner) Scan(target Target, found chan<- Device, probed func()) error {
 	conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
 	if err != nil {
-		return nil, err
-	}
-
-	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, err
+		return err
 	}
+	defer conn.Close()
 
+	// Replies are read while the requests go out, until timeout after the last of them
+	received := make(chan struct{})
 	go func() {
-		defer conn.Close()
-
-		var wg sync.WaitGroup
-		var sent atomic.Int32
-		for _, ip := range ips {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := sendICMP(conn, ip); err != nil {
-					log.Debug("ICMP send error", "ip", ip, "err", err)
-					return
+		defer close(received)
+		for {
+			if err := recvICMP(conn, found); err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					log.Debug("ICMP recv error", "err", err)
 				}
-				log.Debug("sent packet", "ip", ip)
-				sent.Add(1)
-			}()
-		}
-		wg.Wait()
-
-		n := sent.Load()
-		log.Debugf("sent %d echo packets", n)
-
-		var eg errgroup.Group
-		for range n {
-			eg.Go(func() error {
-				return recvICMP(conn, c)
-			})
-		}
-		if err := eg.Wait(); err != nil {
-			log.Debug("ICMP recv error", "err", err)
+				return
+			}
 		}
-		close(c)
 	}()
 
-	return c, nil
+	pace := time.NewTicker(sendInterval)
+	defer pace.Stop()
+	var eg errgroup.Group
+	eg.SetLimit(sendConcurrency)
+	var sent atomic.Int32
+	for _, ip := range target.Addrs() {
+		<-pace.C
+		eg.Go(func() error {
+			defer probed()
+			if err := sendICMP(conn, ip); err != nil {
+				log.Debug("ICMP send error", "ip", ip, "err", err)
+				return nil
+			}
+			log.Debug("sent packet", "ip", ip)
+			sent.Add(1)
+			return nil
+		})
+	}
+	eg.Wait()
+	log.Debugf("sent %d echo packets", sent.Load())
+
+	if err = conn.SetReadDeadline(time.Now().Add(target.Timeout)); err != nil {
+		// Unblocks the receiver
+		conn.Close()
+	}
+	<-received
+	return err
 }
 
 func sendICMP(conn *icmp.PacketConn, ip netip.Addr) error {
@@ -191,6 +238,8 @@ func sendICMP(conn *icmp.PacketConn, ip netip.Addr) error {
 	return err
 }
 
+// recvICMP reads an ICMP message, sending the peer to res if it's an echo reply. Only failing to read is returned,
+// as malformed messages shouldn't stop the replies after them from being read.
 func recvICMP(conn *icmp.PacketConn, res chan<- Device) error {
 	rb := make([]byte, 1500)
 	n, peer, err := conn.ReadFrom(rb)
@@ -199,12 +248,14 @@ func recvICMP(conn *icmp.PacketConn, res chan<- Device) error {
 	}
 	peerIP, err := netip.ParseAddr(strings.TrimSuffix(peer.String(), ":0"))
 	if err != nil {
-		return err
+		log.Debug("ICMP peer error", "peer", peer, "err", err)
+		return nil
 	}
 
 	msg, err := icmp.ParseMessage(1, rb[:n])
 	if err != nil {
-		return err
+		log.Debug("ICMP parse error", "ip", peerIP, "err", err)
+		return nil
 	}
 
 	_, ok := msg.Body.(*icmp.Echo)
@@ -238,61 +289,7 @@ func recvICMP(conn *icmp.PacketConn, res chan<- Device) error {
 	log.Debug("echo response", "ip", peerIP, "msg", *msg)
 	res <- Device{
 		IP: peerIP,
-		// TODO find MAC and Name
 	}
 
 	return nil
 }
-
-func scanTCP(ips []netip.Addr, timeout time.Duration) <-chan Device {
-	c := make(chan Device)
-
-	go func() {
-		var eg errgroup.Group
-		for _, ip := range ips {
-			eg.Go(func() error {
-				err := dialTCP(ip, timeout, c)
-				if err != nil {
-					log.Debug("TCP dial error", "ip", ip, "err", err)
-					return err
-				}
-				log.Debug("TCP dial complete", "ip", ip)
-				return nil
-			})
-		}
-		if err := eg.Wait(); err != nil {
-			log.Debug("TCP dial error", "err", err)
-		}
-		close(c)
-	}()
-
-	return c
-}
-
-func dialTCP(ip netip.Addr, timeout time.Duration, res chan<- Device) error {
-	conn, err := net.Dial("tcp", ip.String()+":http")
-	if err != nil {
-		return err
-	}
-
-	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-		return err
-	}
-
-	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
-	if err != nil {
-		return err
-	}
-
-	addr, err := netip.ParseAddr(host)
-	if err != nil {
-		return err
-	}
-
-	res <- Device{
-		IP:    addr,
-		IsTCP: true,
-	}
-
-	return conn.Close()
-}
@@ -1,14 +1,29 @@
 package network
 
 import (
+	"errors"
 	"github.com/charmbracelet/log"
+	"net"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestScan(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 	log.Info("Started")
-	devices, err := Scan()
+	ip, err := OutboundIP()
+	if err != nil {
+		t.Skip("no outbound network:", err)
+	}
+	target, err := LocalTarget(ip, net.IPv4Mask(255, 255, 255, 0), time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := Scan(target, nil)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("ICMP sockets not permitted:", err)
+	}
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"github.com/charmbracelet/log"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// oui.csv is from the IEEE MA-L registry, mapping the first three bytes of MACs to the organizations they're
+// assigned to. The checked in copy is an excerpt of common network and device vendors, so other vendors stay
+// unknown unless go generate has replaced it with the full current registry before building. A failed download
+// leaves the excerpt in place.
+//
+//go:generate sh -c "curl -sSfL -o oui.csv.tmp https://standards-oui.ieee.org/oui/oui.csv && mv oui.csv.tmp oui.csv"
+//go:embed oui.csv
+var ouiCSV string
+
+// ouiTable is parsed on first use rather than at startup.
+var ouiTable = sync.OnceValue(func() map[[3]byte]string {
+	table := make(map[[3]byte]string)
+	r := csv.NewReader(strings.NewReader(ouiCSV))
+	// The registry has stray quotes in some addresses, which shouldn't cost the rest of it
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	// Registry, Assignment, Organization Name, Organization Address, after a header
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warn("skipping invalid OUI record", "err", err)
+			continue
+		}
+		if len(record) < 3 {
+			continue
+		}
+		b, err := hex.DecodeString(record[1])
+		if err != nil || len(b) != 3 {
+			continue
+		}
+		table[[3]byte(b)] = strings.TrimSpace(record[2])
+	}
+	return table
+})
+
+// Vendor returns the organization the MAC is registered to, or "" if it isn't in the embedded registry. Locally
+// administered MACs, like the random ones phones use, don't belong to anyone.
+func Vendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 || mac[0]&0x02 != 0 {
+		return ""
+	}
+	return ouiTable()[[3]byte(mac[:3])]
+}
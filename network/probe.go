@@ -0,0 +1,271 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/errgroup"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultPorts are probed on targets that don't list their own.
+var DefaultPorts = []int{22, 80, 443}
+
+const (
+	// dialConcurrency limits how many connections a scan has open at once
+	dialConcurrency = 256
+	// maxBannerWait caps how long to wait for services that greet clients first. It's also at most half of what's
+	// left of a probe, leaving the rest for trying TLS and HTTP on services that don't.
+	maxBannerWait = 2 * time.Second
+	// maxBannerLen is how much of a banner is kept
+	maxBannerLen = 200
+)
+
+// wellKnownServices names services that can't be told apart by talking to them.
+var wellKnownServices = map[int]string{
+	21:   "ftp",
+	22:   "ssh",
+	23:   "telnet",
+	25:   "smtp",
+	53:   "dns",
+	80:   "http",
+	110:  "pop3",
+	139:  "netbios",
+	143:  "imap",
+	443:  "https",
+	445:  "smb",
+	465:  "smtps",
+	587:  "smtp",
+	993:  "imaps",
+	995:  "pop3s",
+	1433: "mssql",
+	3306: "mysql",
+	3389: "rdp",
+	5432: "postgres",
+	5900: "vnc",
+	6379: "redis",
+	8080: "http",
+	8443: "https",
+}
+
+// Service is an open TCP port of a device.
+type Service struct {
+	Port int
+	// Name is the protocol the service speaks, guessed from its banner or else its port
+	Name string
+	// Banner is the first line the service sent, or for HTTP its status line and server
+	Banner string
+	// Certificate is set for services speaking TLS
+	Certificate *Certificate
+}
+
+// Certificate describes the leaf certificate a TLS service presented.
+type Certificate struct {
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+}
+
+// tcpScanner connects to each port of each address of a target, finding out what's listening.
+type tcpScanner struct{}
+
+func (tcpScanner) Probes(target Target) int {
+	return len(target.Addrs()) * len(target.Ports)
+}
+
+// MaxDuration assumes every probe takes the whole timeout, as they do when addresses don't answer at all.
+func (s tcpScanner) MaxDuration(target Target) time.Duration {
+	rounds := (s.Probes(target) + dialConcurrency - 1) / dialConcurrency
+	return time.Duration(rounds) * target.Timeout
+}
+
+func (tcpScanner) Scan(target Target, found chan<- Device, probed func()) error {
+	var eg errgroup.Group
+	eg.SetLimit(dialConcurrency)
+	for _, ip := range target.Addrs() {
+		for _, port := range target.Ports {
+			eg.Go(func() error {
+				service, ok := probeTCP(netip.AddrPortFrom(ip, uint16(port)), time.Now().Add(target.Timeout))
+				probed()
+				if !ok {
+					return nil
+				}
+				log.Debug("open port", "ip", ip, "port", port, "service", service.Name)
+				found <- Device{
+					IP:       ip,
+					IsTCP:    true,
+					Services: []Service{service},
+				}
+				return nil
+			})
+		}
+	}
+	return eg.Wait()
+}
+
+// probeTCP connects to addr and tries to find out what's listening before deadline: services that greet clients
+// are recognized by their banner, the others are tried with TLS, then plain HTTP.
+func probeTCP(addr netip.AddrPort, deadline time.Time) (service Service, open bool) {
+	service = Service{Port: int(addr.Port()), Name: wellKnownServices[int(addr.Port())]}
+
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", addr.String())
+	if err != nil {
+		return service, false
+	}
+	banner := readBanner(conn, min(time.Until(deadline)/2, maxBannerWait))
+	conn.Close()
+	if banner != "" {
+		service.Banner = banner
+		if name := identifyBanner(banner); name != "" {
+			service.Name = name
+		}
+		return service, true
+	}
+
+	if cert, banner, ok := probeTLS(addr, deadline); ok {
+		service.Certificate = &cert
+		service.Banner = banner
+		if banner != "" {
+			service.Name = "https"
+		} else if service.Name == "" {
+			service.Name = "tls"
+		}
+		return service, true
+	}
+
+	if banner := probeHTTP(addr, deadline); banner != "" {
+		service.Name, service.Banner = "http", banner
+	}
+	return service, true
+}
+
+// readBanner returns the first line conn sends within wait, or "" if it stays quiet.
+func readBanner(conn net.Conn, wait time.Duration) string {
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return ""
+	}
+	buf := make([]byte, 512)
+	n, _ := conn.Read(buf)
+	if n == 0 {
+		return ""
+	}
+	// Telnet servers open with option negotiation rather than text
+	if buf[0] == 0xff {
+		return "telnet"
+	}
+	line, _, _ := bytes.Cut(buf[:n], []byte("\n"))
+	return sanitizeBanner(string(line))
+}
+
+// identifyBanner guesses the protocol of a service from what it greets clients with.
+func identifyBanner(banner string) string {
+	upper := strings.ToUpper(banner)
+	switch {
+	case banner == "telnet":
+		return "telnet"
+	case strings.HasPrefix(banner, "SSH-"):
+		return "ssh"
+	case strings.HasPrefix(banner, "220"):
+		if strings.Contains(upper, "FTP") {
+			return "ftp"
+		}
+		if strings.Contains(upper, "SMTP") || strings.Contains(upper, "MAIL") {
+			return "smtp"
+		}
+	case strings.HasPrefix(banner, "+OK"):
+		return "pop3"
+	case strings.HasPrefix(banner, "* OK"):
+		return "imap"
+	}
+	return ""
+}
+
+// probeTLS attempts a handshake with addr, returning the certificate it presents and what it answers to an
+// HTTP request if it's HTTPS.
+func probeTLS(addr netip.AddrPort, deadline time.Time) (cert Certificate, banner string, ok bool) {
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr.String(), &tls.Config{
+		// Only the certificate is recorded, it isn't trusted for anything
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) > 0 {
+		cert = Certificate{
+			Subject:  certs[0].Subject.String(),
+			Issuer:   certs[0].Issuer.String(),
+			NotAfter: certs[0].NotAfter,
+		}
+	}
+	return cert, requestHTTP(conn, addr, deadline), true
+}
+
+// probeHTTP returns what addr answers to a plain HTTP request, or "" if it doesn't speak HTTP.
+func probeHTTP(addr netip.AddrPort, deadline time.Time) string {
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", addr.String())
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return requestHTTP(conn, addr, deadline)
+}
+
+// requestHTTP sends a HEAD request over conn, summarizing the response by its status line and server header.
+func requestHTTP(conn net.Conn, addr netip.AddrPort, deadline time.Time) string {
+	if err := conn.SetDeadline(deadline); err != nil {
+		return ""
+	}
+	host := addr.Addr().String()
+	if addr.Addr().Is6() {
+		host = "[" + host + "]"
+	}
+	_, err := conn.Write([]byte("HEAD / HTTP/1.0\r\nHost: " + host + ":" + strconv.Itoa(int(addr.Port())) +
+		"\r\nUser-Agent: pva\r\n\r\n"))
+	if err != nil {
+		return ""
+	}
+
+	r := bufio.NewReader(conn)
+	status, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(status, "HTTP/") {
+		return ""
+	}
+	banner := strings.TrimSpace(status)
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil || line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Server") {
+			banner += " (" + strings.TrimSpace(value) + ")"
+			break
+		}
+	}
+	return sanitizeBanner(banner)
+}
+
+// sanitizeBanner strips control characters and caps the length of what a service sent.
+func sanitizeBanner(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(s, ""))
+	s = strings.TrimSpace(s)
+	if len(s) > maxBannerLen {
+		s = strings.ToValidUTF8(s[:maxBannerLen], "")
+	}
+	return s
+}
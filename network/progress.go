@@ -0,0 +1,206 @@
+package network
+
+import (
+	"errors"
+	"github.com/charmbracelet/log"
+	"sync"
+	"time"
+)
+
+const (
+	// manualScanCooldown is how long after a scan requested by ScanNow another one can be requested
+	manualScanCooldown = 10 * time.Second
+	// progressInterval is how often running scans report their progress
+	progressInterval = 500 * time.Millisecond
+	// subscriberBuffer is how many events a subscriber can fall behind before missing some
+	subscriberBuffer = 64
+)
+
+var (
+	ErrScanRunning = errors.New("a scan is already running")
+	ErrScanTooSoon = errors.New("a scan was requested too recently")
+)
+
+// Kinds of scan events.
+const (
+	// EventStarted is sent when a scan starts probing
+	EventStarted = "started"
+	// EventHost is sent when a probe gets an answer, with what it learned about the host
+	EventHost     = "host"
+	EventProgress = "progress"
+	// EventDone is sent once the results of a scan have been handled
+	EventDone  = "done"
+	EventError = "error"
+)
+
+// Event reports the progress of a scan of a target.
+type Event struct {
+	Kind   string
+	Target string
+	// Device is set for host events
+	Device *Device
+	// Done and Total count the probes of the scan
+	Done  int
+	Total int
+	// Devices is how many devices the scan found, set for done events
+	Devices int
+	Err     error
+}
+
+// TargetStatus describes the scans of a target.
+type TargetStatus struct {
+	Target  string
+	Running bool
+	// StartedAt, Done and Total describe the running scan, or else the last one
+	StartedAt time.Time
+	Done      int
+	Total     int
+	// LastCompleted is when the last successful scan finished, zero if none has, and Devices what it found
+	LastCompleted time.Time
+	Devices       int
+	LastError     string
+}
+
+// Status describes the scans of every target.
+type Status struct {
+	// Manual is set while a scan requested by ScanNow is running
+	Manual  bool
+	Targets []TargetStatus
+}
+
+var (
+	// handler is given the results of every scan
+	handler ScanHandler
+	// targetLocks keep a target from being scanned twice at once
+	targetLocks map[string]*sync.Mutex
+
+	statuses = make(map[string]TargetStatus)
+	statusMu sync.RWMutex
+
+	subscribers = make(map[chan Event]bool)
+	subMu       sync.Mutex
+
+	manualRunning bool
+	lastManual    time.Time
+	manualMu      sync.Mutex
+)
+
+// runScan scans the target, caching the results and handing them to the handler.
+func runScan(target Target) {
+	lock := targetLocks[target.Name]
+	lock.Lock()
+	defer lock.Unlock()
+
+	startedAt := time.Now()
+	devices, err := Scan(target, publish)
+	if err != nil {
+		log.Error("scanning error", "target", target.Name, "err", err.Error())
+		publish(Event{Kind: EventError, Target: target.Name, Err: err})
+		return
+	}
+	cacheMu.Lock()
+	cachedResults[target.Name] = devices
+	cacheMu.Unlock()
+	if handler != nil {
+		if err = handler(target, devices, startedAt); err != nil {
+			log.Error("error handling scan", "target", target.Name, "err", err.Error())
+		}
+	}
+	publish(Event{Kind: EventDone, Target: target.Name, Devices: len(devices)})
+	log.Debug("device scan complete", "target", target.Name)
+}
+
+// ScanNow scans every target right away, returning once the scans have started. Only one such scan can run
+// at a time, and not more often than every manualScanCooldown.
+func ScanNow() error {
+	manualMu.Lock()
+	defer manualMu.Unlock()
+	if manualRunning {
+		return ErrScanRunning
+	}
+	if time.Since(lastManual) < manualScanCooldown {
+		return ErrScanTooSoon
+	}
+	manualRunning, lastManual = true, time.Now()
+
+	go func() {
+		var wg sync.WaitGroup
+		for _, target := range targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runScan(target)
+			}()
+		}
+		wg.Wait()
+
+		manualMu.Lock()
+		manualRunning = false
+		manualMu.Unlock()
+		log.Info("manual device scan complete", "targets", len(targets))
+	}()
+	return nil
+}
+
+// Subscribe returns a channel receiving the events of all scans until cancel is called. Events are dropped
+// for subscribers that don't keep up.
+func Subscribe() (events <-chan Event, cancel func()) {
+	c := make(chan Event, subscriberBuffer)
+	subMu.Lock()
+	subscribers[c] = true
+	subMu.Unlock()
+
+	return c, sync.OnceFunc(func() {
+		subMu.Lock()
+		delete(subscribers, c)
+		subMu.Unlock()
+		close(c)
+	})
+}
+
+// publish updates the status of the target of the event and sends it to subscribers.
+func publish(e Event) {
+	statusMu.Lock()
+	status := statuses[e.Target]
+	status.Target = e.Target
+	switch e.Kind {
+	case EventStarted:
+		status.Running, status.StartedAt = true, time.Now()
+		status.Done, status.Total = e.Done, e.Total
+	case EventHost, EventProgress:
+		status.Done, status.Total = e.Done, e.Total
+	case EventDone:
+		status.Running, status.LastCompleted, status.Devices, status.LastError = false, time.Now(), e.Devices, ""
+		e.Done, e.Total = status.Done, status.Total
+	case EventError:
+		status.Running, status.LastError = false, e.Err.Error()
+		e.Done, e.Total = status.Done, status.Total
+	}
+	statuses[e.Target] = status
+	statusMu.Unlock()
+
+	subMu.Lock()
+	defer subMu.Unlock()
+	for c := range subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// GetStatus describes the scans of every target, in the order they're listed in.
+func GetStatus() Status {
+	manualMu.Lock()
+	status := Status{Manual: manualRunning}
+	manualMu.Unlock()
+
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	status.Targets = make([]TargetStatus, len(targets))
+	for i, target := range targets {
+		status.Targets[i] = statuses[target.Name]
+		status.Targets[i].Target = target.Name
+	}
+	return status
+}
@@ -0,0 +1,206 @@
+package network
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/log"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scanner is a way of discovering hosts. Scanners are registered by name, which targets enable them by.
+type Scanner interface {
+	// Probes is how many probes scanning the target takes, for reporting progress
+	Probes(target Target) int
+	// MaxDuration is the longest scanning the target can take
+	MaxDuration(target Target) time.Duration
+	// Scan probes the target, sending what it learns about each host that answers to found and calling probed
+	// after each probe. It returns once every probe has been answered or timed out.
+	Scan(target Target, found chan<- Device, probed func()) error
+}
+
+// DefaultScanners are enabled on targets that don't list their own.
+var DefaultScanners = []string{"icmp", "tcp", "arp"}
+
+const (
+	// sendInterval paces the requests sweeps send to each address, so scanning a large target doesn't flood the
+	// network or overflow socket buffers
+	sendInterval = 200 * time.Microsecond
+	// sendConcurrency limits how many requests of a sweep are being sent at once
+	sendConcurrency = 64
+)
+
+var (
+	scanners   = make(map[string]Scanner)
+	scannersMu sync.RWMutex
+)
+
+func init() {
+	Register("icmp", icmpScanner{})
+	Register("tcp", tcpScanner{})
+	Register("arp", arpScanner{})
+}
+
+// Register makes a scanner available to targets under name. It panics if the name is taken.
+func Register(name string, scanner Scanner) {
+	scannersMu.Lock()
+	defer scannersMu.Unlock()
+	if _, ok := scanners[name]; ok {
+		panic("network: scanner " + name + " registered twice")
+	}
+	scanners[name] = scanner
+}
+
+// LookupScanner returns the scanner registered under name.
+func LookupScanner(name string) (Scanner, bool) {
+	scannersMu.RLock()
+	defer scannersMu.RUnlock()
+	scanner, ok := scanners[name]
+	return scanner, ok
+}
+
+// CheckDuration returns an error if a scanner enabled on the target can take longer than its interval, which
+// would keep its scans from running on schedule.
+func CheckDuration(target Target) error {
+	for _, name := range target.Scanners {
+		scanner, ok := LookupScanner(name)
+		if !ok {
+			return fmt.Errorf("unknown scanner %q", name)
+		}
+		if d := scanner.MaxDuration(target); d > target.Interval {
+			return fmt.Errorf("%s scans can take up to %s, longer than the interval of %s", name, d, target.Interval)
+		}
+	}
+	return nil
+}
+
+// collect runs the scanners enabled on the target, merging what they find into one device per address. A scanner
+// failing doesn't fail the scan unless all of them do.
+func collect(target Target, observe func(Event)) ([]Device, error) {
+	enabled := make([]Scanner, len(target.Scanners))
+	total := 0
+	for i, name := range target.Scanners {
+		scanner, ok := LookupScanner(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown scanner %q", name)
+		}
+		enabled[i] = scanner
+		total += scanner.Probes(target)
+	}
+
+	var probed atomic.Int64
+	report := func(kind string, dev *Device) {
+		if observe != nil {
+			observe(Event{Kind: kind, Target: target.Name, Device: dev, Done: int(probed.Load()), Total: total})
+		}
+	}
+
+	found := make(chan Device)
+	errs := make([]error, len(enabled))
+	var wg sync.WaitGroup
+	for i, scanner := range enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = scanner.Scan(target, found, func() { probed.Add(1) })
+			if errs[i] != nil {
+				log.Warn("scanner error", "target", target.Name, "scanner", target.Scanners[i], "err", errs[i])
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+	report(EventStarted, nil)
+
+	agg := newAggregator(target)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for found != nil {
+		select {
+		case <-ticker.C:
+			report(EventProgress, nil)
+		case dev, ok := <-found:
+			if !ok {
+				found = nil
+				continue
+			}
+			if merged, ok := agg.add(dev); ok {
+				report(EventHost, &merged)
+			}
+		}
+	}
+	report(EventProgress, nil)
+
+	if len(errs) > 0 && !slices.Contains(errs, nil) {
+		return nil, errors.Join(errs...)
+	}
+	return agg.result(), nil
+}
+
+// aggregator merges what scanners find about the hosts of a target.
+type aggregator struct {
+	target  Target
+	devices map[netip.Addr]*Device
+}
+
+func newAggregator(target Target) *aggregator {
+	return &aggregator{target: target, devices: make(map[netip.Addr]*Device)}
+}
+
+// add merges what a scanner found about a host into what's known about it, returning a copy of the result.
+// Hosts outside the target are dropped, as answers to scans of other targets can arrive on shared sockets.
+func (a *aggregator) add(dev Device) (merged Device, ok bool) {
+	if !a.target.Contains(dev.IP) {
+		return Device{}, false
+	}
+
+	existing, ok := a.devices[dev.IP]
+	if !ok {
+		dev.Target = a.target.Name
+		dev.Services = slices.Clone(dev.Services)
+		dev.IPv6 = slices.Clone(dev.IPv6)
+		a.devices[dev.IP] = &dev
+		existing = &dev
+	} else {
+		existing.IsTCP = existing.IsTCP || dev.IsTCP
+		for _, service := range dev.Services {
+			if !slices.ContainsFunc(existing.Services, func(s Service) bool { return s.Port == service.Port }) {
+				existing.Services = append(existing.Services, service)
+			}
+		}
+		for _, ip := range dev.IPv6 {
+			if !slices.Contains(existing.IPv6, ip) {
+				existing.IPv6 = append(existing.IPv6, ip)
+			}
+		}
+		if existing.MAC == nil {
+			existing.MAC = dev.MAC
+		}
+		if existing.Name == "" {
+			existing.Name, existing.NameSource = dev.Name, dev.NameSource
+		}
+	}
+
+	merged = *existing
+	merged.Services = slices.Clone(existing.Services)
+	merged.IPv6 = slices.Clone(existing.IPv6)
+	return merged, true
+}
+
+// result lists the devices by address, with their services by port.
+func (a *aggregator) result() []Device {
+	devices := make([]Device, 0, len(a.devices))
+	for _, ip := range slices.SortedFunc(maps.Keys(a.devices), netip.Addr.Compare) {
+		dev := *a.devices[ip]
+		slices.SortFunc(dev.Services, func(a, b Service) int { return cmp.Compare(a.Port, b.Port) })
+		devices = append(devices, dev)
+	}
+	return devices
+}
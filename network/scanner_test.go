@@ -0,0 +1,243 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeScanner finds the same devices every scan.
+type fakeScanner struct {
+	devices []Device
+	probes  int
+	err     error
+}
+
+func (f fakeScanner) Probes(Target) int {
+	return f.probes
+}
+
+func (f fakeScanner) MaxDuration(Target) time.Duration {
+	return 0
+}
+
+func (f fakeScanner) Scan(_ Target, found chan<- Device, probed func()) error {
+	for _, dev := range f.devices {
+		found <- dev
+	}
+	for range f.probes {
+		probed()
+	}
+	return f.err
+}
+
+// useScanners registers the scanners for the duration of the test, returning a target enabling them.
+func useScanners(t *testing.T, fakes map[string]Scanner) Target {
+	t.Helper()
+	target, err := ParseTarget("test", []string{"10.0.0.0/29"}, nil, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Scanners = nil
+	for name, scanner := range fakes {
+		Register(name, scanner)
+		target.Scanners = append(target.Scanners, name)
+		t.Cleanup(func() {
+			scannersMu.Lock()
+			delete(scanners, name)
+			scannersMu.Unlock()
+		})
+	}
+	slices.Sort(target.Scanners)
+	return target
+}
+
+func TestCollectMergesScanners(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	target := useScanners(t, map[string]Scanner{
+		"fake-ping": fakeScanner{probes: 6, devices: []Device{
+			{IP: netip.MustParseAddr("10.0.0.2")},
+			{IP: netip.MustParseAddr("10.0.0.1"), MAC: mac},
+		}},
+		"fake-ports": fakeScanner{probes: 12, devices: []Device{
+			{IP: netip.MustParseAddr("10.0.0.2"), IsTCP: true, Services: []Service{{Port: 80, Name: "http"}}},
+			{IP: netip.MustParseAddr("10.0.0.2"), IsTCP: true, Services: []Service{{Port: 22, Name: "ssh"}}},
+			// Outside the target
+			{IP: netip.MustParseAddr("10.0.1.2"), IsTCP: true, Services: []Service{{Port: 22, Name: "ssh"}}},
+		}},
+	})
+
+	var events []Event
+	devices, err := collect(target, func(e Event) { events = append(events, e) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2: %v", len(devices), devices)
+	}
+	first, second := devices[0], devices[1]
+	if first.IP.String() != "10.0.0.1" || first.MAC.String() != mac.String() || first.IsTCP {
+		t.Errorf("unexpected first device %+v", first)
+	}
+	if second.IP.String() != "10.0.0.2" || !second.IsTCP || len(second.Services) != 2 ||
+		second.Services[0].Port != 22 || second.Services[1].Port != 80 {
+		t.Errorf("unexpected second device %+v", second)
+	}
+	for _, dev := range devices {
+		if dev.Target != "test" {
+			t.Errorf("device %s has target %q", dev.IP, dev.Target)
+		}
+	}
+
+	if len(events) == 0 || events[0].Kind != EventStarted || events[0].Total != 18 {
+		t.Fatalf("scan didn't start with its total: %+v", events)
+	}
+	if last := events[len(events)-1]; last.Kind != EventProgress || last.Done != 18 {
+		t.Errorf("scan didn't end with its progress complete: %+v", last)
+	}
+	hosts := 0
+	for _, e := range events {
+		if e.Kind == EventHost {
+			hosts++
+		}
+	}
+	if hosts != 4 {
+		t.Errorf("got %d host events, want 4", hosts)
+	}
+}
+
+func TestCollectScannerErrors(t *testing.T) {
+	errFake := errors.New("fake error")
+	target := useScanners(t, map[string]Scanner{
+		"fake-broken": fakeScanner{err: errFake},
+		"fake-working": fakeScanner{devices: []Device{
+			{IP: netip.MustParseAddr("10.0.0.3")},
+		}},
+	})
+
+	devices, err := collect(target, nil)
+	if err != nil {
+		t.Fatal("one broken scanner failed the scan:", err)
+	}
+	if len(devices) != 1 {
+		t.Errorf("got %d devices, want 1", len(devices))
+	}
+
+	target.Scanners = []string{"fake-broken"}
+	if _, err = collect(target, nil); !errors.Is(err, errFake) {
+		t.Errorf("got error %v, want %v", err, errFake)
+	}
+
+	target.Scanners = []string{"fake-missing"}
+	if _, err = collect(target, nil); err == nil {
+		t.Error("unknown scanner didn't fail the scan")
+	}
+}
+
+func TestTCPScanner(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+
+	target, err := ParseTarget("local", []string{"127.0.0.1"}, nil, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Scanners = []string{"tcp"}
+	target.Ports = []int{ln.Addr().(*net.TCPAddr).Port}
+
+	devices, err := collect(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || len(devices[0].Services) != 1 {
+		t.Fatalf("got %+v, want one device with one service", devices)
+	}
+	if service := devices[0].Services[0]; service.Name != "ssh" || service.Banner != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("unexpected service %+v", service)
+	}
+}
+
+func TestTCPScannerTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// Connections are held open without a word, so every stage of a probe waits as long as it can
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	target, err := ParseTarget("local", []string{"127.0.0.1"}, nil, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Scanners = []string{"tcp"}
+	target.Ports = []int{ln.Addr().(*net.TCPAddr).Port}
+
+	start := time.Now()
+	devices, err := collect(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > target.Timeout+500*time.Millisecond {
+		t.Errorf("probing a silent port took %s with a timeout of %s", elapsed, target.Timeout)
+	}
+	if len(devices) != 1 || len(devices[0].Services) != 1 {
+		t.Errorf("got %+v, want one device with one service", devices)
+	}
+}
+
+func TestCheckDuration(t *testing.T) {
+	tests := []struct {
+		ranges   []string
+		ports    []int
+		interval time.Duration
+		ok       bool
+	}{
+		{[]string{"10.0.0.0/24"}, []int{22, 80, 443}, 2 * time.Minute, true},
+		{[]string{"10.0.0.0/16"}, []int{22, 80, 443}, 2 * time.Minute, false},
+		{[]string{"10.0.0.0/16"}, []int{22, 80, 443}, time.Hour, true},
+		{[]string{"10.0.0.0/16"}, nil, 2 * time.Minute, true},
+	}
+	for _, test := range tests {
+		target, err := ParseTarget("test", test.ranges, nil, test.interval, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.Ports = test.ports
+		if err = CheckDuration(target); (err == nil) != test.ok {
+			t.Errorf("%v with ports %v every %s: got error %v, want ok %t", test.ranges, test.ports, test.interval,
+				err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// maxTargetSize keeps a mistyped prefix like /8 from making a single scan dial millions of addresses.
+const maxTargetSize = 1 << 16
+
+// addrRange is an inclusive range of IPv4 addresses.
+type addrRange struct {
+	from, to netip.Addr
+}
+
+func (r addrRange) contains(ip netip.Addr) bool {
+	return r.from.Compare(ip) <= 0 && ip.Compare(r.to) <= 0
+}
+
+// size is a uint64 as the range of all addresses has one more than fits in a uint32.
+func (r addrRange) size() uint64 {
+	return uint64(toUint32(r.to)) - uint64(toUint32(r.from)) + 1
+}
+
+// Target is a set of addresses scanned together on its own schedule.
+type Target struct {
+	Name     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Interfaces are where IPv6 hosts are discovered, as their subnets are too large to scan by address
+	Interfaces []string
+	// Scanners are the names of the scanners the target is scanned with
+	Scanners []string
+	// Ports are probed on every address by the TCP scanner
+	Ports []int
+
+	ranges  []addrRange
+	exclude []addrRange
+}
+
+// ParseTarget builds a target from ranges, each either a CIDR prefix ("10.0.0.0/24"), a range of addresses
+// ("10.0.1.10-10.0.1.50") or a single address. Addresses in exclude are skipped.
+func ParseTarget(name string, ranges []string, exclude []string, interval, timeout time.Duration) (Target,
+	error) {
+	t := Target{Name: name, Interval: interval, Timeout: timeout, Scanners: DefaultScanners, Ports: DefaultPorts}
+
+	for _, s := range ranges {
+		r, err := parseRange(s)
+		if err != nil {
+			return t, err
+		}
+		t.ranges = append(t.ranges, r)
+	}
+	for _, s := range exclude {
+		r, err := parseRange(s)
+		if err != nil {
+			return t, err
+		}
+		t.exclude = append(t.exclude, r)
+	}
+
+	var size uint64
+	for _, r := range t.ranges {
+		size += r.size()
+		if size > maxTargetSize {
+			return t, fmt.Errorf("more than %d addresses", maxTargetSize)
+		}
+	}
+	return t, nil
+}
+
+// LocalTarget is the subnet of hostIP, the default when no targets are configured. IPv6 hosts are discovered
+// on the interface hostIP belongs to.
+func LocalTarget(hostIP netip.Addr, mask net.IPMask, interval, timeout time.Duration) (Target, error) {
+	ones, bits := mask.Size()
+	if bits != 32 || !hostIP.Is4() {
+		return Target{}, errors.New("only IPv4 subnets are supported")
+	}
+	prefix := netip.PrefixFrom(hostIP, ones).Masked()
+	t, err := ParseTarget("local", []string{prefix.String()}, nil, interval, timeout)
+	if err != nil {
+		return t, err
+	}
+	if iface := interfaceOf(hostIP); iface != "" {
+		t.Interfaces = []string{iface}
+	}
+	return t, nil
+}
+
+// interfaceOf returns the name of the interface with the address ip, or "" if there's none.
+func interfaceOf(ip netip.Addr) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if prefix, err := netip.ParsePrefix(addr.String()); err == nil && prefix.Addr() == ip {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+func parseRange(s string) (addrRange, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return addrRange{}, err
+		}
+		if !prefix.Addr().Is4() {
+			return addrRange{}, fmt.Errorf("%s: only IPv4 ranges are supported, IPv6 hosts are found on interfaces", s)
+		}
+		prefix = prefix.Masked()
+		from := toUint32(prefix.Addr())
+		to := from | ^uint32(0)>>prefix.Bits()
+		// Skip the network and broadcast addresses of subnets that have them
+		if prefix.Bits() < 31 {
+			from, to = from+1, to-1
+		}
+		return addrRange{fromUint32(from), fromUint32(to)}, nil
+	}
+
+	fromStr, toStr, isRange := strings.Cut(s, "-")
+	from, err := netip.ParseAddr(strings.TrimSpace(fromStr))
+	if err != nil {
+		return addrRange{}, err
+	}
+	to := from
+	if isRange {
+		if to, err = netip.ParseAddr(strings.TrimSpace(toStr)); err != nil {
+			return addrRange{}, err
+		}
+	}
+	if !from.Is4() || !to.Is4() {
+		return addrRange{}, fmt.Errorf("%s: only IPv4 ranges are supported, IPv6 hosts are found on interfaces", s)
+	}
+	if to.Less(from) {
+		return addrRange{}, fmt.Errorf("%s: range ends before it starts", s)
+	}
+	return addrRange{from, to}, nil
+}
+
+// Contains reports whether the target scans ip.
+func (t Target) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, r := range t.exclude {
+		if r.contains(ip) {
+			return false
+		}
+	}
+	for _, r := range t.ranges {
+		if r.contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Addrs lists the addresses of the target without duplicates.
+func (t Target) Addrs() []netip.Addr {
+	seen := make(map[netip.Addr]bool)
+	var ips []netip.Addr
+	for _, r := range t.ranges {
+		for i := toUint32(r.from); ; i++ {
+			ip := fromUint32(i)
+			if !seen[ip] && t.Contains(ip) {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+			if ip == r.to {
+				break
+			}
+		}
+	}
+	return ips
+}
+
+func toUint32(ip netip.Addr) uint32 {
+	b := ip.As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func fromUint32(i uint32) netip.Addr {
+	var ip [4]byte
+	binary.BigEndian.PutUint32(ip[:], i)
+	return netip.AddrFrom4(ip)
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseTargetSize(t *testing.T) {
+	tests := []struct {
+		ranges []string
+		ok     bool
+	}{
+		{[]string{"10.0.0.0/16"}, true},
+		{[]string{"10.0.0.0/15"}, false},
+		{[]string{"10.0.0.0/16", "10.1.0.1", "10.1.0.2", "10.1.0.3"}, false},
+		{[]string{"10.0.0.0-10.0.255.255"}, true},
+		{[]string{"10.0.0.0-10.1.0.0"}, false},
+		{[]string{"0.0.0.0/0"}, false},
+		{[]string{"0.0.0.0-255.255.255.255"}, false},
+		{[]string{"10.0.0.1", "0.0.0.0-255.255.255.255"}, false},
+	}
+	for _, test := range tests {
+		_, err := ParseTarget("test", test.ranges, nil, time.Minute, time.Second)
+		if (err == nil) != test.ok {
+			t.Errorf("%v: got error %v, want ok %t", test.ranges, err, test.ok)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		s        string
+		from, to string
+		ok       bool
+	}{
+		{"10.0.0.0/24", "10.0.0.1", "10.0.0.254", true},
+		{"10.0.0.77/24", "10.0.0.1", "10.0.0.254", true},
+		{"10.0.0.4/31", "10.0.0.4", "10.0.0.5", true},
+		{"10.0.0.4/32", "10.0.0.4", "10.0.0.4", true},
+		{" 10.0.1.10 - 10.0.1.50 ", "10.0.1.10", "10.0.1.50", true},
+		{"10.0.1.10", "10.0.1.10", "10.0.1.10", true},
+		{"10.0.1.50-10.0.1.10", "", "", false},
+		{"fd00::/64", "", "", false},
+		{"fd00::1-fd00::2", "", "", false},
+		{"10.0.0.0/33", "", "", false},
+		{"10.0.1", "", "", false},
+	}
+	for _, test := range tests {
+		r, err := parseRange(test.s)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, want ok %t", test.s, err, test.ok)
+			continue
+		}
+		if test.ok && (r.from.String() != test.from || r.to.String() != test.to) {
+			t.Errorf("%q: got %s-%s, want %s-%s", test.s, r.from, r.to, test.from, test.to)
+		}
+	}
+}
+
+func TestTargetAddrs(t *testing.T) {
+	target, err := ParseTarget("test", []string{"10.0.0.0/29", "10.0.0.5-10.0.0.9"},
+		[]string{"10.0.0.2", "10.0.0.8-10.0.0.20"}, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.1", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}
+	var got []string
+	for _, ip := range target.Addrs() {
+		got = append(got, ip.String())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got addresses %v, want %v", got, want)
+	}
+
+	contains := map[string]bool{
+		"10.0.0.1":        true,
+		"::ffff:10.0.0.1": true,
+		"10.0.0.7":        true,
+		"10.0.0.0":        false,
+		"10.0.0.2":        false,
+		"10.0.0.9":        false,
+		"10.0.1.1":        false,
+		"fd00::1":         false,
+	}
+	for s, want := range contains {
+		if got := target.Contains(netip.MustParseAddr(s)); got != want {
+			t.Errorf("Contains(%s) = %t, want %t", s, got, want)
+		}
+	}
+}
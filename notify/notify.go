@@ -0,0 +1,118 @@
+// Package notify delivers alerts to people and systems outside pva.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Message is an alert to be delivered.
+type Message struct {
+	Subject string
+	Body    string
+	// Fields describe what the alert is about, for receivers that process alerts rather than show them
+	Fields map[string]string
+	Time   time.Time
+}
+
+// Notifier delivers messages somewhere.
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// Multi delivers messages through each of its notifiers, even if some of them fail.
+type Multi []Notifier
+
+func (m Multi) Notify(msg Message) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Webhook posts messages as JSON to a URL.
+type Webhook struct {
+	URL    string
+	client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Webhook) Notify(msg Message) error {
+	body, err := json.Marshal(struct {
+		Subject string            `json:"subject"`
+		Body    string            `json:"body"`
+		Fields  map[string]string `json:"fields"`
+		Time    time.Time         `json:"time"`
+	}{msg.Subject, msg.Body, msg.Fields, msg.Time})
+	if err != nil {
+		return err
+	}
+
+	res, err := w.client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
+
+// SMTP mails messages to a list of recipients.
+type SMTP struct {
+	// Addr is the host and port of the mail server
+	Addr string
+	// Username and Password authenticate with the server if set, which it only accepts over TLS
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) Notify(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := strings.Cut(s.Addr, ":")
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + strings.Join(s.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + headerValue(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + msg.Time.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n") + "\r\n")
+	keys := make([]string, 0, len(msg.Fields))
+	for k := range msg.Fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if len(keys) > 0 {
+		b.WriteString("\r\n")
+	}
+	for _, k := range keys {
+		b.WriteString(k + ": " + msg.Fields[k] + "\r\n")
+	}
+
+	return smtp.SendMail(s.Addr, auth, s.From, s.To, []byte(b.String()))
+}
+
+// headerValue keeps a value from breaking out of its mail header.
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package notify
+
+import "errors"
+
+// Syslog writes messages to a syslog daemon, which isn't supported on this platform.
+type Syslog struct{}
+
+func NewSyslog(network, addr string) (*Syslog, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (s *Syslog) Notify(msg Message) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package notify
+
+import "log/syslog"
+
+// Syslog writes messages to a syslog daemon as warnings.
+type Syslog struct {
+	writer *syslog.Writer
+}
+
+// NewSyslog connects to the daemon at addr over network, or to the local one if both are empty.
+func NewSyslog(network, addr string) (*Syslog, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_WARNING|syslog.LOG_DAEMON, "pva")
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{writer: w}, nil
+}
+
+func (s *Syslog) Notify(msg Message) error {
+	return s.writer.Warning(headerValue(msg.Subject + ": " + msg.Body))
+}
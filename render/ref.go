@@ -0,0 +1,106 @@
+package render
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"html"
+	"regexp"
+	"strconv"
+)
+
+var refPattern = regexp.MustCompile(`^\[\[(vault|device|document):(\d+)]]|^\[\[password:(\d+)/(\d+)]]`)
+
+var kindRef = ast.NewNodeKind("Ref")
+
+type refNode struct {
+	ast.BaseInline
+	ref    Ref
+	source string
+}
+
+func (n *refNode) Kind() ast.NodeKind {
+	return kindRef
+}
+
+func (n *refNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Ref": n.source}, nil)
+}
+
+type refParser struct{}
+
+func (p refParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (p refParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	m := refPattern.FindSubmatch(line)
+	if m == nil {
+		return nil
+	}
+
+	node := &refNode{source: string(m[0])}
+	var err error
+	if m[1] != nil {
+		node.ref.Type = string(m[1])
+		node.ref.ID, err = strconv.Atoi(string(m[2]))
+	} else {
+		node.ref.Type = "password"
+		node.ref.VaultID, err = strconv.Atoi(string(m[3]))
+		if err == nil {
+			node.ref.ID, err = strconv.Atoi(string(m[4]))
+		}
+	}
+	// Out of range ids
+	if err != nil {
+		return nil
+	}
+
+	block.Advance(len(m[0]))
+	return node
+}
+
+type refRenderer struct {
+	resolve Resolver
+}
+
+func (r refRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindRef, r.render)
+}
+
+func (r refRenderer) render(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	node := n.(*refNode)
+	// Missing objects and ones the reader can't see look the same so their existence isn't leaked
+	title, url, ok := r.resolve(node.ref)
+	if !ok {
+		_, _ = w.WriteString(`<span class="pva-ref pva-ref-unavailable">` + html.EscapeString(node.source) +
+			"</span>")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString(`<a class="pva-ref" href="` + html.EscapeString(url) + `">` + html.EscapeString(title) +
+		"</a>")
+	return ast.WalkContinue, nil
+}
+
+type refExtension struct {
+	resolve Resolver
+}
+
+func newRefExtension(resolve Resolver) goldmark.Extender {
+	return refExtension{resolve}
+}
+
+func (e refExtension) Extend(m goldmark.Markdown) {
+	// Runs before the link parser, which also triggers on '['
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(refParser{}, 199)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(refRenderer{e.resolve}, 500)))
+}
@@ -0,0 +1,60 @@
+package render
+
+import (
+	"bytes"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"html"
+	"regexp"
+)
+
+// Ref is an internal link like [[device:12]] or [[password:3/7]]. VaultID is only set for passwords.
+type Ref struct {
+	Type    string
+	ID      int
+	VaultID int
+}
+
+// Resolver looks up the title and URL of a referenced object, returning false if it doesn't exist or the
+// reader isn't allowed to see it.
+type Resolver func(ref Ref) (title string, url string, ok bool)
+
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Task list checkboxes
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	// Syntax highlighting hints on code blocks
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^pva-ref( pva-ref-unavailable)?$`)).OnElements("a", "span")
+	return p
+}
+
+// Markdown renders GitHub flavored markdown, resolving internal links with resolve. Raw HTML is allowed
+// but sanitized along with everything else.
+func Markdown(source string, resolve Resolver) (string, error) {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM, newRefExtension(resolve)),
+		goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+	)
+
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return policy.Sanitize(buf.String()), nil
+}
+
+// Plain renders text as preformatted HTML.
+func Plain(source string) string {
+	return "<pre>" + html.EscapeString(source) + "</pre>"
+}
+
+// HTML sanitizes an HTML document.
+func HTML(source string) string {
+	return policy.Sanitize(source)
+}
@@ -21,6 +21,11 @@ func (lrw *loggingRw) WriteHeader(status int) {
 	lrw.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, for flushing event streams.
+func (lrw *loggingRw) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 var (
 	infoFmt      = lipgloss.NewStyle().Foreground(lipgloss.Color("27")).Render
 	okFmt        = lipgloss.NewStyle().Foreground(lipgloss.Color("120")).Render
@@ -65,11 +70,22 @@ func newRouter(env *handlers.Env) http.Handler {
 		r.Post("/revoke", env.Revoke)
 	})
 
+	// WebDAV methods aren't known to chi, so they're registered for the handler to receive them
+	for _, method := range []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"} {
+		chi.RegisterMethod(method)
+	}
+	r.Route(handlers.WebDAVPrefix, func(r chi.Router) {
+		r.Use(env.WebDAVAuthMiddleware)
+		r.HandleFunc("/", env.WebDAVHandler)
+		r.HandleFunc("/*", env.WebDAVHandler)
+	})
+
 	r.Route("/api", func(r chi.Router) {
 		r.Use(env.AuthMiddleware)
 
 		r.Get("/ping", pingHandler)
-		r.Get("/index", env.GetIndexHandler)
+		r.Get("/search", env.SearchHandler)
+		r.Get("/storage", env.StorageUsageHandler)
 
 		r.Route("/vaults", func(r chi.Router) {
 			r.Get("/", env.GetVaultsHandler)
@@ -77,10 +93,22 @@ func newRouter(env *handlers.Env) http.Handler {
 			r.Patch("/{id}", env.UpdateVaultHandler)
 			r.Delete("/{id}", env.DeleteVaultHandler)
 			r.Post("/{id}/share", env.ShareVaultHandler)
+			r.Post("/{id}/request", env.RequestVaultAccessHandler)
+			r.Post("/{id}/move", env.MoveVaultHandler)
 
 			r.Post("/{id}/new", env.NewPasswordHandler)
 			r.Patch("/{vaultId}/{passwordId}", env.UpdatePasswordHandler)
 			r.Delete("/{vaultId}/{passwordId}", env.DeletePasswordHandler)
+
+			r.Get("/{vaultId}/{passwordId}/checkouts", env.GetCheckoutsHandler)
+			r.Post("/{vaultId}/{passwordId}/checkout", env.CheckoutPasswordHandler)
+			r.Post("/{vaultId}/{passwordId}/checkin", env.CheckInPasswordHandler)
+		})
+
+		r.Route("/requests", func(r chi.Router) {
+			r.Get("/", env.GetAccessRequestsHandler)
+			r.Post("/{id}/approve", env.ApproveAccessRequestHandler)
+			r.Post("/{id}/deny", env.DenyAccessRequestHandler)
 		})
 
 		r.Route("/devices", func(r chi.Router) {
@@ -88,15 +116,78 @@ func newRouter(env *handlers.Env) http.Handler {
 			r.Post("/", env.NewDeviceHandler)
 			r.Put("/", env.UpdateDeviceHandler)
 			r.Delete("/", env.DeleteDeviceHandler)
+			r.Get("/stale", env.StaleDevicesHandler)
+			r.Get("/scan", env.ScanStatusHandler)
+			r.Post("/scan", env.ScanHandler)
+			r.Get("/scan/events", env.ScanEventsHandler)
+			r.Get("/{id}/history", env.DeviceHistoryHandler)
+		})
+
+		r.Route("/alerts", func(r chi.Router) {
+			r.Get("/", env.GetAlertsHandler)
+			r.Post("/{id}/ack", env.AcknowledgeAlertHandler)
 		})
 
 		r.Route("/documents", func(r chi.Router) {
-			r.Get("/", env.GetDocumentHandler)
+			r.Get("/", env.GetDocumentsHandler)
 			r.Post("/", env.NewDocumentHandler)
-			r.Put("/", env.UpdateDocumentHandler)
-			r.Delete("/", env.DeleteDocumentHandler)
+			r.Post("/export", env.ExportDocumentsHandler)
+			r.Get("/{id}", env.GetDocumentHandler)
+			r.Get("/{id}/rendered", env.RenderDocumentHandler)
+			r.Patch("/{id}", env.UpdateDocumentHandler)
+			r.Delete("/{id}", env.DeleteDocumentHandler)
+			r.Post("/{id}/share", env.ShareDocumentHandler)
+			r.Post("/{id}/move", env.MoveDocumentHandler)
+			r.Post("/{id}/attachments", env.UploadAttachmentHandler)
+			r.Get("/{id}/attachments/{attachmentId}", env.DownloadAttachmentHandler)
+			r.Delete("/{id}/attachments/{attachmentId}", env.DeleteAttachmentHandler)
+			r.Get("/{id}/revisions", env.GetRevisionsHandler)
+			r.Get("/{id}/revisions/{revisionId}", env.GetRevisionHandler)
+			r.Post("/{id}/revisions/{revisionId}/restore", env.RestoreRevisionHandler)
+			r.Get("/{id}/diff", env.DiffRevisionsHandler)
+		})
+
+		r.Route("/folders", func(r chi.Router) {
+			r.Get("/", env.GetRootFolderHandler)
+			r.Post("/", env.NewFolderHandler)
+			r.Get("/{id}", env.GetFolderHandler)
+			r.Patch("/{id}", env.RenameFolderHandler)
+			r.Delete("/{id}", env.DeleteFolderHandler)
+			r.Post("/{id}/move", env.MoveFolderHandler)
+			r.Get("/{id}/shares", env.GetFolderSharesHandler)
+			r.Put("/{id}/shares", env.SetFolderSharesHandler)
+		})
+
+		r.Route("/templates", func(r chi.Router) {
+			r.Get("/", env.GetTemplatesHandler)
+			r.Post("/", env.NewTemplateHandler)
+			r.Get("/{id}", env.GetTemplateHandler)
+			r.Delete("/{id}", env.DeleteTemplateHandler)
+			r.Post("/{id}/instantiate", env.InstantiateTemplateHandler)
+		})
+
+		r.Route("/tags", func(r chi.Router) {
+			r.Get("/", env.GetTagsHandler)
+			r.Put("/{type}/{id}", env.SetTagsHandler)
+		})
+
+		r.Route("/relationships", func(r chi.Router) {
+			r.Post("/", env.LinkHandler)
+			r.Delete("/{id}", env.UnlinkHandler)
+			r.Get("/{type}/{id}", env.GetRelatedHandler)
+		})
+
+		r.Route("/favorites", func(r chi.Router) {
+			r.Put("/{type}/{id}", env.AddFavoriteHandler)
+			r.Delete("/{type}/{id}", env.RemoveFavoriteHandler)
+		})
 
-			r.Post("/share", env.ShareDocumentHandler)
+		r.Route("/trash", func(r chi.Router) {
+			r.Get("/", env.GetTrashHandler)
+			r.Post("/vaults/{id}", env.RestoreVaultHandler)
+			r.Post("/vaults/{vaultId}/{passwordId}", env.RestorePasswordHandler)
+			r.Post("/devices/{id}", env.RestoreDeviceHandler)
+			r.Post("/documents/{id}", env.RestoreDocumentHandler)
 		})
 	})
 
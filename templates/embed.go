@@ -0,0 +1,23 @@
+package templates
+
+import (
+	"embed"
+	"io/fs"
+	"sync"
+)
+
+//go:embed builtin
+var builtin embed.FS
+
+var getBuiltin = sync.OnceValue(func() fs.FS {
+	f, err := fs.Sub(builtin, "builtin")
+	if err != nil {
+		panic(err)
+	}
+	return f
+})
+
+// Builtin holds the document templates shipped with the binary.
+func Builtin() fs.FS {
+	return getBuiltin()
+}